@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/schedule"
+	"github.com/ooojustin/pr-puller/pkg/slack"
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+// Post a digest of the stored pull requests which are waiting for review.
+func (prs *PrSlacker) sendDigest(digest utils.Digest, now time.Time) error {
+	channel := digest.Channel
+	if channel == "" {
+		channel = prs.cfg.SlackChannelID
+	}
+
+	waiting, err := prs.db.AwaitingReview(digest.Repositories)
+	if err != nil {
+		return err
+	}
+
+	if err := prs.slack.SendDigest(channel, waiting, now); err != nil {
+		return err
+	}
+	fmt.Printf("Sent digest %s to %s, listing %d pull requests.\n", digest.Name, channel, len(waiting))
+	return nil
+}
+
+// Print the digests in config, as they would be posted now.
+func digestCommand(args []string) {
+	cfg, ok := utils.GetConfig()
+	if !ok {
+		exitf(0, "Failed to load config.")
+	}
+
+	db := initializeDatabase(cfg)
+
+	if len(cfg.Digests) == 0 {
+		fmt.Println("No digests are configured.")
+		return
+	}
+
+	now := time.Now()
+	for _, digest := range cfg.Digests {
+		cron, err := schedule.ParseCron(digest.Schedule, digest.Timezone)
+		if err != nil {
+			fmt.Printf("%s: invalid schedule: %s\n\n", digest.Name, err)
+			continue
+		}
+
+		waiting, err := db.AwaitingReview(digest.Repositories)
+		if err != nil {
+			exitf(1, "Failed to load pull requests: %s\n", err)
+		}
+
+		fmt.Printf("%s (next at %s)\n", digest.Name, cron.Next(now).Format(TimeFormat))
+		fmt.Println(slack.FormatDigest(waiting, now))
+	}
+}
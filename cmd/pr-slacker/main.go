@@ -10,19 +10,52 @@ import (
 	"github.com/ooojustin/pr-puller/pkg/utils"
 )
 
+// Commands which can be passed as the first argument. Running without a command
+// monitors pull requests.
+var commands = map[string]func(args []string){
+	"run":      runCommand,
+	"digest":   digestCommand,
+	"discard":  discardCommand,
+	"export":   exportCommand,
+	"import":   importCommand,
+	"init":     migrateCommand,
+	"migrate":  migrateCommand,
+	"purge":    purgeCommand,
+	"release":  releaseCommand,
+	"outbox":   outboxCommand,
+	"rules":    rulesCommand,
+	"timeline": timelineCommand,
+}
+
 func main() {
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	command, ok := commands[name]
+	if !ok {
+		exitf(1, "Unknown command: %s\n", name)
+	}
+	command(args)
+}
+
+func runCommand(args []string) {
 	// Load config variables from file.
 	cfg, ok := utils.GetConfig()
 	if !ok {
 		exitf(0, "Failed to load config.")
 	}
 
-	// Initialize database connection.
-	db, ok := database.Initialize()
-	if !ok {
-		exitf(0, "Failed to initialize database client.")
+	switch cfg.SeedMode {
+	case "", SeedAuto, SeedAlways, SeedNever:
+	default:
+		exitf(1, "Unknown seed mode: %s\n", cfg.SeedMode)
 	}
 
+	// Initialize database connection.
+	db := initializeDatabase(cfg)
+
 	// Initialize slack client used to send messages.
 	slackClient, ok := slack.Initialize()
 	if !ok {
@@ -54,6 +87,28 @@ func main() {
 	prs.Run()
 }
 
+// Initialize the database connection, and make sure its schema is up to date.
+// Local backends are migrated automatically, while shared DynamoDB tables must be
+// migrated explicitly with the migrate command.
+func initializeDatabase(cfg *utils.Config) *database.Database {
+	db, ok := database.Initialize()
+	if !ok {
+		exitf(0, "Failed to initialize database client.")
+	}
+
+	if cfg.DatabaseBackend == database.BackendSQLite || cfg.DatabaseBackend == database.BackendMemory {
+		if err := db.Migrate(); err != nil {
+			exitf(1, "Failed to migrate database: %s\n", err)
+		}
+	} else if err := db.CheckSchemaVersion(); err == database.SchemaOutdatedError {
+		exitf(1, "Database schema is out of date. Run `pr-slacker migrate` to update it.\n")
+	} else if err != nil {
+		exitf(1, "Failed to check database schema version: %s\n", err)
+	}
+
+	return db
+}
+
 func exitf(code int, format string, a ...interface{}) {
 	fmt.Printf(format, a...)
 	os.Exit(code)
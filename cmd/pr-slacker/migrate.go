@@ -0,0 +1,17 @@
+package main
+
+import (
+	"github.com/ooojustin/pr-puller/pkg/database"
+)
+
+// Create any missing tables and indexes, and apply pending data migrations.
+func migrateCommand(args []string) {
+	db, ok := database.Initialize()
+	if !ok {
+		exitf(0, "Failed to initialize database client.")
+	}
+
+	if err := db.Migrate(); err != nil {
+		exitf(1, "Failed to migrate database: %s\n", err)
+	}
+}
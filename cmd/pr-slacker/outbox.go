@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/database"
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+// List notifications in the outbox. By default, only the dead-lettered ones which
+// gave up after failing repeatedly are listed.
+func outboxCommand(args []string) {
+	flags := flag.NewFlagSet("outbox", flag.ExitOnError)
+	status := flags.String("status", database.OutboxDead, "Status of the notifications to list: pending, sent, dead, or discarded.")
+	flags.Parse(args)
+
+	cfg, ok := utils.GetConfig()
+	if !ok {
+		exitf(0, "Failed to load config.")
+	}
+
+	db := initializeDatabase(cfg)
+
+	if breaker, err := db.GetBreaker(); err == nil && breaker.Tripped() {
+		fmt.Printf("Circuit breaker tripped %s. %s\n\n", breaker.TrippedAt.Local().Format(TimeFormat), breaker.Summary())
+	}
+
+	msgs, err := db.Store.ListOutboxMessages(*status)
+	if err != nil {
+		exitf(1, "Failed to list notifications: %s\n", err)
+	}
+
+	for _, msg := range msgs {
+		fmt.Printf("%-40s %-10s %-12s attempts: %d, queued %s\n",
+			msg.PK, msg.Channel, msg.Event, msg.Attempts, msg.Created.Local().Format(TimeFormat))
+		if msg.LastError != "" {
+			fmt.Printf("    %s\n", msg.LastError)
+		}
+	}
+	fmt.Printf("%d %s notification(s).\n", len(msgs), *status)
+}
+
+// Reset the circuit breaker, so the notifications it's holding are sent.
+func releaseCommand(args []string) {
+	cfg, ok := utils.GetConfig()
+	if !ok {
+		exitf(0, "Failed to load config.")
+	}
+
+	db := initializeDatabase(cfg)
+
+	breaker, err := db.GetBreaker()
+	if err != nil {
+		exitf(1, "Failed to load circuit breaker: %s\n", err)
+	}
+	if !breaker.Tripped() {
+		fmt.Println("The circuit breaker isn't tripped.")
+		return
+	}
+
+	if err := db.ReleaseBreaker(time.Now()); err != nil {
+		exitf(1, "Failed to release circuit breaker: %s\n", err)
+	}
+	fmt.Printf("Released %d held notification(s).\n", breaker.Held)
+}
+
+// Drop every pending notification, and reset the circuit breaker.
+func discardCommand(args []string) {
+	cfg, ok := utils.GetConfig()
+	if !ok {
+		exitf(0, "Failed to load config.")
+	}
+
+	db := initializeDatabase(cfg)
+
+	count, err := db.DiscardPending(time.Now())
+	if err != nil {
+		exitf(1, "Failed to discard notifications: %s\n", err)
+	}
+	fmt.Printf("Discarded %d pending notification(s).\n", count)
+}
@@ -2,10 +2,15 @@ package main
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/ooojustin/pr-puller/pkg/database"
 	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/leader"
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+	"github.com/ooojustin/pr-puller/pkg/metrics"
+	"github.com/ooojustin/pr-puller/pkg/schedule"
 	"github.com/ooojustin/pr-puller/pkg/slack"
 	"github.com/ooojustin/pr-puller/pkg/utils"
 )
@@ -16,19 +21,71 @@ const (
 )
 
 type PrSlacker struct {
-	cfg   *utils.Config
-	db    *database.Database
-	ghc   *pr_gh.GithubClient
-	slack *slack.Slack
+	cfg       *utils.Config
+	db        *database.Database
+	ghc       *pr_gh.GithubClient
+	slack     *slack.Slack
+	elector   *leader.Elector
+	scheduler *schedule.Scheduler
+	seeded    bool
+	mu        sync.Mutex
 }
 
 func (prs *PrSlacker) Run() {
-	prs.processPullRequests(true)
+	if prs.cfg.MetricsAddr != "" {
+		metrics.Serve(prs.cfg.MetricsAddr)
+	}
+
+	prs.db.Events.Subscribe(func(event *lifecycle.Event) {
+		metrics.LifecycleEvents.Add(event.Type, 1)
+	})
+
+	// Only the leader polls and notifies. Whenever this replica takes over, it starts
+	// with a full refresh, since the previous leader may have stopped a while ago. The
+	// first refresh into an empty store is silent, so it doesn't announce every open PR.
+	ttl := time.Duration(prs.cfg.LeaderLeaseSeconds) * time.Second
+	prs.elector = leader.NewElector(prs.db.Store, ttl)
+	prs.elector.OnElected = func() {
+		if prs.shouldSeed() {
+			prs.seedPullRequests()
+			return
+		}
+		prs.processPullRequests(true)
+	}
+	prs.elector.Start()
+
+	prs.scheduler = prs.newScheduler()
+
 	prs.startPullRequestTicker(3 * time.Minute)
+	prs.startDeliveryTicker(30 * time.Second)
+	prs.startScheduleTicker(time.Minute)
 	fmt.Scanln()
+	prs.elector.Stop()
 }
 
 func (prs *PrSlacker) processPullRequests(all bool) {
+	prs.mu.Lock()
+	defer prs.mu.Unlock()
+
+	pullRequests := prs.loadPullRequests(all)
+	pprr := prs.db.PutPullRequests(pullRequests)
+
+	fmt.Printf("Uploaded: %d, Updated: %d, Skipped: %d, Failed: %d, Queued: %d\n",
+		len(pprr.Uploaded), len(pprr.Updated), len(pprr.Skipped), len(pprr.Failed), len(pprr.Notify))
+
+	reminders, err := prs.db.QueueReminders(time.Now())
+	if err != nil {
+		fmt.Println("Failed to queue reminders:", err)
+	} else if len(reminders) > 0 {
+		fmt.Printf("Reminders: %d\n", len(reminders))
+	}
+
+	prs.deliverNotifications()
+}
+
+// Scrape open pull requests from Github, either every page of them or just the most
+// recent, along with recently closed ones.
+func (prs *PrSlacker) loadPullRequests(all bool) []*pr_gh.PullRequest {
 	var org string = prs.cfg.GithubOrganization
 	timeStr := time.Now().Format(TimeFormat)
 
@@ -43,13 +100,21 @@ func (prs *PrSlacker) processPullRequests(all bool) {
 		prs.ghc.GetPullRequests(nil, 1, org, true, &pullRequests)
 	}
 
-	fmt.Printf("Loaded %d PullRequests\n", len(pullRequests))
+	// Process first page of recently closed pull requests, to see tracked ones get merged or closed.
+	prs.ghc.GetClosedPullRequests(1, org, &pullRequests)
 
-	pprr := prs.db.PutPullRequests(pullRequests)
-	prs.slack.SendPullRequestMessages(pprr.Notify)
+	// Load details which aren't in search results, such as CI status, requested reviewers
+	// and reviews, from the pages of pull requests which changed or haven't been loaded
+	// in a while.
+	if details, err := prs.db.NeedsDetails(pullRequests, time.Now()); err != nil {
+		fmt.Println("Failed to check which pull requests need details:", err)
+	} else if len(details) > 0 {
+		prs.ghc.LoadPullRequestDetails(details, time.Now())
+		fmt.Printf("Loaded details of %d PullRequests\n", len(details))
+	}
 
-	fmt.Printf("Uploaded: %d, Updated: %d, Skipped: %d, Failed: %d, Notified: %d\n",
-		len(pprr.Uploaded), len(pprr.Updated), len(pprr.Skipped), len(pprr.Failed), len(pprr.Notify))
+	fmt.Printf("Loaded %d PullRequests\n", len(pullRequests))
+	return pullRequests
 }
 
 func (prs *PrSlacker) startPullRequestTicker(d time.Duration) {
@@ -59,7 +124,9 @@ func (prs *PrSlacker) startPullRequestTicker(d time.Duration) {
 		for {
 			select {
 			case <-ticker.C:
-				prs.processPullRequests(false)
+				if prs.elector.IsLeader() {
+					prs.processPullRequests(false)
+				}
 			case <-quit:
 				ticker.Stop()
 				return
@@ -67,3 +134,137 @@ func (prs *PrSlacker) startPullRequestTicker(d time.Duration) {
 		}
 	}()
 }
+
+// Retry failed notifications between refreshes, rather than waiting for the next one.
+func (prs *PrSlacker) startDeliveryTicker(d time.Duration) {
+	ticker := time.NewTicker(d)
+	go func() {
+		for range ticker.C {
+			if prs.elector.IsLeader() {
+				prs.mu.Lock()
+				prs.deliverNotifications()
+				prs.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Create the scheduler which runs the digests and review queues in config.
+func (prs *PrSlacker) newScheduler() *schedule.Scheduler {
+	scheduler := schedule.NewScheduler(prs.db.Store)
+	for _, digest := range prs.cfg.Digests {
+		digest := digest
+		cron, err := schedule.ParseCron(digest.Schedule, digest.Timezone)
+		if err != nil {
+			fmt.Printf("Failed to parse schedule of digest %s: %s\n", digest.Name, err)
+			continue
+		}
+
+		scheduler.Add("digest#"+digest.Name, cron, func(now time.Time) error {
+			return prs.sendDigest(digest, now)
+		})
+	}
+	for login, queue := range prs.cfg.ReviewQueues {
+		login, queue := login, queue
+		cron, err := schedule.ParseCron(queue.Schedule, queue.Timezone)
+		if err != nil {
+			fmt.Printf("Failed to parse review queue schedule of %s: %s\n", login, err)
+			continue
+		}
+
+		scheduler.Add("review_queue#"+login, cron, func(now time.Time) error {
+			return prs.sendReviewQueue(login, cron.Location(), now)
+		})
+	}
+
+	return scheduler
+}
+
+// Run scheduled jobs, such as digests, once their schedule fires.
+func (prs *PrSlacker) startScheduleTicker(d time.Duration) {
+	ticker := time.NewTicker(d)
+	go func() {
+		for range ticker.C {
+			if prs.elector.IsLeader() {
+				prs.mu.Lock()
+				prs.scheduler.RunDue(time.Now())
+				prs.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Send any queued notifications which are due, including retries of earlier failures.
+func (prs *PrSlacker) deliverNotifications() {
+	send := func(msg *database.OutboxMessage, pr *pr_gh.PullRequest) (string, string, error) {
+		if msg.Event == database.OutboxUpdateEvent {
+			return prs.slack.UpdatePullRequestMessage(pr.SlackChannel, pr.SlackTS, pr)
+		}
+		return prs.slack.SendPullRequestMessage(newNotification(msg, pr), pr)
+	}
+	sendBatch := func(destination string, msgs []*database.OutboxMessage, batchPRs []*pr_gh.PullRequest) (string, string, error) {
+		notifications := make([]*slack.Notification, len(msgs))
+		for idx, msg := range msgs {
+			notifications[idx] = newNotification(msg, batchPRs[idx])
+		}
+		return prs.slack.SendNotificationBatch(destination, notifications, batchPRs)
+	}
+
+	resp, err := prs.db.DeliverOutbox(send, sendBatch, time.Now())
+	if err != nil {
+		fmt.Println("Failed to load queued notifications:", err)
+		return
+	}
+	if resp.Tripped != nil {
+		prs.alertBreakerTripped(resp.Tripped)
+	}
+
+	for _, msg := range append(resp.Retry, resp.Dead...) {
+		fmt.Printf("Failed to send notification for %s (attempt %d): %s\n", msg.PK, msg.Attempts, msg.LastError)
+	}
+	for _, msg := range resp.Dead {
+		fmt.Printf("Gave up on notification %s. Run `pr-slacker outbox` to see failed notifications.\n", msg.ID)
+	}
+
+	metrics.NotificationsSent.Add(int64(len(resp.Sent)))
+	metrics.NotificationFailures.Add(int64(len(resp.Retry) + len(resp.Dead)))
+	metrics.NotificationsDead.Add(int64(len(resp.Dead)))
+
+	if total := len(resp.Sent) + len(resp.Retry) + len(resp.Dead) + len(resp.Held); total > 0 {
+		fmt.Printf("Sent: %d, Retrying: %d, Dead: %d, Held: %d\n",
+			len(resp.Sent), len(resp.Retry), len(resp.Dead), len(resp.Held))
+	}
+}
+
+// Describe an outbox message as a notification for Slack.
+func newNotification(msg *database.OutboxMessage, pr *pr_gh.PullRequest) *slack.Notification {
+	notification := &slack.Notification{
+		Destination:    msg.Channel,
+		Event:          msg.Event,
+		Mention:        msg.Mention,
+		Users:          msg.Users,
+		ThreadTS:       msg.ThreadTS,
+		Broadcast:      msg.Broadcast,
+		IdempotencyKey: msg.IdempotencyKey(),
+		Since:          msg.Created,
+	}
+	if review, ok := pr.Review(msg.Reviewer); ok && msg.Reviewer != "" {
+		notification.Review = review
+	}
+	return notification
+}
+
+// Tell the admin channel that the circuit breaker is holding notifications.
+func (prs *PrSlacker) alertBreakerTripped(breaker *database.Breaker) {
+	fmt.Println("Circuit breaker tripped:", breaker.Summary())
+
+	channel := prs.cfg.StormProtection.AdminChannel
+	if channel == "" {
+		channel = prs.cfg.SlackChannelID
+	}
+	msg := fmt.Sprintf("Notifications are on hold. %s\nRun `pr-slacker release` to send them, or `pr-slacker discard` to drop them.",
+		breaker.Summary())
+	if _, _, err := prs.slack.PostMessage(channel, msg, nil); err != nil {
+		fmt.Println("Failed to alert admin channel:", err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+// Delete merged or closed pull requests which are past the retention period, along
+// with their history.
+func purgeCommand(args []string) {
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "List what would be removed without deleting anything.")
+	flags.Parse(args)
+
+	cfg, ok := utils.GetConfig()
+	if !ok {
+		exitf(0, "Failed to load config.")
+	}
+
+	db := initializeDatabase(cfg)
+
+	candidates, err := db.FindPurgeable(time.Now())
+	if err != nil {
+		exitf(1, "Failed to find records to purge: %s\n", err)
+	}
+
+	for _, candidate := range candidates {
+		name := candidate.PK
+		if candidate.OutboxID != "" {
+			name = candidate.OutboxID
+		}
+		fmt.Printf("%-40s %-8s %s", name, candidate.State, candidate.Reason)
+		if !candidate.ExpiresAt.IsZero() {
+			fmt.Printf(" (expired %s)", candidate.ExpiresAt.Local().Format(TimeFormat))
+		}
+		fmt.Println()
+	}
+
+	if *dryRun {
+		fmt.Printf("Would purge %d record(s).\n", len(candidates))
+		return
+	}
+
+	purged, err := db.Purge(candidates)
+	fmt.Printf("Purged %d record(s).\n", purged)
+	if err != nil {
+		exitf(1, "Failed to purge: %s\n", err)
+	}
+}
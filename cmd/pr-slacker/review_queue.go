@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/database"
+)
+
+// Send a user their review queue. The first message of each day, in the user's
+// timezone, is posted, and later ones that day update it in place. Empty queues
+// aren't posted, but do update the day's message.
+func (prs *PrSlacker) sendReviewQueue(login string, loc *time.Location, now time.Time) error {
+	user, ok := prs.cfg.SlackUsers[login]
+	if !ok {
+		return fmt.Errorf("%s isn't listed in slack_users", login)
+	}
+
+	queue, err := prs.db.ReviewQueue(login)
+	if err != nil {
+		return err
+	}
+
+	var channel, ts string
+	ref, err := prs.db.GetReviewQueueMessage(login, user)
+	if err == nil && sameDay(ref.Posted, now, loc) {
+		channel, ts = ref.Channel, ref.Timestamp
+	} else if err != nil && err != database.ItemNotFoundError {
+		return err
+	} else if len(queue) == 0 {
+		return nil
+	}
+
+	channel, newTS, err := prs.slack.SendReviewQueue(user, channel, ts, queue, now)
+	if err != nil {
+		return err
+	}
+	if newTS == ts {
+		return nil
+	}
+	return prs.db.PutReviewQueueMessage(login, user, channel, newTS, now)
+}
+
+// Whether two times are on the same day in a timezone.
+func sameDay(a time.Time, b time.Time, loc *time.Location) bool {
+	a, b = a.In(loc), b.In(loc)
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
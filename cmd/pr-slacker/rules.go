@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+	"github.com/ooojustin/pr-puller/pkg/rules"
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+// Work with notification rules. The only subcommand is `test`, which evaluates the
+// rules in config against a stored pull request and explains which rule fired.
+func rulesCommand(args []string) {
+	if len(args) == 0 || args[0] != "test" {
+		exitf(1, "Usage: pr-slacker rules test [-event type] <pr_uid>\n")
+	}
+
+	flags := flag.NewFlagSet("rules test", flag.ExitOnError)
+	eventType := flags.String("event", lifecycle.Opened, "Type of lifecycle event to evaluate the rules against.")
+	flags.Parse(args[1:])
+	if flags.NArg() != 1 {
+		exitf(1, "Usage: pr-slacker rules test [-event type] <pr_uid>\n")
+	}
+
+	cfg, ok := utils.GetConfig()
+	if !ok {
+		exitf(0, "Failed to load config.")
+	}
+
+	db := initializeDatabase(cfg)
+
+	pr, err := db.Store.GetPullRequest(flags.Arg(0))
+	if err != nil {
+		exitf(1, "Failed to load %s: %s\n", flags.Arg(0), err)
+	}
+
+	event := &lifecycle.Event{
+		Type:      *eventType,
+		PK:        pr.PK,
+		Timestamp: time.Now().UTC(),
+		Previous:  pr,
+		Current:   pr,
+	}
+
+	if len(cfg.NotificationRules) == 0 {
+		fmt.Println("No notification rules are configured, so the default rules are used.")
+	}
+
+	explanations, result := rules.NewEngineFromConfig(cfg).Explain(event)
+	for _, explanation := range explanations {
+		outcome := "no match"
+		if explanation.Matched {
+			outcome = "fired"
+		}
+		fmt.Printf("%-20s %-9s %s\n", explanation.Rule, outcome, explanation.Reason)
+	}
+	fmt.Print(LineSeperator)
+
+	switch {
+	case result == nil:
+		fmt.Printf("No rule matched the %s event, so nothing would be sent.\n", *eventType)
+	case result.Suppressed:
+		fmt.Printf("Rule %s suppressed notifications for the %s event.\n", result.Rule, *eventType)
+	case len(result.Notifications) == 0:
+		fmt.Printf("Rule %s fired, but had nowhere to send notifications.\n", result.Rule)
+	default:
+		fmt.Printf("Rule %s fired, and would send:\n", result.Rule)
+		for _, notification := range result.Notifications {
+			fmt.Printf("  - a message to %s", notification.Destination)
+			if notification.Mention != "" {
+				fmt.Printf(", mentioning group %s", notification.Mention)
+			}
+			fmt.Println()
+		}
+	}
+	if result != nil && result.Important && !result.Suppressed {
+		fmt.Println("The event is important, so replies about it are also broadcast to the channel.")
+	}
+}
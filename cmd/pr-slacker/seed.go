@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/slack"
+)
+
+// Values of seed_mode, which decides whether the initial sync is silent.
+const (
+	// Seed only if no pull requests are stored yet. (default)
+	SeedAuto = "auto"
+	// Seed on the first sync after starting, even if pull requests are stored. Only those
+	// which aren't stored yet are seeded.
+	SeedAlways = "always"
+	// Never seed, so the initial sync notifies about everything it finds.
+	SeedNever = "never"
+)
+
+// Whether the full refresh after being elected should seed the store, rather than
+// notify about what it finds.
+func (prs *PrSlacker) shouldSeed() bool {
+	switch prs.cfg.SeedMode {
+	case SeedNever:
+		return false
+	case SeedAlways:
+		return !prs.seeded
+	}
+
+	empty, err := prs.db.IsEmpty()
+	if err != nil {
+		fmt.Println("Failed to check for stored pull requests:", err)
+		return false
+	}
+	return empty
+}
+
+// Store every open pull request which isn't stored yet without notifying anyone about
+// them, and report what was seeded. Stored pull requests, and later changes to the
+// seeded ones, are notified as usual.
+func (prs *PrSlacker) seedPullRequests() {
+	prs.mu.Lock()
+	defer prs.mu.Unlock()
+
+	pullRequests := prs.loadPullRequests(true)
+	pprr := prs.db.SeedPullRequests(pullRequests)
+	prs.seeded = true
+
+	fmt.Printf("Seeded %d open pull requests without notifying:\n", len(pprr.Seeded))
+	for _, pr := range pprr.Seeded {
+		fmt.Printf("  %-40s %s (%s old)\n", pr.PK, pr.Title, slack.FormatAge(time.Since(pr.Created)))
+	}
+	fmt.Printf("Uploaded: %d, Updated: %d, Skipped: %d, Failed: %d\n",
+		len(pprr.Uploaded), len(pprr.Updated), len(pprr.Skipped), len(pprr.Failed))
+}
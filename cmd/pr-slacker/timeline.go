@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+// Print the lifecycle history of a pull request, given its pr_uid. (ex: org#repo#123)
+func timelineCommand(args []string) {
+	if len(args) != 1 {
+		exitf(1, "Usage: pr-slacker timeline <pr_uid>\n")
+	}
+
+	cfg, ok := utils.GetConfig()
+	if !ok {
+		exitf(0, "Failed to load config.")
+	}
+
+	db := initializeDatabase(cfg)
+
+	timeline, err := db.GetTimeline(args[0])
+	if err != nil {
+		exitf(1, "Failed to load timeline: %s\n", err)
+	} else if len(timeline.Events) == 0 {
+		exitf(1, "No history found for %s.\n", args[0])
+	}
+
+	fmt.Print(LineSeperator)
+	for _, event := range timeline.Events {
+		fmt.Printf("%-28s %-18s", event.Timestamp.Local().Format(TimeFormat), event.Type)
+		if event.Old != "" || event.New != "" {
+			fmt.Printf(" %q -> %q", event.Old, event.New)
+		}
+		fmt.Println()
+	}
+	fmt.Print(LineSeperator)
+
+	if waited, ok := timeline.WaitedForReview(); ok {
+		fmt.Printf("Waited %s for review.\n", waited.Round(time.Second))
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ooojustin/pr-puller/pkg/database"
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+// Write all tracked state to a JSON Lines file, or stdout.
+func exportCommand(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "", "File to write to. (default: stdout)")
+	flags.Parse(args)
+
+	cfg, ok := utils.GetConfig()
+	if !ok {
+		exitf(0, "Failed to load config.")
+	}
+
+	db := initializeDatabase(cfg)
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			exitf(1, "Failed to create %s: %s\n", *output, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	stats, err := db.Export(w)
+	if err != nil {
+		exitf(1, "Failed to export: %s\n", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d pull request(s), %d history event(s), %d Slack message(s).\n",
+		stats.PullRequests, stats.History, stats.Messages)
+}
+
+// Read state written by the export command from a file, or stdin.
+func importCommand(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	input := flags.String("i", "", "File to read from. (default: stdin)")
+	policy := flags.String("conflict", database.ConflictSkip,
+		"What to do with records which already exist: skip, overwrite, or newest-wins.")
+	flags.Parse(args)
+
+	cfg, ok := utils.GetConfig()
+	if !ok {
+		exitf(0, "Failed to load config.")
+	}
+
+	db := initializeDatabase(cfg)
+
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			exitf(1, "Failed to open %s: %s\n", *input, err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	stats, err := db.Import(r, *policy)
+	fmt.Printf("Imported %d pull request(s), %d history event(s), %d Slack message(s). Skipped %d.\n",
+		stats.PullRequests, stats.History, stats.Messages, stats.Skipped)
+	if err != nil {
+		exitf(1, "Failed to import: %s\n", err)
+	}
+}
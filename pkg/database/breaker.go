@@ -0,0 +1,165 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Key the state of the circuit breaker is stored under, in the metadata table.
+const breakerKey string = "circuit_breaker"
+
+// Statuses of outbox messages which were dropped with `discard`.
+const OutboxDiscarded string = "discarded"
+
+// Breaker is the state of the circuit breaker, which stops every notification from
+// being sent when too many are due at once, such as after Github's markup changes
+// and every pull request looks new. Once it's tripped, notifications are held until
+// they're released or discarded.
+type Breaker struct {
+	TrippedAt time.Time      `json:"tripped_at"` // zero unless it's tripped
+	Reason    string         `json:"reason,omitempty"`
+	Held      int            `json:"held,omitempty"`
+	Events    map[string]int `json:"events,omitempty"` // held notifications by event type
+
+	// Notifications queued before the breaker was last released don't count towards
+	// the limits, so releasing it lets them all through.
+	ReleasedAt time.Time `json:"released_at"`
+}
+
+// Whether notifications are being held.
+func (breaker *Breaker) Tripped() bool {
+	return !breaker.TrippedAt.IsZero()
+}
+
+// Describe why the breaker tripped and what it's holding.
+// (ex: "Holding 312 notifications, since 312 were due at once (limit 50). opened: 300, approved: 12")
+func (breaker *Breaker) Summary() string {
+	var events []string
+	for event := range breaker.Events {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	for idx, event := range events {
+		events[idx] = fmt.Sprintf("%s: %d", event, breaker.Events[event])
+	}
+	return fmt.Sprintf("Holding %d notifications, since %s. %s", breaker.Held, breaker.Reason, strings.Join(events, ", "))
+}
+
+// Get the state of the circuit breaker.
+func (db *Database) GetBreaker() (*Breaker, error) {
+	value, err := db.Store.GetMeta(breakerKey)
+	if err == ItemNotFoundError {
+		return &Breaker{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var breaker Breaker
+	if err := json.Unmarshal([]byte(value), &breaker); err != nil {
+		return nil, err
+	}
+	return &breaker, nil
+}
+
+func (db *Database) putBreaker(breaker *Breaker) error {
+	value, err := json.Marshal(breaker)
+	if err != nil {
+		return err
+	}
+	return db.Store.PutMeta(breakerKey, string(value))
+}
+
+// Check whether sending the due deliveries would exceed the limits, and trip the
+// breaker if it would. Each delivery is a single message, even if it batches several
+// notifications. Updates of an existing message don't notify anyone, so they aren't
+// counted. Returns the tripped breaker, or nil if they can be sent.
+func (db *Database) checkLimits(breaker *Breaker, deliveries [][]*OutboxMessage, now time.Time) (*Breaker, error) {
+	var counted []*OutboxMessage
+	for _, batch := range deliveries {
+		if batch[0].Event == OutboxUpdateEvent {
+			continue
+		}
+		if batch[len(batch)-1].Created.After(breaker.ReleasedAt) {
+			counted = append(counted, batch[0])
+		}
+	}
+
+	var reason string
+	if limit := db.Storm.MaxPerCycle; limit > 0 && len(counted) > limit {
+		reason = fmt.Sprintf("%d were due at once (limit %d)", len(counted), limit)
+	} else if limit := db.Storm.MaxPerHour; limit > 0 && len(counted) > 0 {
+		sent, err := db.Store.ListSentOutboxMessages(now.Add(-time.Hour))
+		if err != nil {
+			return nil, err
+		}
+		var recent int
+		for _, msg := range sent {
+			if msg.Event == OutboxUpdateEvent {
+				continue
+			}
+			if msg.BatchedWith != "" && msg.BatchedWith != msg.ID {
+				// Sent in the same message as the first of its batch.
+				continue
+			}
+			if msg.Created.After(breaker.ReleasedAt) {
+				recent++
+			}
+		}
+		if recent+len(counted) > limit {
+			reason = fmt.Sprintf("%d would be sent within an hour (limit %d)", recent+len(counted), limit)
+		}
+	}
+	if reason == "" {
+		return nil, nil
+	}
+
+	tripped := &Breaker{TrippedAt: now.UTC(), Reason: reason, ReleasedAt: breaker.ReleasedAt}
+	if err := db.countHeld(tripped); err != nil {
+		return nil, err
+	}
+	if err := db.putBreaker(tripped); err != nil {
+		return nil, err
+	}
+	return tripped, nil
+}
+
+// Count the pending notifications a breaker is holding.
+func (db *Database) countHeld(breaker *Breaker) error {
+	pending, err := db.Store.ListOutboxMessages(OutboxPending)
+	if err != nil {
+		return err
+	}
+	breaker.Held = len(pending)
+	breaker.Events = make(map[string]int)
+	for _, msg := range pending {
+		breaker.Events[msg.Event]++
+	}
+	return nil
+}
+
+// Reset the circuit breaker, and let every notification it was holding be sent.
+func (db *Database) ReleaseBreaker(now time.Time) error {
+	return db.putBreaker(&Breaker{ReleasedAt: now.UTC()})
+}
+
+// Drop every pending notification, and reset the circuit breaker. Returns how many
+// notifications were dropped.
+func (db *Database) DiscardPending(now time.Time) (int, error) {
+	pending, err := db.Store.ListOutboxMessages(OutboxPending)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range pending {
+		msg.Status = OutboxDiscarded
+		msg.ExpiresAt = now.Add(outboxSentRetention).Unix()
+		if err := db.Store.PutOutboxMessage(msg); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(pending), db.putBreaker(&Breaker{ReleasedAt: now.UTC()})
+}
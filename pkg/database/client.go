@@ -1,15 +1,52 @@
 package database
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+	"github.com/ooojustin/pr-puller/pkg/rules"
+	"github.com/ooojustin/pr-puller/pkg/schedule"
 	"github.com/ooojustin/pr-puller/pkg/utils"
 )
 
 type Database struct {
-	DynamoDB *dynamodb.DynamoDB
+	Store Store
+
+	// How long merged or closed pull requests are kept. Zero keeps them forever.
+	Retention time.Duration
+
+	// Rules which decide which notifications are queued for each lifecycle event. If
+	// it's nil, the default rules post to NotifyChannel, if it's set.
+	Rules         *rules.Engine
+	NotifyChannel string
+
+	// Schedules for reminding people about pull requests waiting for review, and the
+	// Slack users to mention, keyed by Github username.
+	Reminders  []ReminderSchedule
+	SlackUsers map[string]string
+
+	// Members of Github teams, keyed by team name (ex: "org/backend"), since reviews
+	// can be requested from a team.
+	Teams map[string][]string
+
+	// When notifications can be sent to each channel or user. Outside of those hours,
+	// they're held in the outbox.
+	Schedules schedule.Schedules
+
+	// Limits on how many notifications can be sent at once, and within an hour.
+	Storm utils.StormProtection
+
+	// How long the details of an open pull request, which are loaded from its page,
+	// are trusted before they're loaded again.
+	DetailsRefresh time.Duration
+
+	// Lifecycle events are prepared here before the change they describe is stored,
+	// and published once it is. The notifier and history subscribe to it when it's
+	// first used.
+	Events    *lifecycle.Bus
+	subscribe sync.Once
 }
 
 func Initialize() (*Database, bool) {
@@ -18,18 +55,36 @@ func Initialize() (*Database, bool) {
 		return nil, false
 	}
 
-	creds := credentials.NewStaticCredentials(cfg.AwsAccessKeyID, cfg.AwsAccessKeySecret, "")
-	sess, err := session.NewSession(&aws.Config{
-		Credentials: creds,
-		Region:      aws.String(cfg.AwsRegion),
-	})
+	store, err := NewStore(cfg)
+	if err != nil {
+		fmt.Println("Failed to create database store:", err)
+		return nil, false
+	}
+
+	schedules, err := schedule.ParseSchedules(cfg.NotificationSchedules)
+	if err != nil {
+		fmt.Println("Failed to parse notification schedules:", err)
+		return nil, false
+	}
+
+	reminders, err := ParseReminderSchedules(cfg.ReminderSchedules)
 	if err != nil {
+		fmt.Println("Failed to parse reminder schedules:", err)
 		return nil, false
 	}
 
-	ddb := dynamodb.New(sess)
 	db := &Database{
-		DynamoDB: ddb,
+		Store:          store,
+		Retention:      time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		Rules:          rules.NewEngineFromConfig(cfg),
+		NotifyChannel:  cfg.SlackChannelID,
+		Reminders:      reminders,
+		SlackUsers:     cfg.SlackUsers,
+		Schedules:      schedules,
+		Teams:          cfg.GithubTeams,
+		Storm:          cfg.StormProtection,
+		DetailsRefresh: time.Duration(cfg.DetailsRefreshMinutes) * time.Minute,
+		Events:         lifecycle.NewBus(),
 	}
 
 	return db, true
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+)
+
+// Default for how long the details of an open pull request are trusted, before its
+// page is loaded again to notice commits, checks and review requests, which don't
+// change its search result.
+const defaultDetailsRefresh time.Duration = 30 * time.Minute
+
+// Most pull request pages which are loaded in a single refresh, so a large initial
+// sync doesn't hit Github's rate limits. The rest are loaded by later refreshes.
+const maxDetailsPerRefresh int = 50
+
+// Get the freshly scraped pull requests whose details should be loaded from their
+// page. Open pull requests which aren't stored yet, whose details were never loaded,
+// or whose search result changed since they were stored come first, followed by
+// those whose details are older than DetailsRefresh, oldest first.
+func (db *Database) NeedsDetails(prs []*pr_gh.PullRequest, now time.Time) ([]*pr_gh.PullRequest, error) {
+	pr_uids := make([]string, len(prs))
+	for idx, pr := range prs {
+		pr_uids[idx] = pr.PK
+	}
+	existingPRs, err := db.Store.BatchGetPullRequests(pr_uids)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := db.DetailsRefresh
+	if refresh <= 0 {
+		refresh = defaultDetailsRefresh
+	}
+
+	var changed, stale []*pr_gh.PullRequest
+	for _, pr := range prs {
+		if pr.State != pr_gh.StateOpen {
+			continue
+		}
+
+		existing := existingPRs[pr.PK]
+		switch {
+		case existing == nil || existing.DetailsLoaded.IsZero() || !sameSearchResult(existing, pr):
+			changed = append(changed, pr)
+		case now.Sub(existing.DetailsLoaded) >= refresh:
+			stale = append(stale, pr)
+		}
+	}
+
+	sort.SliceStable(stale, func(i, j int) bool {
+		return existingPRs[stale[i].PK].DetailsLoaded.Before(existingPRs[stale[j].PK].DetailsLoaded)
+	})
+
+	needed := append(changed, stale...)
+	if len(needed) > maxDetailsPerRefresh {
+		needed = needed[:maxDetailsPerRefresh]
+	}
+	return needed, nil
+}
+
+// Whether a pull request looks the same in search results as when it was stored.
+// A review decision which failed to load isn't a change.
+func sameSearchResult(stored *pr_gh.PullRequest, scraped *pr_gh.PullRequest) bool {
+	before, after := stored.SearchResult(), scraped.SearchResult()
+	if after.ReviewDecision == "" {
+		after.ReviewDecision = before.ReviewDecision
+	}
+	if after.ID == 0 {
+		after.ID = before.ID
+	}
+	if len(before.Labels) == 0 && len(after.Labels) == 0 {
+		before.Labels, after.Labels = nil, nil
+	}
+
+	beforeBytes, _ := json.Marshal(before)
+	afterBytes, _ := json.Marshal(after)
+	return string(beforeBytes) == string(afterBytes)
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"sort"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+)
+
+// Get the open pull requests which are waiting for review, oldest first. Drafts, and
+// pull requests which are approved or have changes requested, aren't waiting. If repositories are given, only pull requests
+// in matching repositories (names or glob patterns) are included. This only reads the
+// stored records, so it doesn't need Github.
+func (db *Database) AwaitingReview(repositories []string) ([]*pr_gh.PullRequest, error) {
+	prs, err := db.Store.QueryOpenPullRequests()
+	if err != nil {
+		return nil, err
+	}
+
+	var waiting []*pr_gh.PullRequest
+	for _, pr := range prs {
+		if pr.Draft || pr.ReviewDecision == pr_gh.ReviewApproved || pr.ReviewDecision == pr_gh.ReviewChangesRequested {
+			continue
+		}
+		if len(repositories) > 0 && !matchesAny(repositories, pr.Repository) {
+			continue
+		}
+		waiting = append(waiting, pr)
+	}
+
+	sort.SliceStable(waiting, func(i, j int) bool {
+		if !waiting[i].Created.Equal(waiting[j].Created) {
+			return waiting[i].Created.Before(waiting[j].Created)
+		}
+		return waiting[i].PK < waiting[j].PK
+	})
+	return waiting, nil
+}
@@ -0,0 +1,834 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+const pullRequestPK string = "pr_uid"
+const historySK string = "event_id"
+const leasePK string = "lease_name"
+const metaPK string = "meta_key"
+const messagePK string = "message_key"
+const outboxPK string = "outbox_id"
+
+// Sources of AWS credentials which can be selected in config.
+const (
+	AwsCredentialsStatic string = "static"
+	AwsCredentialsChain  string = "chain"
+)
+
+// Maximum number of keys accepted by a single BatchGetItem request.
+const dynamoBatchGetLimit int = 100
+
+// Maximum number of items accepted by a single BatchWriteItem request.
+const dynamoBatchWriteLimit int = 25
+
+// Most items read by each page of a Scan which filters out most of a table.
+const dynamoScanPageSize int64 = 100
+
+// Unprocessed batch items are retried up to dynamoBatchMaxRetries times, waiting
+// dynamoBatchBaseBackoff before the first retry and doubling the wait each time after.
+const dynamoBatchMaxRetries int = 5
+const dynamoBatchBaseBackoff time.Duration = 50 * time.Millisecond
+
+type DynamoStore struct {
+	DynamoDB *dynamodb.DynamoDB
+	tables   Tables
+}
+
+// Names of the tables used by the DynamoDB backend, unless configured otherwise.
+func DefaultDynamoTables() Tables {
+	return Tables{
+		PullRequests: "pull-requests",
+		History:      "pull-request-history",
+		Leases:       "pr-slacker-leases",
+		Meta:         "pr-slacker-meta",
+		Messages:     "pr-slacker-messages",
+		Outbox:       "pr-slacker-outbox",
+	}
+}
+
+func NewDynamoStore(cfg *utils.Config, tables Tables) (*DynamoStore, error) {
+	sess, err := newAwsSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &DynamoStore{
+		DynamoDB: dynamodb.New(sess),
+		tables:   tables,
+	}
+
+	return store, nil
+}
+
+// Create an AWS session using the credentials selected in config. Static keys are
+// used if they're selected, or if they're provided without selecting anything.
+// Otherwise the standard credential chain is used: environment variables, the shared
+// credentials/config files (optionally with a named profile), web identity tokens,
+// and finally ECS task or EC2 instance roles.
+func newAwsSession(cfg *utils.Config) (*session.Session, error) {
+	awsCfg := aws.Config{}
+	if cfg.AwsRegion != "" {
+		awsCfg.Region = aws.String(cfg.AwsRegion)
+	}
+	if cfg.DynamoDBEndpoint != "" {
+		// ex: http://localhost:8000 for DynamoDB Local
+		awsCfg.Endpoint = aws.String(cfg.DynamoDBEndpoint)
+	}
+
+	switch cfg.AwsCredentials {
+	case "":
+		if cfg.AwsAccessKeyID != "" || cfg.AwsAccessKeySecret != "" {
+			awsCfg.Credentials = credentials.NewStaticCredentials(cfg.AwsAccessKeyID, cfg.AwsAccessKeySecret, "")
+		}
+	case AwsCredentialsStatic:
+		awsCfg.Credentials = credentials.NewStaticCredentials(cfg.AwsAccessKeyID, cfg.AwsAccessKeySecret, "")
+	case AwsCredentialsChain:
+	default:
+		return nil, UnknownCredentialsError
+	}
+
+	return session.NewSessionWithOptions(session.Options{
+		Config:            awsCfg,
+		Profile:           cfg.AwsProfile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+}
+
+func (ds *DynamoStore) GetMeta(key string) (string, error) {
+	input := &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			metaPK: {S: aws.String(key)},
+		},
+		TableName: aws.String(ds.tables.Meta),
+	}
+
+	output, err := ds.DynamoDB.GetItem(input)
+	if err != nil {
+		fmt.Println("Failed to GetItem meta value:", err)
+		return "", err
+	}
+
+	value, ok := output.Item["value"]
+	if !ok {
+		return "", ItemNotFoundError
+	}
+	return aws.StringValue(value.S), nil
+}
+
+func (ds *DynamoStore) PutMeta(key string, value string) error {
+	input := &dynamodb.PutItemInput{
+		Item: map[string]*dynamodb.AttributeValue{
+			metaPK:  {S: aws.String(key)},
+			"value": {S: aws.String(value)},
+		},
+		TableName: aws.String(ds.tables.Meta),
+	}
+
+	if _, err := ds.DynamoDB.PutItem(input); err != nil {
+		fmt.Println("Failed to PutItem meta value:", err)
+		return err
+	}
+	return nil
+}
+
+func (ds *DynamoStore) PutPullRequest(pr *pr_gh.PullRequest) error {
+	av, err := dynamodbattribute.MarshalMap(pr)
+	if err != nil {
+		fmt.Println("Failed to marshal PullRequest:", err)
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(ds.tables.PullRequests),
+	}
+
+	_, err = ds.DynamoDB.PutItem(input)
+	if err != nil {
+		fmt.Println("Failed to PutItem PullRequest:", err)
+		return err
+	}
+
+	return nil
+}
+
+func (ds *DynamoStore) PutPullRequestIfVersion(pr *pr_gh.PullRequest, expectedVersion int, outbox ...*OutboxMessage) error {
+	av, err := dynamodbattribute.MarshalMap(pr)
+	if err != nil {
+		fmt.Println("Failed to marshal PullRequest:", err)
+		return err
+	}
+
+	var cond expression.ConditionBuilder
+	if expectedVersion == 0 {
+		// Either a new item, or one which was written before versioning was introduced.
+		cond = expression.AttributeNotExists(expression.Name(pullRequestPK)).
+			Or(expression.AttributeNotExists(expression.Name("version"))).
+			Or(expression.Name("version").Equal(expression.Value(0)))
+	} else {
+		cond = expression.Name("version").Equal(expression.Value(expectedVersion))
+	}
+
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return err
+	}
+
+	if len(outbox) > 0 {
+		return ds.transactPullRequest(av, expr, outbox)
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:                      av,
+		TableName:                 aws.String(ds.tables.PullRequests),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	_, err = ds.DynamoDB.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return VersionConflictError
+	} else if err != nil {
+		fmt.Println("Failed to PutItem PullRequest:", err)
+		return err
+	}
+
+	return nil
+}
+
+// Write a pull request conditionally, along with its outbox messages, in a single
+// transaction.
+func (ds *DynamoStore) transactPullRequest(av map[string]*dynamodb.AttributeValue, expr expression.Expression, outbox []*OutboxMessage) error {
+	items := []*dynamodb.TransactWriteItem{{
+		Put: &dynamodb.Put{
+			Item:                      av,
+			TableName:                 aws.String(ds.tables.PullRequests),
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		},
+	}}
+
+	for _, msg := range outbox {
+		msgAv, err := marshalOutboxMessage(msg)
+		if err != nil {
+			fmt.Println("Failed to marshal OutboxMessage:", err)
+			return err
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				Item:      msgAv,
+				TableName: aws.String(ds.tables.Outbox),
+			},
+		})
+	}
+
+	_, err := ds.DynamoDB.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if cerr, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		// Reasons are listed in the same order as the items, so the first is the pull request.
+		if len(cerr.CancellationReasons) > 0 &&
+			aws.StringValue(cerr.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+			return VersionConflictError
+		}
+	}
+	if err != nil {
+		fmt.Println("Failed to TransactWriteItems PullRequest:", err)
+		return err
+	}
+
+	return nil
+}
+
+func (ds *DynamoStore) GetPullRequest(pr_uid string) (*pr_gh.PullRequest, error) {
+	key := map[string]interface{}{pullRequestPK: pr_uid}
+
+	av, err := dynamodbattribute.MarshalMap(key)
+	if err != nil {
+		fmt.Println("Failed to marshal PullRequest key:", err)
+		return nil, err
+	}
+
+	input := &dynamodb.GetItemInput{
+		Key:       av,
+		TableName: aws.String(ds.tables.PullRequests),
+	}
+
+	var pr pr_gh.PullRequest
+
+	output, err := ds.DynamoDB.GetItem(input)
+	if err != nil {
+		fmt.Println("Failed to GetItem PullRequest:", err)
+		return nil, err
+	}
+
+	if len(output.Item) == 0 {
+		return nil, ItemNotFoundError
+	}
+
+	err = dynamodbattribute.UnmarshalMap(output.Item, &pr)
+	if err != nil {
+		fmt.Println("Failed to convert PullRequest output to object:", err)
+		return nil, err
+	}
+
+	return &pr, nil
+}
+
+func (ds *DynamoStore) BatchGetPullRequests(pr_uids []string) (map[string]*pr_gh.PullRequest, error) {
+	prs := make(map[string]*pr_gh.PullRequest)
+	for start := 0; start < len(pr_uids); start += dynamoBatchGetLimit {
+		end := start + dynamoBatchGetLimit
+		if end > len(pr_uids) {
+			end = len(pr_uids)
+		}
+
+		var keys []map[string]*dynamodb.AttributeValue
+		for _, pr_uid := range pr_uids[start:end] {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{
+				pullRequestPK: {S: aws.String(pr_uid)},
+			})
+		}
+
+		requestItems := map[string]*dynamodb.KeysAndAttributes{
+			ds.tables.PullRequests: {Keys: keys},
+		}
+
+		for attempt := 0; len(requestItems) > 0; attempt++ {
+			if attempt > 0 {
+				if attempt > dynamoBatchMaxRetries {
+					fmt.Println("Failed to BatchGetItem PullRequests: unprocessed keys remain after retries")
+					return nil, UnprocessedItemsError
+				}
+				time.Sleep(batchBackoff(attempt))
+			}
+
+			input := &dynamodb.BatchGetItemInput{RequestItems: requestItems}
+			output, err := ds.DynamoDB.BatchGetItem(input)
+			if err != nil {
+				fmt.Println("Failed to BatchGetItem PullRequests:", err)
+				return nil, err
+			}
+
+			for _, item := range output.Responses[ds.tables.PullRequests] {
+				var pr pr_gh.PullRequest
+				if err := dynamodbattribute.UnmarshalMap(item, &pr); err != nil {
+					fmt.Println("Failed to convert PullRequest output to object:", err)
+					return nil, err
+				}
+				prs[pr.PK] = &pr
+			}
+
+			requestItems = output.UnprocessedKeys
+		}
+	}
+	return prs, nil
+}
+
+// Execute BatchWriteItem requests against a single table, retrying unprocessed items
+// with exponential backoff. Returns the partition keys of any items that weren't written.
+func (ds *DynamoStore) batchWrite(table string, requests []*dynamodb.WriteRequest) []string {
+	requestItems := map[string][]*dynamodb.WriteRequest{table: requests}
+	for attempt := 0; len(requestItems[table]) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt > dynamoBatchMaxRetries {
+				fmt.Println("Failed to BatchWriteItem: unprocessed items remain after retries")
+				break
+			}
+			time.Sleep(batchBackoff(attempt))
+		}
+
+		input := &dynamodb.BatchWriteItemInput{RequestItems: requestItems}
+		output, err := ds.DynamoDB.BatchWriteItem(input)
+		if err != nil {
+			fmt.Println("Failed to BatchWriteItem:", err)
+			break
+		}
+
+		requestItems = output.UnprocessedItems
+		if requestItems == nil {
+			requestItems = map[string][]*dynamodb.WriteRequest{}
+		}
+	}
+
+	var failed []string
+	for _, request := range requestItems[table] {
+		if request.PutRequest != nil {
+			failed = append(failed, aws.StringValue(request.PutRequest.Item[pullRequestPK].S))
+		} else if request.DeleteRequest != nil {
+			failed = append(failed, aws.StringValue(request.DeleteRequest.Key[pullRequestPK].S))
+		}
+	}
+	return failed
+}
+
+// Delay before retrying unprocessed batch items, doubling with each attempt.
+func batchBackoff(attempt int) time.Duration {
+	return dynamoBatchBaseBackoff << uint(attempt-1)
+}
+
+func (ds *DynamoStore) QueryOpenPullRequests() ([]*pr_gh.PullRequest, error) {
+	keyCond := expression.Key("state").Equal(expression.Value(pr_gh.StateOpen))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(ds.tables.PullRequests),
+		IndexName:                 aws.String(stateIndex),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	var prs []*pr_gh.PullRequest
+	var unmarshalErr error
+	err = ds.DynamoDB.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var pagePRs []*pr_gh.PullRequest
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pagePRs); unmarshalErr != nil {
+			return false
+		}
+		prs = append(prs, pagePRs...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		fmt.Println("Failed to Query open PullRequests:", err)
+		return nil, err
+	}
+
+	return prs, nil
+}
+
+func (ds *DynamoStore) ScanPullRequests() ([]*pr_gh.PullRequest, error) {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(ds.tables.PullRequests),
+	}
+
+	var prs []*pr_gh.PullRequest
+	var unmarshalErr error
+	err := ds.DynamoDB.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var pagePRs []*pr_gh.PullRequest
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pagePRs); unmarshalErr != nil {
+			return false
+		}
+		prs = append(prs, pagePRs...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		fmt.Println("Failed to Scan PullRequests:", err)
+		return nil, err
+	}
+
+	return prs, nil
+}
+
+// Without a prefix, a single item is read. With one, pages are scanned until an item
+// which matches the filter is found.
+func (ds *DynamoStore) HasPullRequests(prefix string) (bool, error) {
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(ds.tables.PullRequests),
+		ProjectionExpression: aws.String(pullRequestPK),
+		Limit:                aws.Int64(1),
+	}
+	if prefix != "" {
+		filter := expression.Name(pullRequestPK).BeginsWith(prefix)
+		expr, err := expression.NewBuilder().WithFilter(filter).WithProjection(expression.NamesList(expression.Name(pullRequestPK))).Build()
+		if err != nil {
+			return false, err
+		}
+		input.FilterExpression = expr.Filter()
+		input.ProjectionExpression = expr.Projection()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+		input.Limit = aws.Int64(dynamoScanPageSize)
+	}
+
+	var found bool
+	err := ds.DynamoDB.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		found = len(page.Items) > 0
+		return !found
+	})
+	if err != nil {
+		fmt.Println("Failed to Scan PullRequests:", err)
+		return false, err
+	}
+	return found, nil
+}
+
+func (ds *DynamoStore) DeletePullRequest(pr_uid string) error {
+	input := &dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			pullRequestPK: {S: aws.String(pr_uid)},
+		},
+		TableName: aws.String(ds.tables.PullRequests),
+	}
+
+	if _, err := ds.DynamoDB.DeleteItem(input); err != nil {
+		fmt.Println("Failed to DeleteItem PullRequest:", err)
+		return err
+	}
+	return nil
+}
+
+func (ds *DynamoStore) PutHistoryEvent(event *HistoryEvent) error {
+	av, err := dynamodbattribute.MarshalMap(event)
+	if err != nil {
+		fmt.Println("Failed to marshal HistoryEvent:", err)
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(ds.tables.History),
+	}
+
+	_, err = ds.DynamoDB.PutItem(input)
+	if err != nil {
+		fmt.Println("Failed to PutItem HistoryEvent:", err)
+		return err
+	}
+
+	return nil
+}
+
+func (ds *DynamoStore) GetHistory(pr_uid string) ([]*HistoryEvent, error) {
+	keyCond := expression.Key(pullRequestPK).Equal(expression.Value(pr_uid))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(ds.tables.History),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ScanIndexForward:          aws.Bool(true),
+	}
+
+	var events []*HistoryEvent
+	var unmarshalErr error
+	err = ds.DynamoDB.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var pageEvents []*HistoryEvent
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageEvents); unmarshalErr != nil {
+			return false
+		}
+		events = append(events, pageEvents...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		fmt.Println("Failed to Query HistoryEvents:", err)
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (ds *DynamoStore) DeleteHistory(pr_uid string) error {
+	events, err := ds.GetHistory(pr_uid)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(events); start += dynamoBatchWriteLimit {
+		end := start + dynamoBatchWriteLimit
+		if end > len(events) {
+			end = len(events)
+		}
+
+		var requests []*dynamodb.WriteRequest
+		for _, event := range events[start:end] {
+			requests = append(requests, &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{Key: map[string]*dynamodb.AttributeValue{
+					pullRequestPK: {S: aws.String(event.PK)},
+					historySK:     {S: aws.String(event.EventID)},
+				}},
+			})
+		}
+
+		if failed := ds.batchWrite(ds.tables.History, requests); len(failed) > 0 {
+			return &BatchWriteError{PKs: failed}
+		}
+	}
+	return nil
+}
+
+func (ds *DynamoStore) ListHistoryPKs() ([]string, error) {
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(ds.tables.History),
+		ProjectionExpression: aws.String(pullRequestPK),
+	}
+
+	var pr_uids []string
+	seen := make(map[string]bool)
+	err := ds.DynamoDB.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			pr_uid := aws.StringValue(item[pullRequestPK].S)
+			if !seen[pr_uid] {
+				seen[pr_uid] = true
+				pr_uids = append(pr_uids, pr_uid)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		fmt.Println("Failed to Scan history keys:", err)
+		return nil, err
+	}
+
+	sort.Strings(pr_uids)
+	return pr_uids, nil
+}
+
+func (ds *DynamoStore) PutMessageRef(ref *MessageRef) error {
+	av, err := dynamodbattribute.MarshalMap(ref)
+	if err != nil {
+		fmt.Println("Failed to marshal MessageRef:", err)
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(ds.tables.Messages),
+	}
+
+	if _, err := ds.DynamoDB.PutItem(input); err != nil {
+		fmt.Println("Failed to PutItem MessageRef:", err)
+		return err
+	}
+	return nil
+}
+
+func (ds *DynamoStore) GetMessageRef(key string) (*MessageRef, error) {
+	input := &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			messagePK: {S: aws.String(key)},
+		},
+		TableName: aws.String(ds.tables.Messages),
+	}
+
+	output, err := ds.DynamoDB.GetItem(input)
+	if err != nil {
+		fmt.Println("Failed to GetItem MessageRef:", err)
+		return nil, err
+	}
+
+	if len(output.Item) == 0 {
+		return nil, ItemNotFoundError
+	}
+
+	var ref MessageRef
+	if err := dynamodbattribute.UnmarshalMap(output.Item, &ref); err != nil {
+		fmt.Println("Failed to convert MessageRef output to object:", err)
+		return nil, err
+	}
+	return &ref, nil
+}
+
+func (ds *DynamoStore) ListMessageRefs() ([]*MessageRef, error) {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(ds.tables.Messages),
+	}
+
+	var refs []*MessageRef
+	var unmarshalErr error
+	err := ds.DynamoDB.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var pageRefs []*MessageRef
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageRefs); unmarshalErr != nil {
+			return false
+		}
+		refs = append(refs, pageRefs...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		fmt.Println("Failed to Scan MessageRefs:", err)
+		return nil, err
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Key < refs[j].Key
+	})
+	return refs, nil
+}
+
+// Marshal an outbox message into a DynamoDB item. The sent time is the sort key of
+// the status index, so it's stored in a layout which sorts in chronological order.
+func marshalOutboxMessage(msg *OutboxMessage) (map[string]*dynamodb.AttributeValue, error) {
+	av, err := dynamodbattribute.MarshalMap(msg)
+	if err != nil {
+		return nil, err
+	}
+	av["sent"] = &dynamodb.AttributeValue{S: aws.String(msg.Sent.UTC().Format(historyTimeFormat))}
+	return av, nil
+}
+
+func (ds *DynamoStore) PutOutboxMessage(msg *OutboxMessage) error {
+	av, err := marshalOutboxMessage(msg)
+	if err != nil {
+		fmt.Println("Failed to marshal OutboxMessage:", err)
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(ds.tables.Outbox),
+	}
+	if _, err := ds.DynamoDB.PutItem(input); err != nil {
+		fmt.Println("Failed to PutItem OutboxMessage:", err)
+		return err
+	}
+	return nil
+}
+
+func (ds *DynamoStore) ListOutboxMessages(status string) ([]*OutboxMessage, error) {
+	keyCond := expression.Key("status").Equal(expression.Value(status))
+	return ds.queryOutboxMessages(keyCond)
+}
+
+// Sent times are stored in UTC by marshalOutboxMessage, in a layout which sorts by time.
+func (ds *DynamoStore) ListSentOutboxMessages(since time.Time) ([]*OutboxMessage, error) {
+	keyCond := expression.Key("status").Equal(expression.Value(OutboxSent)).
+		And(expression.Key("sent").GreaterThanEqual(expression.Value(since.UTC().Format(historyTimeFormat))))
+	return ds.queryOutboxMessages(keyCond)
+}
+
+func (ds *DynamoStore) queryOutboxMessages(keyCond expression.KeyConditionBuilder) ([]*OutboxMessage, error) {
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(ds.tables.Outbox),
+		IndexName:                 aws.String(outboxStatusIndex),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	var msgs []*OutboxMessage
+	var unmarshalErr error
+	err = ds.DynamoDB.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var pageMsgs []*OutboxMessage
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageMsgs); unmarshalErr != nil {
+			return false
+		}
+		msgs = append(msgs, pageMsgs...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		fmt.Println("Failed to Query OutboxMessages:", err)
+		return nil, err
+	}
+
+	sortOutboxMessages(msgs)
+	return msgs, nil
+}
+
+func (ds *DynamoStore) DeleteOutboxMessage(id string) error {
+	input := &dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			outboxPK: {S: aws.String(id)},
+		},
+		TableName: aws.String(ds.tables.Outbox),
+	}
+	if _, err := ds.DynamoDB.DeleteItem(input); err != nil {
+		fmt.Println("Failed to DeleteItem OutboxMessage:", err)
+		return err
+	}
+	return nil
+}
+
+func (ds *DynamoStore) AcquireLease(name string, owner string, ttl time.Duration) (*Lease, error) {
+	lease := newLease(name, owner, ttl)
+	av, err := dynamodbattribute.MarshalMap(lease)
+	if err != nil {
+		fmt.Println("Failed to marshal Lease:", err)
+		return nil, err
+	}
+
+	cond := expression.AttributeNotExists(expression.Name(leasePK)).
+		Or(expression.Name("owner").Equal(expression.Value(owner))).
+		Or(expression.Name("expires").LessThanEqual(expression.Value(lease.Heartbeat.UnixMilli())))
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:                      av,
+		TableName:                 aws.String(ds.tables.Leases),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	_, err = ds.DynamoDB.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil, LeaseHeldError
+	} else if err != nil {
+		fmt.Println("Failed to PutItem Lease:", err)
+		return nil, err
+	}
+
+	return lease, nil
+}
+
+func (ds *DynamoStore) ReleaseLease(name string, owner string) error {
+	cond := expression.Name("owner").Equal(expression.Value(owner))
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			leasePK: {S: aws.String(name)},
+		},
+		TableName:                 aws.String(ds.tables.Leases),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	_, err = ds.DynamoDB.DeleteItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		// Someone else already holds it, so there's nothing to release.
+		return nil
+	} else if err != nil {
+		fmt.Println("Failed to DeleteItem Lease:", err)
+		return err
+	}
+
+	return nil
+}
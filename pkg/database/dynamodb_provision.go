@@ -0,0 +1,263 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	repositoryIndex string = "repository-index"
+	stateIndex      string = "state-index"
+	creatorIndex    string = "creator-index"
+
+	// Outbox messages by status, ordered by when they were sent.
+	outboxStatusIndex string = "status-index"
+)
+
+// How often to check whether a new index has finished building.
+const dynamoIndexPollInterval time.Duration = 5 * time.Second
+
+type dynamoTable struct {
+	name    string
+	pk      string
+	sk      string
+	ttl     string
+	indexes []dynamoIndex
+}
+
+type dynamoIndex struct {
+	name string
+	pk   string
+	sk   string
+}
+
+// Every table used by the DynamoDB backend.
+func (ds *DynamoStore) tableDefinitions() []dynamoTable {
+	return []dynamoTable{
+		{
+			name: ds.tables.PullRequests,
+			pk:   pullRequestPK,
+			ttl:  "expires_at",
+			indexes: []dynamoIndex{
+				{name: repositoryIndex, pk: "repository", sk: "created"},
+				{name: stateIndex, pk: "state", sk: "created"},
+				{name: creatorIndex, pk: "creator", sk: "created"},
+			},
+		},
+		{name: ds.tables.History, pk: pullRequestPK, sk: historySK},
+		{name: ds.tables.Leases, pk: leasePK},
+		{name: ds.tables.Meta, pk: metaPK},
+		{name: ds.tables.Messages, pk: messagePK},
+		{
+			name: ds.tables.Outbox,
+			pk:   outboxPK,
+			ttl:  "expires_at",
+			indexes: []dynamoIndex{
+				{name: outboxStatusIndex, pk: "status", sk: "sent"},
+			},
+		},
+	}
+}
+
+func (ds *DynamoStore) Provision() error {
+	for _, table := range ds.tableDefinitions() {
+		if err := ds.provisionTable(table); err != nil {
+			fmt.Printf("Failed to provision table %s: %s\n", table.name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *DynamoStore) provisionTable(table dynamoTable) error {
+	output, err := ds.DynamoDB.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(table.name),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		if err := ds.createTable(table); err != nil {
+			return err
+		}
+		return ds.enableTTL(table)
+	} else if err != nil {
+		return err
+	}
+
+	// The table already exists, so only add the indexes it's missing.
+	existing := make(map[string]bool)
+	for _, gsi := range output.Table.GlobalSecondaryIndexes {
+		existing[aws.StringValue(gsi.IndexName)] = true
+	}
+
+	for _, index := range table.indexes {
+		if existing[index.name] {
+			continue
+		}
+		if err := ds.createIndex(table, index); err != nil {
+			return err
+		}
+	}
+
+	return ds.enableTTL(table)
+}
+
+// Let DynamoDB delete expired items on its own, if the table has a TTL attribute.
+func (ds *DynamoStore) enableTTL(table dynamoTable) error {
+	if table.ttl == "" {
+		return nil
+	}
+
+	output, err := ds.DynamoDB.DescribeTimeToLive(&dynamodb.DescribeTimeToLiveInput{
+		TableName: aws.String(table.name),
+	})
+	if err != nil {
+		return err
+	}
+
+	status := aws.StringValue(output.TimeToLiveDescription.TimeToLiveStatus)
+	if status == dynamodb.TimeToLiveStatusEnabled || status == dynamodb.TimeToLiveStatusEnabling {
+		return nil
+	}
+
+	fmt.Printf("Enabling TTL on %s for table %s.\n", table.ttl, table.name)
+	_, err = ds.DynamoDB.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(table.name),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(table.ttl),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	return err
+}
+
+func (ds *DynamoStore) createTable(table dynamoTable) error {
+	fmt.Printf("Creating table %s...\n", table.name)
+
+	attributes := []string{table.pk}
+	keySchema := []*dynamodb.KeySchemaElement{
+		{AttributeName: aws.String(table.pk), KeyType: aws.String(dynamodb.KeyTypeHash)},
+	}
+	if table.sk != "" {
+		attributes = append(attributes, table.sk)
+		keySchema = append(keySchema, &dynamodb.KeySchemaElement{
+			AttributeName: aws.String(table.sk), KeyType: aws.String(dynamodb.KeyTypeRange),
+		})
+	}
+
+	var indexes []*dynamodb.GlobalSecondaryIndex
+	for _, index := range table.indexes {
+		attributes = append(attributes, index.pk, index.sk)
+		indexes = append(indexes, index.definition())
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName:              aws.String(table.name),
+		AttributeDefinitions:   attributeDefinitions(attributes),
+		KeySchema:              keySchema,
+		GlobalSecondaryIndexes: indexes,
+		BillingMode:            aws.String(dynamodb.BillingModePayPerRequest),
+	}
+
+	if _, err := ds.DynamoDB.CreateTable(input); err != nil {
+		return err
+	}
+
+	describe := &dynamodb.DescribeTableInput{TableName: aws.String(table.name)}
+	if err := ds.DynamoDB.WaitUntilTableExists(describe); err != nil {
+		return err
+	}
+
+	for _, index := range table.indexes {
+		if err := ds.waitForIndex(table.name, index.name); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Created table %s.\n", table.name)
+	return nil
+}
+
+// DynamoDB only allows one index to be created per UpdateTable request.
+func (ds *DynamoStore) createIndex(table dynamoTable, index dynamoIndex) error {
+	fmt.Printf("Creating index %s on table %s...\n", index.name, table.name)
+
+	input := &dynamodb.UpdateTableInput{
+		TableName:            aws.String(table.name),
+		AttributeDefinitions: attributeDefinitions([]string{index.pk, index.sk}),
+		GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{
+			{Create: &dynamodb.CreateGlobalSecondaryIndexAction{
+				IndexName:  aws.String(index.name),
+				KeySchema:  index.definition().KeySchema,
+				Projection: index.definition().Projection,
+			}},
+		},
+	}
+
+	if _, err := ds.DynamoDB.UpdateTable(input); err != nil {
+		return err
+	}
+
+	if err := ds.waitForIndex(table.name, index.name); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created index %s on table %s.\n", index.name, table.name)
+	return nil
+}
+
+// Wait for an index to finish building. Creating an index on a table which already has
+// items requires backfilling it, which can take a while.
+func (ds *DynamoStore) waitForIndex(tableName string, indexName string) error {
+	for {
+		output, err := ds.DynamoDB.DescribeTable(&dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, gsi := range output.Table.GlobalSecondaryIndexes {
+			if aws.StringValue(gsi.IndexName) != indexName {
+				continue
+			}
+			if aws.StringValue(gsi.IndexStatus) == dynamodb.IndexStatusActive {
+				return nil
+			}
+		}
+
+		time.Sleep(dynamoIndexPollInterval)
+	}
+}
+
+func (index dynamoIndex) definition() *dynamodb.GlobalSecondaryIndex {
+	return &dynamodb.GlobalSecondaryIndex{
+		IndexName: aws.String(index.name),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String(index.pk), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			{AttributeName: aws.String(index.sk), KeyType: aws.String(dynamodb.KeyTypeRange)},
+		},
+		Projection: &dynamodb.Projection{
+			ProjectionType: aws.String(dynamodb.ProjectionTypeAll),
+		},
+	}
+}
+
+// Every key attribute is a string, and each may only be defined once.
+func attributeDefinitions(names []string) []*dynamodb.AttributeDefinition {
+	var definitions []*dynamodb.AttributeDefinition
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		definitions = append(definitions, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(name),
+			AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+		})
+	}
+	return definitions
+}
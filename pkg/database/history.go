@@ -0,0 +1,73 @@
+package database
+
+import (
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+)
+
+// Types of events recorded in the history of a pull request. Besides these, every
+// type of lifecycle event is recorded.
+const (
+	EventOpened           string = lifecycle.Opened
+	EventReadyForReview   string = lifecycle.ReadyForReview
+	EventReviewRequired   string = lifecycle.ReviewRequired
+	EventChangesRequested string = lifecycle.ChangesRequested
+	EventApproved         string = lifecycle.Approved
+	EventNotified         string = "notified"
+	EventSeeded           string = "seeded"
+	EventReminded         string = lifecycle.Reminded
+	EventEscalated        string = lifecycle.Escalated
+	EventMerged           string = lifecycle.Merged
+	EventClosed           string = lifecycle.Closed
+)
+
+// Timeline is the history of a single pull request.
+type Timeline struct {
+	PK     string
+	Events []*HistoryEvent
+}
+
+// Get the timeline of a pull request, oldest event first.
+func (db *Database) GetTimeline(pr_uid string) (*Timeline, error) {
+	events, err := db.Store.GetHistory(pr_uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Timeline{PK: pr_uid, Events: events}, nil
+}
+
+// Get the first event of a given type, or nil if it never happened.
+func (tl *Timeline) First(eventType string) *HistoryEvent {
+	for _, event := range tl.Events {
+		if event.Type == eventType {
+			return event
+		}
+	}
+	return nil
+}
+
+// How long the pull request waited for its first review, from the time it was opened
+// (or left draft) until it was first approved or had changes requested.
+// Returns false if it hasn't been reviewed yet.
+func (tl *Timeline) WaitedForReview() (time.Duration, bool) {
+	var ready, reviewed *HistoryEvent
+	for _, event := range tl.Events {
+		switch event.Type {
+		case EventOpened, EventReadyForReview:
+			if ready == nil && !(event.Type == EventOpened && event.Old == "draft") {
+				ready = event
+			}
+		case EventApproved, EventChangesRequested:
+			if ready != nil && reviewed == nil {
+				reviewed = event
+			}
+		}
+	}
+
+	if ready == nil || reviewed == nil {
+		return 0, false
+	}
+	return reviewed.Timestamp.Sub(ready.Timestamp), true
+}
@@ -0,0 +1,314 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+)
+
+// MemoryStore keeps everything in process memory. State is lost on exit, which makes
+// it useful for local runs and tests.
+type MemoryStore struct {
+	mu           sync.Mutex
+	pullRequests map[string]*pr_gh.PullRequest
+	history      map[string]map[string]*HistoryEvent
+	leases       map[string]*Lease
+	meta         map[string]string
+	messages     map[string]*MessageRef
+	outbox       map[string]*OutboxMessage
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		pullRequests: make(map[string]*pr_gh.PullRequest),
+		history:      make(map[string]map[string]*HistoryEvent),
+		leases:       make(map[string]*Lease),
+		meta:         make(map[string]string),
+		messages:     make(map[string]*MessageRef),
+		outbox:       make(map[string]*OutboxMessage),
+	}
+}
+
+func (ms *MemoryStore) Provision() error {
+	return nil
+}
+
+func (ms *MemoryStore) GetMeta(key string) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	value, ok := ms.meta[key]
+	if !ok {
+		return "", ItemNotFoundError
+	}
+	return value, nil
+}
+
+func (ms *MemoryStore) PutMeta(key string, value string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.meta[key] = value
+	return nil
+}
+
+func (ms *MemoryStore) GetPullRequest(pr_uid string) (*pr_gh.PullRequest, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	pr, ok := ms.pullRequests[pr_uid]
+	if !ok {
+		return nil, ItemNotFoundError
+	}
+	return copyPullRequest(pr), nil
+}
+
+func (ms *MemoryStore) PutPullRequest(pr *pr_gh.PullRequest) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.pullRequests[pr.PK] = copyPullRequest(pr)
+	return nil
+}
+
+func (ms *MemoryStore) PutPullRequestIfVersion(pr *pr_gh.PullRequest, expectedVersion int, outbox ...*OutboxMessage) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var version int
+	if existing, ok := ms.pullRequests[pr.PK]; ok {
+		version = existing.Version
+	}
+	if version != expectedVersion {
+		return VersionConflictError
+	}
+
+	ms.pullRequests[pr.PK] = copyPullRequest(pr)
+	for _, msg := range outbox {
+		cp := *msg
+		ms.outbox[msg.ID] = &cp
+	}
+	return nil
+}
+
+func (ms *MemoryStore) BatchGetPullRequests(pr_uids []string) (map[string]*pr_gh.PullRequest, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	prs := make(map[string]*pr_gh.PullRequest)
+	for _, pr_uid := range pr_uids {
+		if pr, ok := ms.pullRequests[pr_uid]; ok {
+			prs[pr_uid] = copyPullRequest(pr)
+		}
+	}
+	return prs, nil
+}
+
+func (ms *MemoryStore) QueryOpenPullRequests() ([]*pr_gh.PullRequest, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var prs []*pr_gh.PullRequest
+	for _, pr := range ms.pullRequests {
+		if pr.State == pr_gh.StateOpen {
+			prs = append(prs, copyPullRequest(pr))
+		}
+	}
+	return prs, nil
+}
+
+func (ms *MemoryStore) ScanPullRequests() ([]*pr_gh.PullRequest, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var prs []*pr_gh.PullRequest
+	for _, pr := range ms.pullRequests {
+		prs = append(prs, copyPullRequest(pr))
+	}
+	return prs, nil
+}
+
+func (ms *MemoryStore) HasPullRequests(prefix string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for pr_uid := range ms.pullRequests {
+		if strings.HasPrefix(pr_uid, prefix) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (ms *MemoryStore) DeletePullRequest(pr_uid string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.pullRequests, pr_uid)
+	return nil
+}
+
+func (ms *MemoryStore) PutHistoryEvent(event *HistoryEvent) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	events, ok := ms.history[event.PK]
+	if !ok {
+		events = make(map[string]*HistoryEvent)
+		ms.history[event.PK] = events
+	}
+
+	cp := *event
+	events[event.EventID] = &cp
+	return nil
+}
+
+func (ms *MemoryStore) GetHistory(pr_uid string) ([]*HistoryEvent, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var events []*HistoryEvent
+	for _, event := range ms.history[pr_uid] {
+		cp := *event
+		events = append(events, &cp)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].EventID < events[j].EventID
+	})
+	return events, nil
+}
+
+func (ms *MemoryStore) DeleteHistory(pr_uid string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.history, pr_uid)
+	return nil
+}
+
+func (ms *MemoryStore) ListHistoryPKs() ([]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var pr_uids []string
+	for pr_uid, events := range ms.history {
+		if len(events) > 0 {
+			pr_uids = append(pr_uids, pr_uid)
+		}
+	}
+	sort.Strings(pr_uids)
+	return pr_uids, nil
+}
+
+func (ms *MemoryStore) PutMessageRef(ref *MessageRef) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	cp := *ref
+	ms.messages[ref.Key] = &cp
+	return nil
+}
+
+func (ms *MemoryStore) GetMessageRef(key string) (*MessageRef, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ref, ok := ms.messages[key]
+	if !ok {
+		return nil, ItemNotFoundError
+	}
+	cp := *ref
+	return &cp, nil
+}
+
+func (ms *MemoryStore) ListMessageRefs() ([]*MessageRef, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var refs []*MessageRef
+	for _, ref := range ms.messages {
+		cp := *ref
+		refs = append(refs, &cp)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Key < refs[j].Key
+	})
+	return refs, nil
+}
+
+func (ms *MemoryStore) PutOutboxMessage(msg *OutboxMessage) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	cp := *msg
+	ms.outbox[msg.ID] = &cp
+	return nil
+}
+
+func (ms *MemoryStore) ListOutboxMessages(status string) ([]*OutboxMessage, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var msgs []*OutboxMessage
+	for _, msg := range ms.outbox {
+		if msg.Status == status {
+			cp := *msg
+			msgs = append(msgs, &cp)
+		}
+	}
+	sortOutboxMessages(msgs)
+	return msgs, nil
+}
+
+func (ms *MemoryStore) ListSentOutboxMessages(since time.Time) ([]*OutboxMessage, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var msgs []*OutboxMessage
+	for _, msg := range ms.outbox {
+		if msg.Status == OutboxSent && !msg.Sent.Before(since) {
+			cp := *msg
+			msgs = append(msgs, &cp)
+		}
+	}
+	sortOutboxMessages(msgs)
+	return msgs, nil
+}
+
+func (ms *MemoryStore) DeleteOutboxMessage(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.outbox, id)
+	return nil
+}
+
+func (ms *MemoryStore) AcquireLease(name string, owner string, ttl time.Duration) (*Lease, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	lease := newLease(name, owner, ttl)
+	if existing, ok := ms.leases[name]; ok {
+		if existing.Owner != owner && existing.Expires > lease.Heartbeat.UnixMilli() {
+			return nil, LeaseHeldError
+		}
+	}
+
+	cp := *lease
+	ms.leases[name] = &cp
+	return lease, nil
+}
+
+func (ms *MemoryStore) ReleaseLease(name string, owner string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if existing, ok := ms.leases[name]; ok && existing.Owner == owner {
+		delete(ms.leases, name)
+	}
+	return nil
+}
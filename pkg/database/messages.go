@@ -0,0 +1,68 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+)
+
+// Event of outbox messages which update the message announcing a pull request to show
+// its current state, rather than posting a new one.
+const OutboxUpdateEvent string = "update"
+
+// Lifecycle events which are posted as replies in the thread of the message announcing
+// a pull request, even if no rule posts about them.
+var threadEvents = map[string]bool{
+	lifecycle.Reviewed:         true,
+	lifecycle.NewCommits:       true,
+	lifecycle.CIFailed:         true,
+	lifecycle.ChangesRequested: true,
+	lifecycle.Approved:         true,
+	lifecycle.Merged:           true,
+	lifecycle.Closed:           true,
+}
+
+// Queue an update of the message announcing a pull request, if it has one. Only one
+// update is kept per write, since the update shows the pull request as it is when it's
+// delivered.
+func updateMessage(pr *pr_gh.PullRequest, now time.Time) *OutboxMessage {
+	if pr.SlackTS == "" {
+		return nil
+	}
+	return NewOutboxMessage(pr.PK, OutboxUpdateEvent, pr.Version, pr.SlackChannel, now)
+}
+
+// Record the message which announced a pull request on its record, if it's the first
+// message posted in a channel about it. Replies in a thread and direct messages, which
+// are posted in a different channel than their destination, aren't recorded.
+func (db *Database) recordMessage(msg *OutboxMessage, channel string, ts string) {
+	if msg.Event == OutboxUpdateEvent || msg.ThreadTS != "" || channel != msg.Channel {
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		pr, err := db.Store.GetPullRequest(msg.PK)
+		if err != nil {
+			fmt.Printf("Failed to record message for %s: %s\n", msg.PK, err)
+			return
+		}
+		if pr.SlackTS != "" {
+			return
+		}
+
+		expectedVersion := pr.Version
+		pr.SlackChannel = channel
+		pr.SlackTS = ts
+		pr.Version++
+
+		err = db.Store.PutPullRequestIfVersion(pr, expectedVersion)
+		if err == nil {
+			return
+		} else if err != VersionConflictError || attempt >= maxVersionConflictRetries {
+			fmt.Printf("Failed to record message for %s: %s\n", msg.PK, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+)
+
+const schemaVersionKey string = "schema_version"
+
+var (
+	SchemaOutdatedError error = errors.New("Database schema is out of date.")
+)
+
+// Migration updates existing records after the shape of what's stored changes.
+// Migrations must be safe to run more than once, in case one is interrupted.
+type Migration struct {
+	Version     int
+	Description string
+	Apply       func(store Store) error
+}
+
+// Every migration, in the order they're applied. Append new migrations to the end,
+// and never change the version of one which has been released.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "Set state on pull requests stored before it was tracked",
+		Apply:       backfillPullRequestState,
+	},
+}
+
+// The schema version which this build of the program expects.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Get the schema version the store was last migrated to, which is 0 if it never was.
+func (db *Database) SchemaVersion() (int, error) {
+	value, err := db.Store.GetMeta(schemaVersionKey)
+	if err == ItemNotFoundError {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// Returns SchemaOutdatedError if migrations need to be applied before use.
+func (db *Database) CheckSchemaVersion() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	} else if version < LatestSchemaVersion() {
+		return SchemaOutdatedError
+	}
+	return nil
+}
+
+// Create any missing tables and indexes, then apply every migration newer than the
+// store's schema version. The version is recorded after each migration succeeds.
+func (db *Database) Migrate() error {
+	if err := db.Store.Provision(); err != nil {
+		return err
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= version {
+			continue
+		}
+
+		fmt.Printf("Applying migration %d: %s\n", migration.Version, migration.Description)
+		if err := migration.Apply(db.Store); err != nil {
+			fmt.Printf("Failed to apply migration %d: %s\n", migration.Version, err)
+			return err
+		}
+
+		if err := db.Store.PutMeta(schemaVersionKey, strconv.Itoa(migration.Version)); err != nil {
+			return err
+		}
+		version = migration.Version
+	}
+
+	fmt.Printf("Database schema is at version %d.\n", version)
+	return nil
+}
+
+// Only open pull requests were ever scraped before state was tracked. Until the state
+// is set, those records are missing from the state index, and from QueryOpenPullRequests.
+func backfillPullRequestState(store Store) error {
+	prs, err := store.ScanPullRequests()
+	if err != nil {
+		return err
+	}
+
+	for _, pr := range prs {
+		if pr.State == "" {
+			pr.State = pr_gh.StateOpen
+			if err := store.PutPullRequest(pr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+)
+
+// Failed deliveries are retried after outboxBaseBackoff, doubling the wait after each
+// failure up to outboxMaxBackoff. A message which fails outboxMaxAttempts times is
+// dead-lettered, and isn't retried again.
+const outboxBaseBackoff time.Duration = 30 * time.Second
+const outboxMaxBackoff time.Duration = 30 * time.Minute
+const outboxMaxAttempts int = 10
+
+// How long sent messages are kept before DynamoDB deletes them.
+const outboxSentRetention time.Duration = 7 * 24 * time.Hour
+
+var (
+	OutboxPullRequestMissingError error = errors.New("Pull request no longer exists.")
+)
+
+// OutboxSender delivers a notification about a pull request, returning the channel
+// and timestamp of the message once Slack has accepted it. It's given the message's
+// idempotency key, and should return the existing message instead of posting a new
+// one if a message with the same key was already posted.
+type OutboxSender func(msg *OutboxMessage, pr *pr_gh.PullRequest) (string, string, error)
+
+// OutboxBatchSender delivers several notifications to one destination as a single
+// message, given the pull request each one is about. The batch is identified by the
+// idempotency key of its first message, which is otherwise handled like OutboxSender's.
+type OutboxBatchSender func(destination string, msgs []*OutboxMessage, prs []*pr_gh.PullRequest) (string, string, error)
+
+type DeliverOutboxResponse struct {
+	Sent  []*OutboxMessage
+	Retry []*OutboxMessage
+	Dead  []*OutboxMessage
+	Held  []*OutboxMessage
+
+	// Halted is set when the circuit breaker is holding every notification, and
+	// Tripped is set when this delivery is what tripped it.
+	Halted  bool
+	Tripped *Breaker
+}
+
+// Attempt to deliver every pending outbox message which is due. Messages are only
+// marked as sent after send succeeds; failures are rescheduled with backoff, or
+// dead-lettered once they've failed too many times. Messages for a destination which
+// is outside its working hours are held until its next working window starts, and
+// then sent to it as a single message with sendBatch, if it's set and they can be
+// batched (see batchHeld). Nothing is sent
+// while the circuit breaker is tripped, and it trips if more messages are due than
+// the limits allow, where a batch counts as one message.
+func (db *Database) DeliverOutbox(send OutboxSender, sendBatch OutboxBatchSender, now time.Time) (*DeliverOutboxResponse, error) {
+	msgs, err := db.Store.ListOutboxMessages(OutboxPending)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &DeliverOutboxResponse{}
+	var due []*OutboxMessage
+	for _, msg := range msgs {
+		if msg.NextAttempt.After(now) {
+			continue
+		}
+
+		// Editing a message doesn't notify anyone, so updates aren't held.
+		if next, ok := db.nextWindow(msg.Channel, now); ok && next.After(now) && msg.Event != OutboxUpdateEvent {
+			msg.NextAttempt = next.UTC()
+			msg.Held = true
+			if err := db.Store.PutOutboxMessage(msg); err != nil {
+				fmt.Printf("Failed to hold notification %s: %s\n", msg.ID, err)
+			}
+			response.Held = append(response.Held, msg)
+			continue
+		}
+
+		due = append(due, msg)
+	}
+
+	deliveries := db.batchHeld(due, sendBatch != nil)
+
+	breaker, err := db.GetBreaker()
+	if err != nil {
+		return nil, err
+	}
+	if breaker.Tripped() {
+		response.Halted = true
+		return response, nil
+	}
+	if tripped, err := db.checkLimits(breaker, deliveries, now); err != nil {
+		return nil, err
+	} else if tripped != nil {
+		response.Halted = true
+		response.Tripped = tripped
+		return response, nil
+	}
+
+	for _, batch := range deliveries {
+		if len(batch) == 1 {
+			db.deliver(batch[0], send, now, response)
+		} else {
+			db.deliverBatch(batch, sendBatch, now, response)
+		}
+	}
+
+	return response, nil
+}
+
+// Group the messages which are due into deliveries. Messages which were held for a
+// destination during quiet hours are delivered together, if batching is enabled, and
+// every other message is delivered on its own. Replies in a thread are never batched,
+// and neither are the notifications of a pull request which hasn't been announced,
+// since any of them may become the message which is later updated and replied to.
+func (db *Database) batchHeld(due []*OutboxMessage, batching bool) [][]*OutboxMessage {
+	var deliveries [][]*OutboxMessage
+	batches := make(map[string]int)
+	for _, msg := range due {
+		if !batching || !msg.Held || !db.canBatch(msg) {
+			deliveries = append(deliveries, []*OutboxMessage{msg})
+			continue
+		}
+		if idx, ok := batches[msg.Channel]; ok {
+			deliveries[idx] = append(deliveries[idx], msg)
+			continue
+		}
+		batches[msg.Channel] = len(deliveries)
+		deliveries = append(deliveries, []*OutboxMessage{msg})
+	}
+	return deliveries
+}
+
+// Whether a held message can be sent as part of a batch.
+func (db *Database) canBatch(msg *OutboxMessage) bool {
+	if msg.ThreadTS != "" || msg.Event == OutboxUpdateEvent {
+		return false
+	}
+	pr, err := db.Store.GetPullRequest(msg.PK)
+	return err == nil && pr.SlackTS != ""
+}
+
+// Deliver a single message.
+func (db *Database) deliver(msg *OutboxMessage, send OutboxSender, now time.Time, response *DeliverOutboxResponse) {
+	if ref, ok := db.sentRef(msg); ok {
+		db.recordMessage(msg, ref.Channel, ref.Timestamp)
+		db.markOutboxMessageSent(msg, now)
+		response.Sent = append(response.Sent, msg)
+		return
+	}
+
+	pr, err := db.getOutboxPullRequest(msg)
+	if err == nil {
+		var channel, ts string
+		channel, ts, err = send(msg, pr)
+		if err == nil {
+			db.recordSent(msg, channel, ts, now)
+			db.markOutboxMessageSent(msg, now)
+			response.Sent = append(response.Sent, msg)
+			return
+		}
+	}
+	db.failDelivery(msg, err, now, response)
+}
+
+// Deliver messages to one destination as a single message. Messages whose pull
+// request no longer exists are left out, and fail on their own.
+func (db *Database) deliverBatch(batch []*OutboxMessage, sendBatch OutboxBatchSender, now time.Time, response *DeliverOutboxResponse) {
+	var msgs []*OutboxMessage
+	var prs []*pr_gh.PullRequest
+	for _, msg := range batch {
+		pr, err := db.getOutboxPullRequest(msg)
+		if err != nil {
+			db.failDelivery(msg, err, now, response)
+			continue
+		}
+		msgs = append(msgs, msg)
+		prs = append(prs, pr)
+	}
+	if len(msgs) == 0 {
+		return
+	}
+
+	// A previous attempt may have been accepted without every message being marked
+	// as sent.
+	if _, ok := db.sentRef(msgs[0]); !ok {
+		channel, ts, err := sendBatch(msgs[0].Channel, msgs, prs)
+		if err != nil {
+			for _, msg := range msgs {
+				db.failDelivery(msg, err, now, response)
+			}
+			return
+		}
+		for _, msg := range msgs {
+			msg.BatchedWith = msgs[0].ID
+			db.recordSent(msg, channel, ts, now)
+		}
+	}
+
+	for _, msg := range msgs {
+		msg.BatchedWith = msgs[0].ID
+		db.markOutboxMessageSent(msg, now)
+		response.Sent = append(response.Sent, msg)
+	}
+}
+
+// Get the reference to the message Slack accepted for an outbox message, if it was
+// recorded.
+func (db *Database) sentRef(msg *OutboxMessage) (*MessageRef, bool) {
+	ref, err := db.Store.GetMessageRef(messageRefKey(msg.PK, msg.Event, msg.Channel))
+	if err != nil || ref.IdempotencyKey != msg.IdempotencyKey() {
+		return nil, false
+	}
+	return ref, true
+}
+
+// Get the pull request an outbox message is about.
+func (db *Database) getOutboxPullRequest(msg *OutboxMessage) (*pr_gh.PullRequest, error) {
+	pr, err := db.Store.GetPullRequest(msg.PK)
+	if err == ItemNotFoundError {
+		// Retrying won't bring it back.
+		msg.Attempts = outboxMaxAttempts - 1
+		return nil, OutboxPullRequestMissingError
+	}
+	return pr, err
+}
+
+// Reschedule a message which failed to be delivered with backoff, or dead-letter it
+// once it's failed too many times.
+func (db *Database) failDelivery(msg *OutboxMessage, err error, now time.Time, response *DeliverOutboxResponse) {
+	msg.Attempts++
+	msg.LastError = err.Error()
+	if msg.Attempts >= outboxMaxAttempts {
+		msg.Status = OutboxDead
+		response.Dead = append(response.Dead, msg)
+	} else {
+		msg.NextAttempt = now.Add(outboxBackoff(msg.Attempts)).UTC()
+		response.Retry = append(response.Retry, msg)
+	}
+
+	if err := db.Store.PutOutboxMessage(msg); err != nil {
+		fmt.Printf("Failed to reschedule notification %s: %s\n", msg.ID, err)
+	}
+}
+
+// Get the time notifications can next be sent to a destination, which is now if it's
+// within its working hours. Returns false if the destination has no schedule, or no
+// working days coming up, in which case notifications are sent right away.
+func (db *Database) nextWindow(destination string, now time.Time) (time.Time, bool) {
+	hours := db.Schedules.For(destination)
+	if hours == nil {
+		return time.Time{}, false
+	}
+	return hours.Next(now)
+}
+
+// Record that Slack accepted a message. The reference to the posted message, which
+// carries the delivered idempotency key, is written before the outbox is updated.
+// Updates of an existing message don't post anything, so nothing is recorded for them,
+// and a batch is only sent about pull requests which were already announced.
+func (db *Database) recordSent(msg *OutboxMessage, channel string, ts string, now time.Time) {
+	if msg.Event == OutboxUpdateEvent {
+		return
+	}
+
+	// Direct messages are posted in a different channel than their destination, so
+	// the reference is keyed by the destination, where it will be looked up.
+	ref := NewMessageRef(msg.PK, msg.Event, channel, ts, now)
+	ref.Key = messageRefKey(msg.PK, msg.Event, msg.Channel)
+	ref.IdempotencyKey = msg.IdempotencyKey()
+	if err := db.Store.PutMessageRef(ref); err != nil {
+		fmt.Printf("Failed to record message for %s: %s\n", msg.PK, err)
+	}
+	if msg.BatchedWith == "" {
+		db.recordMessage(msg, channel, ts)
+	}
+}
+
+// Mark a message which Slack accepted as sent.
+func (db *Database) markOutboxMessageSent(msg *OutboxMessage, now time.Time) {
+	msg.Status = OutboxSent
+	msg.Attempts++
+	msg.LastError = ""
+	msg.Sent = now.UTC()
+	msg.ExpiresAt = now.Add(outboxSentRetention).Unix()
+	if err := db.Store.PutOutboxMessage(msg); err != nil {
+		fmt.Printf("Failed to mark notification %s as sent: %s\n", msg.ID, err)
+	}
+}
+
+// How long to wait before the next attempt, after a number of failed attempts.
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxBaseBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > outboxMaxBackoff {
+		backoff = outboxMaxBackoff
+	}
+	return backoff
+}
+
+// Order outbox messages oldest first.
+func sortOutboxMessages(msgs []*OutboxMessage) {
+	sort.Slice(msgs, func(i, j int) bool {
+		if !msgs[i].Created.Equal(msgs[j].Created) {
+			return msgs[i].Created.Before(msgs[j].Created)
+		}
+		return msgs[i].ID < msgs[j].ID
+	})
+}
@@ -0,0 +1,251 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+)
+
+// PrefixedStore prepends a prefix to every key written to another store, and only
+// reads back keys with that prefix. Environments which share tables each see
+// their own records, as long as they use different prefixes.
+type PrefixedStore struct {
+	store  Store
+	prefix string
+}
+
+func NewPrefixedStore(store Store, prefix string) *PrefixedStore {
+	return &PrefixedStore{store: store, prefix: prefix}
+}
+
+func (ps *PrefixedStore) key(key string) string {
+	return ps.prefix + key
+}
+
+// Copy a pull request with its key prefixed, so the caller's copy is unchanged.
+func (ps *PrefixedStore) wrap(pr *pr_gh.PullRequest) *pr_gh.PullRequest {
+	cp := copyPullRequest(pr)
+	cp.PK = ps.key(pr.PK)
+	return cp
+}
+
+// Strip the prefix from a pull request read from the underlying store.
+func (ps *PrefixedStore) unwrap(pr *pr_gh.PullRequest) *pr_gh.PullRequest {
+	pr.PK = strings.TrimPrefix(pr.PK, ps.prefix)
+	return pr
+}
+
+// Keep only the pull requests which belong to this prefix.
+func (ps *PrefixedStore) filter(prs []*pr_gh.PullRequest) []*pr_gh.PullRequest {
+	var filtered []*pr_gh.PullRequest
+	for _, pr := range prs {
+		if strings.HasPrefix(pr.PK, ps.prefix) {
+			filtered = append(filtered, ps.unwrap(pr))
+		}
+	}
+	return filtered
+}
+
+func (ps *PrefixedStore) Provision() error {
+	return ps.store.Provision()
+}
+
+func (ps *PrefixedStore) GetMeta(key string) (string, error) {
+	return ps.store.GetMeta(ps.key(key))
+}
+
+func (ps *PrefixedStore) PutMeta(key string, value string) error {
+	return ps.store.PutMeta(ps.key(key), value)
+}
+
+func (ps *PrefixedStore) GetPullRequest(pr_uid string) (*pr_gh.PullRequest, error) {
+	pr, err := ps.store.GetPullRequest(ps.key(pr_uid))
+	if err != nil {
+		return nil, err
+	}
+	return ps.unwrap(pr), nil
+}
+
+func (ps *PrefixedStore) PutPullRequest(pr *pr_gh.PullRequest) error {
+	return ps.store.PutPullRequest(ps.wrap(pr))
+}
+
+func (ps *PrefixedStore) PutPullRequestIfVersion(pr *pr_gh.PullRequest, expectedVersion int, outbox ...*OutboxMessage) error {
+	wrapped := make([]*OutboxMessage, len(outbox))
+	for idx, msg := range outbox {
+		wrapped[idx] = ps.wrapOutboxMessage(msg)
+	}
+	return ps.store.PutPullRequestIfVersion(ps.wrap(pr), expectedVersion, wrapped...)
+}
+
+func (ps *PrefixedStore) BatchGetPullRequests(pr_uids []string) (map[string]*pr_gh.PullRequest, error) {
+	keys := make([]string, len(pr_uids))
+	for idx, pr_uid := range pr_uids {
+		keys[idx] = ps.key(pr_uid)
+	}
+
+	prs, err := ps.store.BatchGetPullRequests(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	unwrapped := make(map[string]*pr_gh.PullRequest)
+	for _, pr := range prs {
+		pr = ps.unwrap(pr)
+		unwrapped[pr.PK] = pr
+	}
+	return unwrapped, nil
+}
+
+func (ps *PrefixedStore) QueryOpenPullRequests() ([]*pr_gh.PullRequest, error) {
+	prs, err := ps.store.QueryOpenPullRequests()
+	if err != nil {
+		return nil, err
+	}
+	return ps.filter(prs), nil
+}
+
+func (ps *PrefixedStore) ScanPullRequests() ([]*pr_gh.PullRequest, error) {
+	prs, err := ps.store.ScanPullRequests()
+	if err != nil {
+		return nil, err
+	}
+	return ps.filter(prs), nil
+}
+
+func (ps *PrefixedStore) HasPullRequests(prefix string) (bool, error) {
+	return ps.store.HasPullRequests(ps.key(prefix))
+}
+
+func (ps *PrefixedStore) DeletePullRequest(pr_uid string) error {
+	return ps.store.DeletePullRequest(ps.key(pr_uid))
+}
+
+func (ps *PrefixedStore) PutHistoryEvent(event *HistoryEvent) error {
+	cp := *event
+	cp.PK = ps.key(event.PK)
+	return ps.store.PutHistoryEvent(&cp)
+}
+
+func (ps *PrefixedStore) GetHistory(pr_uid string) ([]*HistoryEvent, error) {
+	events, err := ps.store.GetHistory(ps.key(pr_uid))
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		event.PK = pr_uid
+	}
+	return events, nil
+}
+
+func (ps *PrefixedStore) DeleteHistory(pr_uid string) error {
+	return ps.store.DeleteHistory(ps.key(pr_uid))
+}
+
+func (ps *PrefixedStore) ListHistoryPKs() ([]string, error) {
+	keys, err := ps.store.ListHistoryPKs()
+	if err != nil {
+		return nil, err
+	}
+
+	var pr_uids []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, ps.prefix) {
+			pr_uids = append(pr_uids, strings.TrimPrefix(key, ps.prefix))
+		}
+	}
+	return pr_uids, nil
+}
+
+func (ps *PrefixedStore) PutMessageRef(ref *MessageRef) error {
+	cp := *ref
+	cp.Key = ps.key(ref.Key)
+	cp.PK = ps.key(ref.PK)
+	return ps.store.PutMessageRef(&cp)
+}
+
+func (ps *PrefixedStore) GetMessageRef(key string) (*MessageRef, error) {
+	ref, err := ps.store.GetMessageRef(ps.key(key))
+	if err != nil {
+		return nil, err
+	}
+	ref.Key = key
+	ref.PK = strings.TrimPrefix(ref.PK, ps.prefix)
+	return ref, nil
+}
+
+func (ps *PrefixedStore) ListMessageRefs() ([]*MessageRef, error) {
+	refs, err := ps.store.ListMessageRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []*MessageRef
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.Key, ps.prefix) {
+			ref.Key = strings.TrimPrefix(ref.Key, ps.prefix)
+			ref.PK = strings.TrimPrefix(ref.PK, ps.prefix)
+			filtered = append(filtered, ref)
+		}
+	}
+	return filtered, nil
+}
+
+// Copy an outbox message with its keys prefixed.
+func (ps *PrefixedStore) wrapOutboxMessage(msg *OutboxMessage) *OutboxMessage {
+	cp := *msg
+	cp.ID = ps.key(msg.ID)
+	cp.PK = ps.key(msg.PK)
+	return &cp
+}
+
+func (ps *PrefixedStore) PutOutboxMessage(msg *OutboxMessage) error {
+	return ps.store.PutOutboxMessage(ps.wrapOutboxMessage(msg))
+}
+
+func (ps *PrefixedStore) ListOutboxMessages(status string) ([]*OutboxMessage, error) {
+	msgs, err := ps.store.ListOutboxMessages(status)
+	if err != nil {
+		return nil, err
+	}
+	return ps.filterOutboxMessages(msgs), nil
+}
+
+func (ps *PrefixedStore) ListSentOutboxMessages(since time.Time) ([]*OutboxMessage, error) {
+	msgs, err := ps.store.ListSentOutboxMessages(since)
+	if err != nil {
+		return nil, err
+	}
+	return ps.filterOutboxMessages(msgs), nil
+}
+
+// Keep only the outbox messages which belong to this prefix.
+func (ps *PrefixedStore) filterOutboxMessages(msgs []*OutboxMessage) []*OutboxMessage {
+	var filtered []*OutboxMessage
+	for _, msg := range msgs {
+		if strings.HasPrefix(msg.ID, ps.prefix) {
+			msg.ID = strings.TrimPrefix(msg.ID, ps.prefix)
+			msg.PK = strings.TrimPrefix(msg.PK, ps.prefix)
+			filtered = append(filtered, msg)
+		}
+	}
+	return filtered
+}
+
+func (ps *PrefixedStore) DeleteOutboxMessage(id string) error {
+	return ps.store.DeleteOutboxMessage(ps.key(id))
+}
+
+func (ps *PrefixedStore) AcquireLease(name string, owner string, ttl time.Duration) (*Lease, error) {
+	lease, err := ps.store.AcquireLease(ps.key(name), owner, ttl)
+	if err != nil {
+		return nil, err
+	}
+	lease.Name = name
+	return lease, nil
+}
+
+func (ps *PrefixedStore) ReleaseLease(name string, owner string) error {
+	return ps.store.ReleaseLease(ps.key(name), owner)
+}
@@ -3,16 +3,13 @@ package database
 import (
 	"errors"
 	"fmt"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+	"github.com/ooojustin/pr-puller/pkg/rules"
 )
 
-const pullRequestsTable string = "pull-requests"
-const pullRequestPK string = "pr_uid"
-
 var (
 	ItemNotFoundError error = errors.New("Item not found.")
 )
@@ -22,103 +19,305 @@ type PutPullRequestsResponse struct {
 	Updated  []*pr_gh.PullRequest
 	Skipped  []*pr_gh.PullRequest
 	Failed   []*pr_gh.PullRequest
-	Notify   []*pr_gh.PullRequest
+
+	// Pull requests which a notification was queued for.
+	Notify []*pr_gh.PullRequest
+
+	// Open pull requests which weren't stored yet, and were recorded as notified without
+	// queueing anything, by SeedPullRequests.
+	Seeded []*pr_gh.PullRequest
 }
 
+// Number of times a conditional write is retried after losing to another instance.
+const maxVersionConflictRetries int = 3
+
+type putResult int
+
+const (
+	putSkipped putResult = iota
+	putUploaded
+	putUpdated
+	putFailed
+)
+
 func (db *Database) PutPullRequests(prs []*pr_gh.PullRequest) PutPullRequestsResponse {
+	return db.putPullRequests(prs, false)
+}
+
+// Store pull requests like PutPullRequests, but without queueing any notifications
+// about those which aren't stored yet. Those which are open are recorded as already
+// notified, so an initial sync doesn't announce every pull request which was opened
+// before the bot was watching. Their review clocks start now, for the same reason.
+// Pull requests which are already stored are stored like PutPullRequests would.
+func (db *Database) SeedPullRequests(prs []*pr_gh.PullRequest) PutPullRequestsResponse {
+	return db.putPullRequests(prs, true)
+}
+
+// Whether no pull requests are stored yet.
+func (db *Database) IsEmpty() (bool, error) {
+	found, err := db.Store.HasPullRequests("")
+	return !found, err
+}
+
+func (db *Database) putPullRequests(prs []*pr_gh.PullRequest, seed bool) PutPullRequestsResponse {
 	var response PutPullRequestsResponse
+
+	pr_uids := make([]string, len(prs))
+	for idx, pr := range prs {
+		pr_uids[idx] = pr.PK
+	}
+
+	// Load every existing record up front, rather than one round trip per PR.
+	existingPRs, err := db.Store.BatchGetPullRequests(pr_uids)
+	if err != nil {
+		response.Skipped = append(response.Skipped, prs...)
+		return response
+	}
+
 	for _, pr := range prs {
-		existingPR, err := db.GetPullRequest(pr.PK)
-		if err != nil && err != ItemNotFoundError {
+		result, notify := db.putPullRequest(pr, existingPRs[pr.PK], seed)
+		switch result {
+		case putSkipped:
 			response.Skipped = append(response.Skipped, pr)
-			continue
+		case putUploaded:
+			response.Uploaded = append(response.Uploaded, pr)
+		case putUpdated:
+			response.Updated = append(response.Updated, pr)
+		case putFailed:
+			response.Failed = append(response.Failed, pr)
+		}
+
+		if notify {
+			response.Notify = append(response.Notify, pr)
+		}
+		if seed && result == putUploaded && pr.State == pr_gh.StateOpen {
+			response.Seeded = append(response.Seeded, pr)
+		}
+	}
+	return response
+}
+
+// Decide whether a freshly scraped PR needs to be written, and write it conditionally
+// on the version of the existing record. Changed items are written one at a time since
+// BatchWriteItem doesn't support conditions. If another instance wrote the record first,
+// it's re-read and the decision is made again, so only the instance whose write wins
+// will report that a notification should be sent, and record the change in history.
+// The scrape is merged into the existing record, so only scraped fields are refreshed,
+// and pr is updated to match the record which was written.
+func (db *Database) putPullRequest(pr *pr_gh.PullRequest, existingPR *pr_gh.PullRequest, seed bool) (putResult, bool) {
+	scraped := pr.Scraped()
+
+	for attempt := 0; ; attempt++ {
+		if existingPR == nil && scraped.State != pr_gh.StateOpen {
+			// Closed PRs are only scraped to see the ones we're tracking leave the open state.
+			return putSkipped, false
 		}
 
-		var update bool
 		if existingPR != nil {
-			update = (existingPR.Draft && !pr.Draft) ||
-				((existingPR.ReviewDecision != pr.ReviewDecision) && pr.ReviewDecision == "Review required")
-			if !update {
-				response.Skipped = append(response.Skipped, pr)
-				continue
+			*pr = *existingPR.Merge(&scraped)
+		} else {
+			*pr = *copyPullRequest(&scraped)
+		}
+		pr.ContentHash = pr.Hash()
+
+		if existingPR != nil && existingPR.ContentHash == pr.ContentHash {
+			if !pr.DetailsLoaded.After(existingPR.DetailsLoaded) {
+				// Nothing has changed since the last time this PR was stored.
+				return putSkipped, false
+			}
+
+			// The details were reloaded without changing, so only when they were loaded is
+			// stored, so they aren't loaded again until they're due.
+			pr.Version = existingPR.Version + 1
+			err := db.Store.PutPullRequestIfVersion(pr, existingPR.Version)
+			if err == nil {
+				return putSkipped, false
+			} else if err != VersionConflictError || attempt >= maxVersionConflictRetries {
+				return putFailed, false
+			}
+
+			if existingPR, err = db.reloadPullRequest(pr.PK); err != nil {
+				return putFailed, false
 			}
+			continue
 		}
 
-		var notify bool
-		if !pr.Notified && !pr.Draft && pr.ReviewDecision != "Approved" {
-			notify = true
-			pr.Notified = true
+		now := time.Now()
+		events := lifecycle.Diff(existingPR, pr, now)
+		trackWaiting(pr, events)
+
+		pr.ExpiresAt = db.expiresAt(existingPR, pr, now)
+		pr.UpdatedAt = now.UTC()
+
+		var expectedVersion int
+		if existingPR != nil {
+			expectedVersion = existingPR.Version
 		}
+		pr.Version = expectedVersion + 1
 
-		if err := db.PutPullRequest(pr); err == nil {
-			if update {
-				response.Updated = append(response.Updated, pr)
-			} else {
-				response.Uploaded = append(response.Uploaded, pr)
+		// Notifications are queued in the same write, and delivered by DeliverOutbox.
+		var notify bool
+		var outbox []*OutboxMessage
+		var marker string
+		if seed && existingPR == nil {
+			// Recorded as if it was announced, without queueing anything. Stored pull
+			// requests are updated as usual, so restarts don't reset their review clocks.
+			pr.Notified = pr.Notified || pr.State == pr_gh.StateOpen
+			if !pr.WaitingSince.IsZero() {
+				pr.WaitingSince = now.UTC()
 			}
-
+			marker = EventSeeded
+		} else {
+			notify, outbox = preparedOutbox(db.bus().Prepare(events...))
+			pr.Notified = pr.Notified || notify
 			if notify {
-				response.Notify = append(response.Notify, pr)
+				marker = EventNotified
 			}
-		} else {
-			response.Failed = append(response.Failed, pr)
 		}
-	}
-	return response
-}
 
-func (db *Database) PutPullRequest(pr *pr_gh.PullRequest) error {
-	av, err := dynamodbattribute.MarshalMap(pr)
-	if err != nil {
-		fmt.Println("Failed to marshal PullRequest:", err)
-		return err
-	}
+		err := db.Store.PutPullRequestIfVersion(pr, expectedVersion, outbox...)
+		if err == nil {
+			db.bus().Publish(events...)
+			if marker != "" {
+				// Timestamped separately, so it's ordered after the change which caused it.
+				db.recordHistoryEvent(NewHistoryEvent(pr.PK, marker, "", "", time.Now()))
+			}
+			if existingPR != nil {
+				return putUpdated, notify
+			}
+			return putUploaded, notify
+		} else if err != VersionConflictError || attempt >= maxVersionConflictRetries {
+			return putFailed, false
+		}
 
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(pullRequestsTable),
+		// Lost the race, so re-evaluate against whatever the winner wrote.
+		if existingPR, err = db.reloadPullRequest(pr.PK); err != nil {
+			return putFailed, false
+		}
 	}
+}
 
-	_, err = db.DynamoDB.PutItem(input)
-	if err != nil {
-		fmt.Println("Failed to PutItem PullRequest:", err)
-		return err
+// Re-read the record of a pull request after losing a race to write it, which is nil if
+// the winner deleted it.
+func (db *Database) reloadPullRequest(pr_uid string) (*pr_gh.PullRequest, error) {
+	pr, err := db.Store.GetPullRequest(pr_uid)
+	if err == ItemNotFoundError {
+		return nil, nil
 	}
+	return pr, err
+}
 
-	return nil
+// Subscribe the notifier and history to the bus lifecycle events are published on,
+// creating it if there isn't one, the first time it's used.
+func (db *Database) bus() *lifecycle.Bus {
+	db.subscribe.Do(func() {
+		if db.Events == nil {
+			db.Events = lifecycle.NewBus()
+		}
+		db.Events.SubscribePrepare(db.prepareNotifications)
+		db.Events.Subscribe(db.recordHistory)
+	})
+	return db.Events
 }
 
-func (db *Database) GetPullRequest(pr_uid string) (*pr_gh.PullRequest, error) {
-	key := map[string]interface{}{pullRequestPK: pr_uid}
+// Evaluate the notification rules against an event, and create the outbox messages
+// they ask for. Once a pull request has a message in a channel, later events are posted
+// as replies in its thread instead, including those in threadEvents which no rule posted
+// about. The rule result is returned too when one fired without suppressing
+// notifications, even if it had nowhere to post, so the write counts as notifying.
+func (db *Database) prepareNotifications(event *lifecycle.Event) []interface{} {
+	engine := db.Rules
+	if engine == nil {
+		engine = rules.NewEngine(nil, db.NotifyChannel, nil)
+	}
+
+	pr := event.Current
+	now := time.Now()
+	var prepared []interface{}
 
-	av, err := dynamodbattribute.MarshalMap(key)
-	if err != nil {
-		fmt.Println("Failed to marshal PullRequest key:", err)
-		return nil, err
+	result := engine.Evaluate(event)
+	if result != nil && result.Suppressed {
+		return nil
 	}
 
-	input := &dynamodb.GetItemInput{
-		Key:       av,
-		TableName: aws.String(pullRequestsTable),
+	var notifications []rules.Notification
+	if result != nil {
+		prepared = append(prepared, result)
+		notifications = result.Notifications
+	}
+	if pr.SlackTS != "" && threadEvents[event.Type] {
+		notifications = append(notifications, rules.Notification{Destination: pr.SlackChannel})
 	}
 
-	var pr pr_gh.PullRequest
+	for _, notification := range notifications {
+		msg := NewOutboxMessage(pr.PK, event.Type, pr.Version, notification.Destination, now)
+		msg.Mention = notification.Mention
+		if result != nil {
+			msg.Rule = result.Rule
+		}
+		if event.Review != nil {
+			msg.Reviewer = event.Review.Reviewer
+		}
+		if pr.SlackTS != "" && notification.Destination == pr.SlackChannel {
+			msg.ThreadTS = pr.SlackTS
+			msg.Broadcast = result != nil && result.Important
+		}
+		prepared = append(prepared, msg)
+	}
 
-	output, err := db.DynamoDB.GetItem(input)
-	if err != nil {
-		fmt.Println("Failed to GetItem PullRequest:", err)
-		return nil, err
+	if update := updateMessage(pr, now); update != nil {
+		prepared = append(prepared, update)
 	}
+	return prepared
+}
 
-	if len(output.Item) == 0 {
-		return nil, ItemNotFoundError
+// Sort what was prepared for a write into whether it notifies, and the outbox messages
+// to write along with it. Each destination gets at most one message per write, each
+// thread gets one reply per type of event, and the message announcing the pull request
+// is updated once. A review which changed the review decision is only replied to once,
+// about the decision.
+func preparedOutbox(prepared []interface{}) (bool, []*OutboxMessage) {
+	var notify bool
+	var messages []*OutboxMessage
+	seen := make(map[string]bool)
+	for _, item := range prepared {
+		switch item := item.(type) {
+		case *rules.Result:
+			notify = true
+		case *OutboxMessage:
+			key := "channel#" + item.Channel
+			if item.Event == OutboxUpdateEvent {
+				key = OutboxUpdateEvent
+			} else if item.ThreadTS != "" {
+				key = "thread#" + item.Event
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			messages = append(messages, item)
+		}
 	}
 
-	err = dynamodbattribute.UnmarshalMap(output.Item, &pr)
-	if err != nil {
-		fmt.Println("Failed to convert PullRequest output to object:", err)
-		return nil, err
+	decided := seen["thread#"+lifecycle.Approved] || seen["thread#"+lifecycle.ChangesRequested]
+	var outbox []*OutboxMessage
+	for _, msg := range messages {
+		if decided && msg.ThreadTS != "" && msg.Event == lifecycle.Reviewed {
+			continue
+		}
+		outbox = append(outbox, msg)
 	}
+	return notify, outbox
+}
 
-	return &pr, nil
+// Append a lifecycle event to the history of its PR, once it's stored. History is best
+// effort, so failures are logged rather than failing the write they describe.
+func (db *Database) recordHistory(event *lifecycle.Event) {
+	db.recordHistoryEvent(NewHistoryEvent(event.PK, event.Type, event.Old, event.New, event.Timestamp))
+}
+
+func (db *Database) recordHistoryEvent(event *HistoryEvent) {
+	if err := db.Store.PutHistoryEvent(event); err != nil {
+		fmt.Printf("Failed to record %s event for %s: %s\n", event.Type, event.PK, err)
+	}
 }
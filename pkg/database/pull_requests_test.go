@@ -0,0 +1,1221 @@
+package database_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/ooojustin/pr-puller/pkg/database"
+	"github.com/ooojustin/pr-puller/pkg/database/storetest"
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+	"github.com/ooojustin/pr-puller/pkg/rules"
+	"github.com/ooojustin/pr-puller/pkg/schedule"
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+// Store which fails to write specific pull requests.
+type failingStore struct {
+	database.Store
+	fail map[string]bool
+}
+
+func (fs *failingStore) PutPullRequestIfVersion(pr *pr_gh.PullRequest, expectedVersion int, outbox ...*database.OutboxMessage) error {
+	if fs.fail[pr.PK] {
+		return errors.New("write failed")
+	}
+	return fs.Store.PutPullRequestIfVersion(pr, expectedVersion, outbox...)
+}
+
+// Store which lets another instance process the same pull requests right before
+// its own first conditional write.
+type racingStore struct {
+	database.Store
+	rival func()
+}
+
+func (rs *racingStore) PutPullRequestIfVersion(pr *pr_gh.PullRequest, expectedVersion int, outbox ...*database.OutboxMessage) error {
+	if rs.rival != nil {
+		rival := rs.rival
+		rs.rival = nil
+		rival()
+	}
+	return rs.Store.PutPullRequestIfVersion(pr, expectedVersion, outbox...)
+}
+
+func pks(prs []*pr_gh.PullRequest) []string {
+	var out []string
+	for _, pr := range prs {
+		out = append(out, pr.PK)
+	}
+	return out
+}
+
+func assertPKs(t *testing.T, name string, got []*pr_gh.PullRequest, want ...*pr_gh.PullRequest) {
+	t.Helper()
+	gotPKs, wantPKs := pks(got), pks(want)
+	if len(gotPKs) != len(wantPKs) {
+		t.Fatalf("%s = %v, want %v", name, gotPKs, wantPKs)
+	}
+	for idx := range gotPKs {
+		if gotPKs[idx] != wantPKs[idx] {
+			t.Fatalf("%s = %v, want %v", name, gotPKs, wantPKs)
+		}
+	}
+}
+
+func TestPutPullRequestsAccounting(t *testing.T) {
+	store := &failingStore{Store: database.NewMemoryStore(), fail: map[string]bool{}}
+	db := &database.Database{Store: store}
+
+	ready := storetest.NewPullRequest(1)
+	draft := storetest.NewPullRequest(2)
+	draft.Draft = true
+	approved := storetest.NewPullRequest(3)
+	approved.ReviewDecision = "Approved"
+	broken := storetest.NewPullRequest(4)
+	store.fail[broken.PK] = true
+
+	resp := db.PutPullRequests([]*pr_gh.PullRequest{ready, draft, approved, broken})
+	assertPKs(t, "Uploaded", resp.Uploaded, ready, draft, approved)
+	assertPKs(t, "Updated", resp.Updated)
+	assertPKs(t, "Skipped", resp.Skipped)
+	assertPKs(t, "Failed", resp.Failed, broken)
+	assertPKs(t, "Notify", resp.Notify, ready)
+
+	// Scraping the same content again skips everything that was stored.
+	store.fail = map[string]bool{}
+	resp = db.PutPullRequests([]*pr_gh.PullRequest{
+		storetest.NewPullRequest(1),
+		func() *pr_gh.PullRequest { pr := storetest.NewPullRequest(2); pr.Draft = true; return pr }(),
+		func() *pr_gh.PullRequest {
+			pr := storetest.NewPullRequest(3)
+			pr.ReviewDecision = "Approved"
+			return pr
+		}(),
+		storetest.NewPullRequest(4),
+	})
+	assertPKs(t, "Uploaded", resp.Uploaded, broken)
+	assertPKs(t, "Skipped", resp.Skipped, ready, draft, approved)
+	assertPKs(t, "Notify", resp.Notify, broken)
+
+	// Leaving draft is an update which notifies, a title change alone doesn't notify.
+	readied := storetest.NewPullRequest(2)
+	renamed := storetest.NewPullRequest(1)
+	renamed.Title = "Renamed"
+	resp = db.PutPullRequests([]*pr_gh.PullRequest{renamed, readied})
+	assertPKs(t, "Updated", resp.Updated, renamed, readied)
+	assertPKs(t, "Skipped", resp.Skipped)
+	assertPKs(t, "Notify", resp.Notify, readied)
+
+	// Closed PRs which were never tracked are ignored.
+	closed := storetest.NewPullRequest(5)
+	closed.State = pr_gh.StateClosed
+	resp = db.PutPullRequests([]*pr_gh.PullRequest{closed})
+	assertPKs(t, "Skipped", resp.Skipped, closed)
+}
+
+func TestPutPullRequestsHistory(t *testing.T) {
+	db := &database.Database{Store: database.NewMemoryStore()}
+
+	scrape := func(mutate func(pr *pr_gh.PullRequest)) {
+		pr := storetest.NewPullRequest(1)
+		mutate(pr)
+		db.PutPullRequests([]*pr_gh.PullRequest{pr})
+	}
+
+	scrape(func(pr *pr_gh.PullRequest) { pr.Draft = true })
+	scrape(func(pr *pr_gh.PullRequest) { pr.Draft = true })
+	scrape(func(pr *pr_gh.PullRequest) {})
+	scrape(func(pr *pr_gh.PullRequest) { pr.ReviewDecision = pr_gh.ReviewChangesRequested })
+	scrape(func(pr *pr_gh.PullRequest) { pr.ReviewDecision = pr_gh.ReviewApproved })
+	scrape(func(pr *pr_gh.PullRequest) {
+		pr.ReviewDecision = pr_gh.ReviewApproved
+		pr.State = pr_gh.StateMerged
+	})
+
+	timeline, err := db.GetTimeline(storetest.NewPullRequest(1).PK)
+	if err != nil {
+		t.Fatalf("GetTimeline: %s", err)
+	}
+
+	var types []string
+	for _, event := range timeline.Events {
+		types = append(types, event.Type)
+	}
+	want := []string{
+		database.EventOpened,
+		database.EventReviewRequired,
+		database.EventReadyForReview,
+		database.EventNotified,
+		database.EventChangesRequested,
+		database.EventApproved,
+		database.EventMerged,
+	}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Fatalf("timeline = %v, want %v", types, want)
+	}
+
+	if opened := timeline.First(database.EventOpened); !opened.Timestamp.Equal(storetest.NewPullRequest(1).Created) {
+		t.Errorf("opened at %s, want creation time", opened.Timestamp)
+	}
+	if _, ok := timeline.WaitedForReview(); !ok {
+		t.Errorf("WaitedForReview reported no review")
+	}
+}
+
+func TestPutPullRequestsConcurrentInstances(t *testing.T) {
+	shared := database.NewMemoryStore()
+	winner := &database.Database{Store: shared}
+	loser := &database.Database{Store: &racingStore{
+		Store: shared,
+		rival: func() {
+			resp := winner.PutPullRequests([]*pr_gh.PullRequest{storetest.NewPullRequest(1)})
+			assertPKs(t, "winner Notify", resp.Notify, storetest.NewPullRequest(1))
+		},
+	}}
+
+	pr := storetest.NewPullRequest(1)
+	resp := loser.PutPullRequests([]*pr_gh.PullRequest{pr})
+	assertPKs(t, "loser Skipped", resp.Skipped, pr)
+	assertPKs(t, "loser Notify", resp.Notify)
+
+	stored, err := shared.GetPullRequest(pr.PK)
+	if err != nil {
+		t.Fatalf("GetPullRequest: %s", err)
+	}
+	if stored.Version != 1 || !stored.Notified {
+		t.Fatalf("stored Version = %d, Notified = %t, want 1, true", stored.Version, stored.Notified)
+	}
+}
+
+func TestEventSubscribers(t *testing.T) {
+	store := &failingStore{Store: database.NewMemoryStore(), fail: map[string]bool{}}
+	db := &database.Database{Store: store, NotifyChannel: "C123", Events: lifecycle.NewBus()}
+
+	var published []string
+	db.Events.Subscribe(func(event *lifecycle.Event) { published = append(published, event.PK) })
+	db.Events.SubscribePrepare(func(event *lifecycle.Event) []interface{} {
+		return []interface{}{database.NewOutboxMessage(event.PK, event.Type, event.Current.Version, "CAUDIT", time.Now())}
+	}, lifecycle.Opened)
+
+	// Nothing which was prepared for a failed write is stored or published.
+	broken := storetest.NewPullRequest(1)
+	store.fail[broken.PK] = true
+	db.PutPullRequests([]*pr_gh.PullRequest{broken})
+	pending, err := store.ListOutboxMessages(database.OutboxPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 || len(published) != 0 {
+		t.Fatalf("failed write queued %d messages and published %v", len(pending), published)
+	}
+	if timeline, err := db.GetTimeline(broken.PK); err != nil || len(timeline.Events) != 0 {
+		t.Fatalf("failed write recorded history: %v, %v", timeline, err)
+	}
+
+	store.fail = map[string]bool{}
+	db.PutPullRequests([]*pr_gh.PullRequest{storetest.NewPullRequest(1)})
+	pending, err = store.ListOutboxMessages(database.OutboxPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channels := map[string]bool{}
+	for _, msg := range pending {
+		channels[msg.Channel] = true
+	}
+	if !channels["C123"] || !channels["CAUDIT"] {
+		t.Errorf("queued messages to %v, want the notifier's and the subscriber's", channels)
+	}
+	if len(published) == 0 {
+		t.Errorf("stored events weren't published")
+	}
+	if timeline, err := db.GetTimeline(broken.PK); err != nil || timeline.First(database.EventOpened) == nil {
+		t.Errorf("history didn't record the opened event: %v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	store := database.NewMemoryStore()
+	db := &database.Database{Store: store}
+
+	legacy := storetest.NewPullRequest(1)
+	legacy.State = ""
+	if err := store.PutPullRequest(legacy); err != nil {
+		t.Fatalf("PutPullRequest: %s", err)
+	}
+
+	if err := db.CheckSchemaVersion(); err != database.SchemaOutdatedError {
+		t.Fatalf("CheckSchemaVersion error = %v, want SchemaOutdatedError", err)
+	}
+
+	// Migrating twice is harmless.
+	for i := 0; i < 2; i++ {
+		if err := db.Migrate(); err != nil {
+			t.Fatalf("Migrate: %s", err)
+		}
+	}
+
+	if err := db.CheckSchemaVersion(); err != nil {
+		t.Fatalf("CheckSchemaVersion after Migrate: %s", err)
+	}
+
+	open, err := store.QueryOpenPullRequests()
+	if err != nil {
+		t.Fatalf("QueryOpenPullRequests: %s", err)
+	}
+	if len(open) != 1 || open[0].PK != legacy.PK {
+		t.Fatalf("QueryOpenPullRequests after Migrate = %v, want %s", pks(open), legacy.PK)
+	}
+}
+
+func TestRetentionAndPurge(t *testing.T) {
+	store := database.NewMemoryStore()
+	db := &database.Database{Store: store, Retention: 24 * time.Hour}
+
+	open := storetest.NewPullRequest(1)
+	merged := storetest.NewPullRequest(2)
+	db.PutPullRequests([]*pr_gh.PullRequest{open, merged})
+
+	merged = storetest.NewPullRequest(2)
+	merged.State = pr_gh.StateMerged
+	db.PutPullRequests([]*pr_gh.PullRequest{merged})
+
+	stored, err := store.GetPullRequest(merged.PK)
+	if err != nil {
+		t.Fatalf("GetPullRequest: %s", err)
+	}
+	expires := time.Unix(stored.ExpiresAt, 0)
+	if until := time.Until(expires); until < 23*time.Hour || until > 25*time.Hour {
+		t.Fatalf("merged PR expires in %s, want about 24h", until)
+	}
+	if stored, _ := store.GetPullRequest(open.PK); stored.ExpiresAt != 0 {
+		t.Fatalf("open PR has ExpiresAt %d, want 0", stored.ExpiresAt)
+	}
+
+	// Closed before retention was configured, and history left behind by a DynamoDB TTL deletion.
+	legacy := storetest.NewPullRequest(3)
+	legacy.State = pr_gh.StateClosed
+	store.PutPullRequest(legacy)
+	store.PutHistoryEvent(database.NewHistoryEvent("org#repo#404", database.EventOpened, "", "open", time.Now()))
+
+	// A notification sent long enough ago that it's expired, and one still pending.
+	sent := database.NewOutboxMessage(open.PK, "opened", 1, "C123", time.Now().Add(-48*time.Hour))
+	sent.Status = database.OutboxSent
+	sent.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+	pending := database.NewOutboxMessage(merged.PK, "merged", 2, "C123", time.Now())
+	store.PutOutboxMessage(sent)
+	store.PutOutboxMessage(pending)
+
+	candidates, err := db.FindPurgeable(time.Now())
+	if err != nil {
+		t.Fatalf("FindPurgeable: %s", err)
+	}
+	reasons := make(map[string]string)
+	for _, candidate := range candidates {
+		if candidate.OutboxID != "" {
+			reasons[candidate.OutboxID] = candidate.Reason
+			continue
+		}
+		reasons[candidate.PK] = candidate.Reason
+	}
+	want := map[string]string{
+		legacy.PK:      database.PurgeNoExpiry,
+		"org#repo#404": database.PurgeOrphanedHistory,
+		sent.ID:        database.PurgeExpiredOutbox,
+	}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Fatalf("FindPurgeable now = %v, want %v", reasons, want)
+	}
+
+	candidates, err = db.FindPurgeable(expires.Add(time.Second))
+	if err != nil {
+		t.Fatalf("FindPurgeable: %s", err)
+	}
+	if purged, err := db.Purge(candidates); err != nil || purged != 4 {
+		t.Fatalf("Purge = %d, %v; want 4, nil", purged, err)
+	}
+
+	prs, _ := store.ScanPullRequests()
+	if len(prs) != 1 || prs[0].PK != open.PK {
+		t.Fatalf("records after purge = %v, want only %s", pks(prs), open.PK)
+	}
+	if pr_uids, _ := store.ListHistoryPKs(); !reflect.DeepEqual(pr_uids, []string{open.PK}) {
+		t.Fatalf("history after purge = %v, want only %s", pr_uids, open.PK)
+	}
+	if msgs, _ := store.ListSentOutboxMessages(time.Time{}); len(msgs) != 0 {
+		t.Fatalf("sent notifications after purge = %d, want 0", len(msgs))
+	}
+	if msgs, _ := store.ListOutboxMessages(database.OutboxPending); len(msgs) != 1 || msgs[0].ID != pending.ID {
+		t.Fatalf("pending notifications after purge = %d, want the pending one", len(msgs))
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	source := &database.Database{Store: database.NewMemoryStore()}
+	source.PutPullRequests([]*pr_gh.PullRequest{storetest.NewPullRequest(1), storetest.NewPullRequest(2)})
+	ref := database.NewMessageRef(storetest.NewPullRequest(1).PK, database.EventNotified, "C123", "1700000000.000100", time.Now())
+	if err := source.Store.PutMessageRef(ref); err != nil {
+		t.Fatal(err)
+	}
+
+	var export bytes.Buffer
+	exported, err := source.Export(&export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported.PullRequests != 2 || exported.Messages != 1 || exported.History == 0 {
+		t.Fatalf("exported %+v", exported)
+	}
+
+	target := &database.Database{Store: database.NewMemoryStore()}
+	for i := 0; i < 2; i++ {
+		imported, err := target.Import(bytes.NewReader(export.Bytes()), database.ConflictSkip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 && (imported.PullRequests != 0 || imported.Messages != 0 || imported.Skipped != 3) {
+			t.Fatalf("second import %+v", imported)
+		}
+	}
+
+	var again bytes.Buffer
+	if _, err := target.Export(&again); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != export.String() {
+		t.Fatalf("import is not a round trip:\n%s\n%s", export.String(), again.String())
+	}
+
+	// A record updated in the target since the export is kept by newest-wins, but
+	// replaced by overwrite.
+	pr, _ := target.Store.GetPullRequest(storetest.NewPullRequest(1).PK)
+	pr.Title = "Renamed"
+	pr.UpdatedAt = pr.UpdatedAt.Add(time.Hour)
+	target.Store.PutPullRequest(pr)
+
+	target.Import(bytes.NewReader(export.Bytes()), database.ConflictNewestWins)
+	if pr, _ = target.Store.GetPullRequest(pr.PK); pr.Title != "Renamed" {
+		t.Fatalf("newest-wins replaced a newer record")
+	}
+	target.Import(bytes.NewReader(export.Bytes()), database.ConflictOverwrite)
+	if pr, _ = target.Store.GetPullRequest(pr.PK); pr.Title == "Renamed" {
+		t.Fatalf("overwrite kept the existing record")
+	}
+
+	if _, err := target.Import(bytes.NewReader(export.Bytes()), "latest"); err != database.UnknownConflictPolicyError {
+		t.Fatalf("unknown policy: %v", err)
+	}
+}
+
+func TestSeedPullRequests(t *testing.T) {
+	db := &database.Database{Store: database.NewMemoryStore(), NotifyChannel: "C123"}
+	if empty, err := db.IsEmpty(); err != nil || !empty {
+		t.Fatalf("IsEmpty = %v, %v, want true", empty, err)
+	}
+
+	open := storetest.NewPullRequest(1)
+	draft := storetest.NewPullRequest(2)
+	draft.Draft = true
+	closed := storetest.NewPullRequest(3)
+	closed.State = pr_gh.StateClosed
+
+	resp := db.SeedPullRequests([]*pr_gh.PullRequest{open, draft, closed})
+	assertPKs(t, "Seeded", resp.Seeded, open, draft)
+	assertPKs(t, "Skipped", resp.Skipped, closed)
+	assertPKs(t, "Notify", resp.Notify)
+	if pending, _ := db.Store.ListOutboxMessages(database.OutboxPending); len(pending) != 0 {
+		t.Fatalf("seeding queued %d notifications", len(pending))
+	}
+	if empty, _ := db.IsEmpty(); empty {
+		t.Fatalf("IsEmpty = true after seeding")
+	}
+
+	stored, err := db.Store.GetPullRequest(open.PK)
+	if err != nil || !stored.Notified {
+		t.Fatalf("seeded pull request = %+v, %v, want notified", stored, err)
+	}
+	if stored.WaitingSince.Before(time.Now().Add(-time.Minute)) {
+		t.Errorf("review clock started at %s, want when it was seeded", stored.WaitingSince)
+	}
+
+	history, _ := db.Store.GetHistory(open.PK)
+	if last := history[len(history)-1]; last.Type != database.EventSeeded {
+		t.Errorf("last history event = %s, want %s", last.Type, database.EventSeeded)
+	}
+
+	// Changes after seeding are notified as usual.
+	ready := storetest.NewPullRequest(2)
+	resp = db.PutPullRequests([]*pr_gh.PullRequest{open, ready})
+	assertPKs(t, "Notify", resp.Notify, ready)
+
+	// Seeding again, such as after a restart, only seeds pull requests which aren't
+	// stored yet, and keeps the review clocks of those which are.
+	before, _ := db.Store.GetPullRequest(open.PK)
+	renamed := storetest.NewPullRequest(1)
+	renamed.Title = "Renamed"
+	opened := storetest.NewPullRequest(4)
+	resp = db.SeedPullRequests([]*pr_gh.PullRequest{renamed, opened})
+	assertPKs(t, "Seeded", resp.Seeded, opened)
+	assertPKs(t, "Updated", resp.Updated, renamed)
+	if stored, _ := db.Store.GetPullRequest(open.PK); !stored.WaitingSince.Equal(before.WaitingSince) {
+		t.Errorf("seeding again moved the review clock from %s to %s", before.WaitingSince, stored.WaitingSince)
+	}
+	history, _ = db.Store.GetHistory(open.PK)
+	if last := history[len(history)-1]; last.Type == database.EventSeeded {
+		t.Errorf("stored pull request was seeded again")
+	}
+}
+
+func TestNeedsDetails(t *testing.T) {
+	db := &database.Database{Store: database.NewMemoryStore(), DetailsRefresh: time.Hour}
+	now := time.Now()
+
+	needs := func(prs ...*pr_gh.PullRequest) []*pr_gh.PullRequest {
+		t.Helper()
+		needed, err := db.NeedsDetails(prs, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return needed
+	}
+
+	fresh, closed := storetest.NewPullRequest(1), storetest.NewPullRequest(2)
+	closed.State = pr_gh.StateClosed
+	assertPKs(t, "new", needs(fresh, closed), fresh)
+
+	loaded := storetest.NewPullRequest(1)
+	loaded.CIStatus = pr_gh.CISuccess
+	loaded.DetailsLoaded = now.Add(-time.Minute)
+	db.PutPullRequests([]*pr_gh.PullRequest{loaded})
+
+	// Only the search result is scraped on each refresh, so nothing is loaded again
+	// until it changes, or its details get old.
+	assertPKs(t, "unchanged", needs(storetest.NewPullRequest(1)))
+
+	approved := storetest.NewPullRequest(1)
+	approved.ReviewDecision = pr_gh.ReviewApproved
+	assertPKs(t, "approved", needs(approved), approved)
+
+	now = now.Add(time.Hour)
+	assertPKs(t, "stale", needs(storetest.NewPullRequest(1)), storetest.NewPullRequest(1))
+
+	// Reloading details which haven't changed isn't an update, but when they were
+	// loaded is still stored.
+	history, _ := db.Store.GetHistory(loaded.PK)
+	reloaded := storetest.NewPullRequest(1)
+	reloaded.CIStatus = pr_gh.CISuccess
+	reloaded.DetailsLoaded = now
+	resp := db.PutPullRequests([]*pr_gh.PullRequest{reloaded})
+	assertPKs(t, "Updated", resp.Updated)
+	assertPKs(t, "Skipped", resp.Skipped, reloaded)
+	assertPKs(t, "reloaded", needs(storetest.NewPullRequest(1)))
+	if after, _ := db.Store.GetHistory(loaded.PK); len(after) != len(history) {
+		t.Errorf("reloading unchanged details recorded %d history events", len(after)-len(history))
+	}
+}
+
+func TestOutboxDelivery(t *testing.T) {
+	db := &database.Database{Store: database.NewMemoryStore(), NotifyChannel: "C123"}
+	resp := db.PutPullRequests([]*pr_gh.PullRequest{storetest.NewPullRequest(1)})
+	assertPKs(t, "Notify", resp.Notify, storetest.NewPullRequest(1))
+
+	// Slack is down, so the notification stays queued and is retried with backoff.
+	now := time.Now()
+	down := func(msg *database.OutboxMessage, pr *pr_gh.PullRequest) (string, string, error) {
+		return "", "", errors.New("service_unavailable")
+	}
+	delivered, err := db.DeliverOutbox(down, nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered.Retry) != 1 || !delivered.Retry[0].NextAttempt.After(now) {
+		t.Fatalf("first failure = %+v, want a retry later", delivered)
+	}
+	if delivered, _ = db.DeliverOutbox(down, nil, now); len(delivered.Retry) != 0 {
+		t.Fatalf("retried before the backoff elapsed")
+	}
+
+	var sent int
+	up := func(msg *database.OutboxMessage, pr *pr_gh.PullRequest) (string, string, error) {
+		sent++
+		return msg.Channel, "1700000000.000100", nil
+	}
+	later := now.Add(time.Hour)
+	if delivered, _ = db.DeliverOutbox(up, nil, later); len(delivered.Sent) != 1 || sent != 1 {
+		t.Fatalf("delivery after recovery = %+v", delivered)
+	}
+	if delivered, _ = db.DeliverOutbox(up, nil, later); len(delivered.Sent) != 0 || sent != 1 {
+		t.Fatalf("sent notification was delivered again")
+	}
+
+	ref, err := db.Store.GetMessageRef(database.NewMessageRef(storetest.NewPullRequest(1).PK, database.EventOpened, "C123", "", later).Key)
+	if err != nil || ref.Timestamp != "1700000000.000100" {
+		t.Fatalf("GetMessageRef = %+v, %v", ref, err)
+	}
+
+	// A notification which keeps failing is dead-lettered.
+	db.PutPullRequests([]*pr_gh.PullRequest{storetest.NewPullRequest(2)})
+	for i := 0; ; i++ {
+		later = later.Add(time.Hour)
+		if delivered, _ = db.DeliverOutbox(down, nil, later); len(delivered.Dead) == 1 {
+			break
+		} else if i > 20 {
+			t.Fatalf("notification was never dead-lettered")
+		}
+	}
+	dead, _ := db.Store.ListOutboxMessages(database.OutboxDead)
+	if len(dead) != 1 || dead[0].PK != storetest.NewPullRequest(2).PK || dead[0].LastError != "service_unavailable" {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+}
+
+func TestMessageUpdates(t *testing.T) {
+	db := &database.Database{Store: database.NewMemoryStore(), NotifyChannel: "C123"}
+	pr := storetest.NewPullRequest(1)
+
+	var sent []*database.OutboxMessage
+	send := func(msg *database.OutboxMessage, pr *pr_gh.PullRequest) (string, string, error) {
+		sent = append(sent, msg)
+		return msg.Channel, "1700000000.000100", nil
+	}
+	deliver := func() {
+		t.Helper()
+		sent = nil
+		if _, err := db.DeliverOutbox(send, nil, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.PutPullRequests([]*pr_gh.PullRequest{storetest.NewPullRequest(1)})
+	deliver()
+	stored, err := db.Store.GetPullRequest(pr.PK)
+	if err != nil || stored.SlackChannel != "C123" || stored.SlackTS != "1700000000.000100" {
+		t.Fatalf("announced pull request = %+v, %v, want its message recorded", stored, err)
+	}
+
+	// The default rules don't post approvals, but the original message is updated.
+	approved := storetest.NewPullRequest(1)
+	approved.ReviewDecision = pr_gh.ReviewApproved
+	db.PutPullRequests([]*pr_gh.PullRequest{approved})
+	deliver()
+	var updates []*database.OutboxMessage
+	for _, msg := range sent {
+		if msg.Event == database.OutboxUpdateEvent {
+			updates = append(updates, msg)
+		}
+	}
+	if len(updates) != 1 || updates[0].Channel != "C123" {
+		t.Fatalf("updates = %+v, want an update of the original message", updates)
+	}
+	if _, err := db.Store.GetMessageRef(database.NewMessageRef(pr.PK, database.OutboxUpdateEvent, "C123", "", time.Now()).Key); err != database.ItemNotFoundError {
+		t.Errorf("update was recorded as a posted message")
+	}
+
+	// Scraping it again without changes doesn't update the message again.
+	db.PutPullRequests([]*pr_gh.PullRequest{approved})
+	deliver()
+	if len(sent) != 0 {
+		t.Fatalf("sent = %+v, want nothing", sent)
+	}
+}
+
+func TestThreadedReplies(t *testing.T) {
+	important := []utils.NotificationRule{{
+		Name:      "merges",
+		Events:    []string{database.EventMerged},
+		Important: true,
+		Actions:   []utils.RuleAction{{Type: utils.ActionPost}},
+	}}
+	db := &database.Database{
+		Store:         database.NewMemoryStore(),
+		NotifyChannel: "C123",
+		Rules:         rules.NewEngine(append(important, rules.DefaultRules()...), "C123", nil),
+	}
+
+	var sent []*database.OutboxMessage
+	send := func(msg *database.OutboxMessage, pr *pr_gh.PullRequest) (string, string, error) {
+		sent = append(sent, msg)
+		return msg.Channel, fmt.Sprintf("1700000000.%06d", len(sent)), nil
+	}
+	scrape := func(mutate func(pr *pr_gh.PullRequest)) map[string]*database.OutboxMessage {
+		t.Helper()
+		pr := storetest.NewPullRequest(1)
+		pr.CIStatus = pr_gh.CIPending
+		pr.HeadSHA = "abc123"
+		mutate(pr)
+		db.PutPullRequests([]*pr_gh.PullRequest{pr})
+
+		sent = nil
+		if _, err := db.DeliverOutbox(send, nil, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		replies := make(map[string]*database.OutboxMessage)
+		for _, msg := range sent {
+			if msg.Event != database.OutboxUpdateEvent {
+				replies[msg.Event] = msg
+			}
+		}
+		return replies
+	}
+
+	if replies := scrape(func(pr *pr_gh.PullRequest) {}); replies[database.EventOpened] == nil || replies[database.EventOpened].ThreadTS != "" {
+		t.Fatalf("announcement = %+v, want a new message", replies)
+	}
+
+	// Later events are replies in the thread of the announcement, which isn't broadcast
+	// unless a rule marks the event as important.
+	replies := scrape(func(pr *pr_gh.PullRequest) {
+		pr.CIStatus = pr_gh.CIFailure
+		pr.HeadSHA = "def456"
+	})
+	for _, event := range []string{lifecycle.CIFailed, lifecycle.NewCommits} {
+		if reply := replies[event]; reply == nil || reply.ThreadTS != "1700000000.000001" || reply.Broadcast {
+			t.Errorf("%s reply = %+v, want an unbroadcast reply in the thread", event, reply)
+		}
+	}
+
+	// An approval which decides the review gets a single reply, about the decision,
+	// rather than one about the review as well.
+	bob := pr_gh.Review{Reviewer: "bob", State: pr_gh.ReviewApproved, URL: "https://github.com/org/repo/pull/1#pullrequestreview-1"}
+	replies = scrape(func(pr *pr_gh.PullRequest) {
+		pr.HeadSHA = "def456"
+		pr.ReviewDecision = pr_gh.ReviewApproved
+		pr.Reviews = []pr_gh.Review{bob}
+	})
+	if len(sent) != 2 || replies[database.EventApproved] == nil || replies[database.EventApproved].Broadcast {
+		t.Errorf("approval sent %+v, want one unbroadcast approved reply and an update", sent)
+	}
+
+	// Later approvals don't change the decision, so they're replied to as reviews.
+	carol := pr_gh.Review{Reviewer: "carol", State: pr_gh.ReviewApproved, URL: "https://github.com/org/repo/pull/1#pullrequestreview-2"}
+	replies = scrape(func(pr *pr_gh.PullRequest) {
+		pr.HeadSHA = "def456"
+		pr.ReviewDecision = pr_gh.ReviewApproved
+		pr.Reviews = []pr_gh.Review{bob, carol}
+	})
+	if len(replies) != 1 || replies[lifecycle.Reviewed] == nil {
+		t.Errorf("second approval replied with %+v, want one reviewed reply", replies)
+	}
+
+	replies = scrape(func(pr *pr_gh.PullRequest) {
+		pr.HeadSHA = "def456"
+		pr.ReviewDecision = pr_gh.ReviewApproved
+		pr.Reviews = []pr_gh.Review{bob, carol}
+		pr.State = pr_gh.StateMerged
+	})
+	if reply := replies[database.EventMerged]; reply == nil || reply.ThreadTS != "1700000000.000001" || !reply.Broadcast || reply.Rule != "merges" {
+		t.Errorf("merged reply = %+v, want a broadcast reply from the merges rule", reply)
+	}
+}
+
+func TestOutboxIdempotency(t *testing.T) {
+	db := &database.Database{Store: database.NewMemoryStore(), NotifyChannel: "C123"}
+	db.PutPullRequests([]*pr_gh.PullRequest{storetest.NewPullRequest(1)})
+
+	pending, _ := db.Store.ListOutboxMessages(database.OutboxPending)
+	if len(pending) != 1 {
+		t.Fatalf("queued %d notifications, want 1", len(pending))
+	}
+	msg := pending[0]
+	again := database.NewOutboxMessage(msg.PK, msg.Event, 1, msg.Channel, time.Now())
+	if again.IdempotencyKey() != msg.IdempotencyKey() {
+		t.Fatalf("idempotency key isn't deterministic")
+	}
+	other := database.NewOutboxMessage(msg.PK, msg.Event, 1, "C456", time.Now())
+	if other.IdempotencyKey() == msg.IdempotencyKey() {
+		t.Fatalf("idempotency key doesn't depend on the destination")
+	}
+
+	// The message was delivered and recorded, but the outbox wasn't updated before a crash.
+	ref := database.NewMessageRef(msg.PK, msg.Event, msg.Channel, "1700000000.000100", time.Now())
+	ref.IdempotencyKey = msg.IdempotencyKey()
+	db.Store.PutMessageRef(ref)
+
+	send := func(msg *database.OutboxMessage, pr *pr_gh.PullRequest) (string, string, error) {
+		t.Fatalf("delivered notification %s was sent again", msg.ID)
+		return "", "", nil
+	}
+	delivered, err := db.DeliverOutbox(send, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered.Sent) != 1 {
+		t.Fatalf("recovered delivery = %+v, want it marked as sent", delivered)
+	}
+}
+
+func TestOutboxQuietHours(t *testing.T) {
+	hours, err := schedule.ParseHours(utils.BusinessHours{Timezone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &database.Database{
+		Store:         database.NewMemoryStore(),
+		NotifyChannel: "C123",
+		Rules:         rules.NewEngine(nil, "C123", map[string]string{"octocat": "U999"}),
+		Schedules:     schedule.Schedules{"C123": hours, "U999": hours},
+		Storm:         utils.StormProtection{MaxPerCycle: 3},
+	}
+	db.PutPullRequests([]*pr_gh.PullRequest{storetest.NewPullRequest(1), storetest.NewPullRequest(2)})
+
+	var sent []*database.OutboxMessage
+	send := func(msg *database.OutboxMessage, pr *pr_gh.PullRequest) (string, string, error) {
+		sent = append(sent, msg)
+		return msg.Channel, fmt.Sprintf("1700000000.%06d", len(sent)), nil
+	}
+	var batches [][]*database.OutboxMessage
+	sendBatch := func(destination string, msgs []*database.OutboxMessage, prs []*pr_gh.PullRequest) (string, string, error) {
+		if len(prs) != len(msgs) || prs[0].PK != msgs[0].PK {
+			t.Fatalf("batch of %d messages got pull requests %v", len(msgs), pks(prs))
+		}
+		batches = append(batches, msgs)
+		return "D999", "1700000000.100000", nil
+	}
+
+	// Saturday morning, so the notifications are held until Monday.
+	saturday := time.Date(2030, 6, 1, 3, 0, 0, 0, time.UTC)
+	delivered, err := db.DeliverOutbox(send, sendBatch, saturday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	monday := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	if len(delivered.Held) != 2 || len(sent) != 0 || !delivered.Held[0].NextAttempt.Equal(monday) {
+		t.Fatalf("delivery during quiet hours = %+v, want both held until %s", delivered, monday)
+	}
+	if delivered, _ = db.DeliverOutbox(send, sendBatch, monday.Add(-time.Minute)); len(delivered.Sent)+len(delivered.Held) != 0 {
+		t.Fatalf("held notifications were released early")
+	}
+
+	// Announcements are sent on their own, since they're updated and replied to later.
+	delivered, err = db.DeliverOutbox(send, sendBatch, monday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivered.Halted || len(delivered.Sent) != 2 || len(sent) != 2 || len(batches) != 0 {
+		t.Fatalf("delivery of held announcements = %+v, want each sent on its own", delivered)
+	}
+	for _, pr := range []*pr_gh.PullRequest{storetest.NewPullRequest(1), storetest.NewPullRequest(2)} {
+		if stored, _ := db.Store.GetPullRequest(pr.PK); stored.SlackTS == "" {
+			t.Errorf("%s wasn't recorded as announced", pr.PK)
+		}
+	}
+
+	// Both are approved over the next weekend, which is replied to in each thread and
+	// sent to the author.
+	var approved []*pr_gh.PullRequest
+	for number := 1; number <= 2; number++ {
+		pr := storetest.NewPullRequest(number)
+		pr.ReviewDecision = pr_gh.ReviewApproved
+		pr.Reviews = []pr_gh.Review{{Reviewer: "bob", State: pr_gh.ReviewApproved, URL: pr.URL + "#pullrequestreview-1"}}
+		approved = append(approved, pr)
+	}
+	db.PutPullRequests(approved)
+	sent = nil
+	if delivered, err = db.DeliverOutbox(send, sendBatch, saturday.AddDate(0, 0, 7)); err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered.Held) != 4 {
+		t.Fatalf("delivery during quiet hours held %d notifications, want 4", len(delivered.Held))
+	}
+
+	// The author gets a single message, which only counts once towards the limits, and
+	// the replies stay in their threads.
+	sent = nil
+	delivered, err = db.DeliverOutbox(send, sendBatch, monday.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivered.Halted || len(delivered.Sent) != 4 || len(batches) != 1 || len(batches[0]) != 2 || batches[0][0].Channel != "U999" {
+		t.Fatalf("delivery at the start of working hours = %+v, batches %v; want one batch to the author", delivered, batches)
+	}
+	for _, msg := range sent {
+		if msg.ThreadTS == "" {
+			t.Errorf("held %s notification to %s was sent outside its thread", msg.Event, msg.Channel)
+		}
+	}
+}
+
+func TestAwaitingReview(t *testing.T) {
+	db := &database.Database{Store: database.NewMemoryStore()}
+
+	older, newer := storetest.NewPullRequest(2), storetest.NewPullRequest(1)
+	older.Created = newer.Created.Add(-time.Hour)
+	draft := storetest.NewPullRequest(3)
+	draft.Draft = true
+	approved := storetest.NewPullRequest(4)
+	approved.ReviewDecision = pr_gh.ReviewApproved
+	other := storetest.NewPullRequest(5)
+	other.Repository = "docs"
+	closed := storetest.NewPullRequest(6)
+	closed.State = pr_gh.StateClosed
+	changes := storetest.NewPullRequest(7)
+	changes.ReviewDecision = pr_gh.ReviewChangesRequested
+	for _, pr := range []*pr_gh.PullRequest{newer, older, draft, approved, other, closed, changes} {
+		db.Store.PutPullRequest(pr)
+	}
+
+	waiting, err := db.AwaitingReview(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPKs(t, "AwaitingReview", waiting, older, newer, other)
+
+	waiting, _ = db.AwaitingReview([]string{"re*"})
+	assertPKs(t, "AwaitingReview(re*)", waiting, older, newer)
+}
+
+func TestReviewQueue(t *testing.T) {
+	db := &database.Database{
+		Store: database.NewMemoryStore(),
+		Teams: map[string][]string{"org/backend": {"Alice", "bob"}},
+	}
+
+	direct, team, other := storetest.NewPullRequest(1), storetest.NewPullRequest(2), storetest.NewPullRequest(3)
+	direct.RequestedReviewers = []string{"alice"}
+	team.RequestedReviewers = []string{"carol", "org/backend"}
+	other.RequestedReviewers = []string{"carol"}
+	draft := storetest.NewPullRequest(4)
+	draft.Draft = true
+	draft.RequestedReviewers = []string{"alice"}
+	for _, pr := range []*pr_gh.PullRequest{team, direct, other, draft} {
+		db.Store.PutPullRequest(pr)
+	}
+
+	queue, err := db.ReviewQueue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPKs(t, "ReviewQueue(alice)", queue, direct, team)
+	queue, _ = db.ReviewQueue("carol")
+	assertPKs(t, "ReviewQueue(carol)", queue, team, other)
+
+	if _, err := db.GetReviewQueueMessage("alice", "U1"); err != database.ItemNotFoundError {
+		t.Fatalf("GetReviewQueueMessage() error = %v, want %v", err, database.ItemNotFoundError)
+	}
+	now := time.Now()
+	if err := db.PutReviewQueueMessage("alice", "U1", "D1", "1700000000.000100", now); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := db.GetReviewQueueMessage("Alice", "U1")
+	if err != nil || ref.Channel != "D1" || ref.Timestamp != "1700000000.000100" || !ref.Posted.Equal(now) {
+		t.Fatalf("GetReviewQueueMessage() = %+v, %v", ref, err)
+	}
+}
+
+// Requested reviewers come from the page of a pull request, so the queue is checked
+// against one which was scraped and stored, rather than filled in by hand.
+func TestReviewQueueFromScrapedPage(t *testing.T) {
+	db := &database.Database{
+		Store: database.NewMemoryStore(),
+		Teams: map[string][]string{"org/backend": {"dave"}},
+	}
+
+	page, err := os.Open("../github/testdata/pull_request.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Close()
+	doc, err := goquery.NewDocumentFromReader(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr := storetest.NewPullRequest(7)
+	pr_gh.ParsePullRequestPage(doc, pr)
+	pr.DetailsLoaded = time.Now()
+	if resp := db.PutPullRequests([]*pr_gh.PullRequest{pr}); len(resp.Uploaded) != 1 {
+		t.Fatalf("PutPullRequests = %+v", resp)
+	}
+
+	for login, want := range map[string][]*pr_gh.PullRequest{
+		"alice": {pr}, // requested directly
+		"dave":  {pr}, // requested through org/backend
+		"bob":   nil,  // already reviewed
+	} {
+		queue, err := db.ReviewQueue(login)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertPKs(t, "ReviewQueue("+login+")", queue, want...)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	db := &database.Database{
+		Store:         database.NewMemoryStore(),
+		NotifyChannel: "C123",
+		Storm:         utils.StormProtection{MaxPerCycle: 2, MaxPerHour: 3},
+	}
+	var sent int
+	send := func(msg *database.OutboxMessage, pr *pr_gh.PullRequest) (string, string, error) {
+		sent++
+		return msg.Channel, "1700000000.000100", nil
+	}
+	put := func(numbers ...int) {
+		var prs []*pr_gh.PullRequest
+		for _, number := range numbers {
+			prs = append(prs, storetest.NewPullRequest(number))
+		}
+		db.PutPullRequests(prs)
+	}
+
+	// Too many at once trips the breaker, and nothing is sent until it's released.
+	put(1, 2, 3)
+	delivered, err := db.DeliverOutbox(send, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !delivered.Halted || delivered.Tripped == nil || sent != 0 {
+		t.Fatalf("delivery of a storm = %+v, want the breaker tripped", delivered)
+	}
+	if tripped := delivered.Tripped; tripped.Held != 3 || tripped.Events[database.EventOpened] != 3 ||
+		!strings.Contains(tripped.Summary(), "3 were due at once (limit 2)") {
+		t.Errorf("tripped breaker = %+v", tripped)
+	}
+	if delivered, _ = db.DeliverOutbox(send, nil, time.Now()); !delivered.Halted || delivered.Tripped != nil || sent != 0 {
+		t.Fatalf("delivery while tripped = %+v, want it halted without alerting again", delivered)
+	}
+
+	if err := db.ReleaseBreaker(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if delivered, _ = db.DeliverOutbox(send, nil, time.Now()); delivered.Halted || sent != 3 {
+		t.Fatalf("delivery after release = %+v, want the held notifications sent", delivered)
+	}
+
+	// Notifications sent within the last hour count towards the hourly limit.
+	put(4, 5)
+	if delivered, _ = db.DeliverOutbox(send, nil, time.Now()); delivered.Halted || sent != 5 {
+		t.Fatalf("delivery within limits = %+v", delivered)
+	}
+	put(6, 7)
+	delivered, _ = db.DeliverOutbox(send, nil, time.Now())
+	if delivered.Tripped == nil || !strings.Contains(delivered.Tripped.Reason, "within an hour") || sent != 5 {
+		t.Fatalf("delivery over the hourly limit = %+v, want the breaker tripped", delivered)
+	}
+
+	discarded, err := db.DiscardPending(time.Now())
+	if err != nil || discarded != 2 {
+		t.Fatalf("DiscardPending() = %d, %v", discarded, err)
+	}
+	if pending, _ := db.Store.ListOutboxMessages(database.OutboxPending); len(pending) != 0 {
+		t.Errorf("%d notifications pending after discarding", len(pending))
+	}
+	if breaker, _ := db.GetBreaker(); breaker.Tripped() {
+		t.Errorf("breaker still tripped after discarding")
+	}
+
+	// Updating the messages which announced pull requests doesn't notify anyone, so it
+	// doesn't count towards the limits.
+	var renamed []*pr_gh.PullRequest
+	for number := 1; number <= 3; number++ {
+		pr := storetest.NewPullRequest(number)
+		pr.Title = "Renamed"
+		renamed = append(renamed, pr)
+	}
+	db.PutPullRequests(renamed)
+	if delivered, _ = db.DeliverOutbox(send, nil, time.Now()); delivered.Halted || sent != 8 {
+		t.Fatalf("delivery of updates = %+v, want them sent without tripping the breaker", delivered)
+	}
+}
+
+func TestPutPullRequestsMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		scrape func(pr *pr_gh.PullRequest)
+		check  func(t *testing.T, stored *pr_gh.PullRequest)
+	}{
+		{"Unchanged", func(pr *pr_gh.PullRequest) {}, func(t *testing.T, stored *pr_gh.PullRequest) {
+			if stored.Version != 1 {
+				t.Errorf("Version = %d, want the record left alone", stored.Version)
+			}
+		}},
+		{"TitleChanged", func(pr *pr_gh.PullRequest) { pr.Title = "Renamed" }, func(t *testing.T, stored *pr_gh.PullRequest) {
+			if stored.Title != "Renamed" {
+				t.Errorf("Title = %q", stored.Title)
+			}
+		}},
+		{"LabelsChanged", func(pr *pr_gh.PullRequest) { pr.Labels = nil }, func(t *testing.T, stored *pr_gh.PullRequest) {
+			if len(stored.Labels) != 0 {
+				t.Errorf("Labels = %v", stored.Labels)
+			}
+		}},
+		{"ConvertedToDraft", func(pr *pr_gh.PullRequest) { pr.Draft = true; pr.ID = 0 }, func(t *testing.T, stored *pr_gh.PullRequest) {
+			if !stored.Draft || stored.ID == 0 {
+				t.Errorf("Draft = %t, ID = %d, want a draft which kept its ID", stored.Draft, stored.ID)
+			}
+		}},
+		{"ChangesRequested", func(pr *pr_gh.PullRequest) { pr.ReviewDecision = pr_gh.ReviewChangesRequested }, func(t *testing.T, stored *pr_gh.PullRequest) {
+			if stored.ReviewDecision != pr_gh.ReviewChangesRequested {
+				t.Errorf("ReviewDecision = %q", stored.ReviewDecision)
+			}
+		}},
+		{"Approved", func(pr *pr_gh.PullRequest) { pr.ReviewDecision = pr_gh.ReviewApproved }, func(t *testing.T, stored *pr_gh.PullRequest) {
+			if stored.ReviewDecision != pr_gh.ReviewApproved {
+				t.Errorf("ReviewDecision = %q", stored.ReviewDecision)
+			}
+		}},
+		{"ReviewDecisionUnknown", func(pr *pr_gh.PullRequest) { pr.ReviewDecision = "" }, func(t *testing.T, stored *pr_gh.PullRequest) {
+			if stored.ReviewDecision != pr_gh.ReviewRequired {
+				t.Errorf("ReviewDecision = %q, want it kept", stored.ReviewDecision)
+			}
+		}},
+		{"Merged", func(pr *pr_gh.PullRequest) { pr.State = pr_gh.StateMerged; pr.ID = 0; pr.ReviewDecision = "" }, func(t *testing.T, stored *pr_gh.PullRequest) {
+			if stored.State != pr_gh.StateMerged || stored.ID == 0 || stored.ReviewDecision != pr_gh.ReviewRequired {
+				t.Errorf("stored = %+v, want merged with its ID and review decision kept", stored)
+			}
+		}},
+		{"Closed", func(pr *pr_gh.PullRequest) { pr.State = pr_gh.StateClosed; pr.ID = 0 }, func(t *testing.T, stored *pr_gh.PullRequest) {
+			if stored.State != pr_gh.StateClosed || stored.ID == 0 {
+				t.Errorf("stored = %+v, want closed with its ID kept", stored)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &database.Database{Store: database.NewMemoryStore()}
+			db.PutPullRequests([]*pr_gh.PullRequest{storetest.NewPullRequest(1)})
+
+			// Stateful fields, and fields the scrape can't see, survive updates.
+			stored, _ := db.Store.GetPullRequest(storetest.NewPullRequest(1).PK)
+			stored.HeadSHA = "aaa"
+			stored.ContentHash = stored.Hash()
+			db.Store.PutPullRequest(stored)
+
+			scraped := storetest.NewPullRequest(1)
+			tt.scrape(scraped)
+			db.PutPullRequests([]*pr_gh.PullRequest{scraped})
+
+			stored, err := db.Store.GetPullRequest(scraped.PK)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !stored.Notified || stored.HeadSHA != "aaa" {
+				t.Errorf("stored = %+v, want Notified and HeadSHA kept", stored)
+			}
+			if stored.ContentHash != stored.Hash() {
+				t.Errorf("ContentHash doesn't match the stored record")
+			}
+			tt.check(t, stored)
+		})
+	}
+}
+
+func TestQueueReminders(t *testing.T) {
+	store := database.NewMemoryStore()
+	if _, err := database.ParseReminderSchedules([]utils.ReminderSchedule{{
+		Name: "broken", BusinessHours: utils.BusinessHours{Timezone: "Nowhere/Special"},
+	}}); err == nil {
+		t.Fatal("ParseReminderSchedules accepted an unknown timezone")
+	}
+
+	reminders, err := database.ParseReminderSchedules([]utils.ReminderSchedule{{
+		Name:          "reviews",
+		BusinessHours: utils.BusinessHours{Timezone: "UTC"},
+		Steps: []utils.ReminderStep{
+			{AfterHours: 12, Action: utils.ReminderEscalate, Channel: "C999"},
+			{AfterHours: 4, Action: utils.ReminderThreadReply},
+			{AfterHours: 8, Action: utils.ReminderMentionReviewers},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newDatabase := func() *database.Database {
+		return &database.Database{
+			Store:         store,
+			NotifyChannel: "C123",
+			Reminders:     reminders,
+			SlackUsers:    map[string]string{"alice": "U1"},
+		}
+	}
+	db := newDatabase()
+
+	// Opened at noon on a Friday, which starts the review clock.
+	pr := storetest.NewPullRequest(1)
+	pr.RequestedReviewers = []string{"alice", "bob"}
+	db.PutPullRequests([]*pr_gh.PullRequest{pr})
+	send := func(msg *database.OutboxMessage, pr *pr_gh.PullRequest) (string, string, error) {
+		return msg.Channel, "1700000000.000100", nil
+	}
+	db.DeliverOutbox(send, nil, time.Now())
+
+	queue := func(at time.Time) []*database.OutboxMessage {
+		t.Helper()
+		queued, err := db.QueueReminders(at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return queued
+	}
+	friday := func(hour int) time.Time { return time.Date(2022, 7, 1, hour, 5, 0, 0, time.UTC) }
+	monday := func(hour int) time.Time { return time.Date(2022, 7, 4, hour, 5, 0, 0, time.UTC) }
+
+	if queued := queue(friday(15)); len(queued) != 0 {
+		t.Fatalf("reminded after 3 business hours: %+v", queued)
+	}
+	queued := queue(friday(16))
+	if len(queued) != 1 || queued[0].Event != database.EventReminded || queued[0].ThreadTS != "1700000000.000100" || len(queued[0].Users) != 0 {
+		t.Fatalf("first reminder = %+v, want a reply in the original thread", queued)
+	}
+	if queued := queue(friday(23)); len(queued) != 0 {
+		t.Fatalf("reminded twice for the same step: %+v", queued)
+	}
+
+	// A restart doesn't reset the clock, and weekends don't count towards it.
+	db = newDatabase()
+	if queued := queue(monday(11)); len(queued) != 0 {
+		t.Fatalf("reminded after 7 business hours: %+v", queued)
+	}
+	queued = queue(monday(12))
+	if len(queued) != 1 || queued[0].ThreadTS == "" || !reflect.DeepEqual(queued[0].Users, []string{"U1"}) {
+		t.Fatalf("second reminder = %+v, want the mapped reviewers mentioned in the thread", queued)
+	}
+	queued = queue(monday(16))
+	if len(queued) != 1 || queued[0].Event != database.EventEscalated || queued[0].Channel != "C999" || queued[0].ThreadTS != "" {
+		t.Fatalf("escalation = %+v, want a new message in the lead channel", queued)
+	}
+	if queued := queue(monday(17).Add(72 * time.Hour)); len(queued) != 0 {
+		t.Fatalf("reminded after the last step: %+v", queued)
+	}
+
+	timeline, _ := db.GetTimeline(pr.PK)
+	if timeline.First(database.EventReminded) == nil || timeline.First(database.EventEscalated) == nil {
+		t.Errorf("reminders weren't recorded in the timeline")
+	}
+
+	// Review activity stops the clock, and it starts again from the first step once a
+	// review is required again.
+	pr.ReviewDecision = pr_gh.ReviewChangesRequested
+	db.PutPullRequests([]*pr_gh.PullRequest{pr})
+	stored, _ := store.GetPullRequest(pr.PK)
+	if !stored.WaitingSince.IsZero() || stored.ReminderStep != 0 {
+		t.Fatalf("stored = %+v, want the review clock stopped", stored)
+	}
+	if queued := queue(monday(17).Add(7 * 24 * time.Hour)); len(queued) != 0 {
+		t.Fatalf("reminded about a reviewed pull request: %+v", queued)
+	}
+
+	pr.ReviewDecision = pr_gh.ReviewRequired
+	db.PutPullRequests([]*pr_gh.PullRequest{pr})
+	stored, _ = store.GetPullRequest(pr.PK)
+	if stored.WaitingSince.IsZero() || stored.ReminderStep != 0 {
+		t.Fatalf("stored = %+v, want the review clock restarted", stored)
+	}
+
+	// A review stops the clock too, even if more are required.
+	pr.Reviews = []pr_gh.Review{{Reviewer: "alice", State: pr_gh.ReviewApproved, URL: pr.URL + "#pullrequestreview-1"}}
+	db.PutPullRequests([]*pr_gh.PullRequest{pr})
+	stored, _ = store.GetPullRequest(pr.PK)
+	if !stored.WaitingSince.IsZero() {
+		t.Fatalf("stored = %+v, want the review clock stopped by the review", stored)
+	}
+}
@@ -0,0 +1,217 @@
+package database
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+	"github.com/ooojustin/pr-puller/pkg/schedule"
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+// Events which start a pull request's review clock, and the original messages which
+// reminders reply to.
+var waitingEvents = []string{lifecycle.Opened, lifecycle.ReadyForReview, lifecycle.ReviewRequired}
+
+// Start or stop the review clock of a pull request, based on the lifecycle events of
+// a write. The clock starts when it's opened, leaves draft, or needs review again, and
+// stops once it's reviewed, converted to a draft, or closed.
+func trackWaiting(pr *pr_gh.PullRequest, events []*lifecycle.Event) {
+	for _, event := range events {
+		switch event.Type {
+		case lifecycle.Opened, lifecycle.ReadyForReview, lifecycle.ReviewRequired:
+			if event.Type == lifecycle.Opened && event.Old == "draft" {
+				continue
+			}
+			if pr.WaitingSince.IsZero() {
+				pr.WaitingSince = event.Timestamp
+				pr.ReminderStep = 0
+			}
+		case lifecycle.Reviewed, lifecycle.ChangesRequested, lifecycle.Approved,
+			lifecycle.ConvertedToDraft, lifecycle.Closed, lifecycle.Merged:
+			pr.WaitingSince = time.Time{}
+			pr.ReminderStep = 0
+		}
+	}
+}
+
+// ReminderSchedule is a reminder schedule from config, with its business hours parsed
+// and its steps sorted by when they're taken.
+type ReminderSchedule struct {
+	utils.ReminderSchedule
+	Hours *schedule.Hours
+}
+
+// Parse the reminder schedules from config.
+func ParseReminderSchedules(cfg []utils.ReminderSchedule) ([]ReminderSchedule, error) {
+	schedules := make([]ReminderSchedule, 0, len(cfg))
+	for _, scheduleCfg := range cfg {
+		hours, err := schedule.ParseHours(scheduleCfg.BusinessHours)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", scheduleCfg.Name, err)
+		}
+
+		steps := append([]utils.ReminderStep(nil), scheduleCfg.Steps...)
+		sort.SliceStable(steps, func(i, j int) bool {
+			return steps[i].AfterHours < steps[j].AfterHours
+		})
+		scheduleCfg.Steps = steps
+
+		schedules = append(schedules, ReminderSchedule{ReminderSchedule: scheduleCfg, Hours: hours})
+	}
+	return schedules, nil
+}
+
+// Find the first schedule which applies to a pull request.
+func matchReminderSchedule(schedules []ReminderSchedule, pr *pr_gh.PullRequest) *ReminderSchedule {
+	for idx := range schedules {
+		s := &schedules[idx]
+		if len(s.Repositories) > 0 && !matchesAny(s.Repositories, pr.Repository) {
+			continue
+		}
+		if len(s.Labels) > 0 && !hasAnyLabel(pr, s.Labels) {
+			continue
+		}
+		return s
+	}
+	return nil
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyLabel(pr *pr_gh.PullRequest, labels []string) bool {
+	for _, label := range labels {
+		for _, prLabel := range pr.Labels {
+			if label == prLabel {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Queue reminders for pull requests which have waited long enough for review. If
+// several steps are due at once, such as after downtime, only the last is taken.
+// The step is recorded on the pull request in the same write which queues the
+// reminder, so each step is taken once, even across restarts.
+func (db *Database) QueueReminders(now time.Time) ([]*OutboxMessage, error) {
+	if len(db.Reminders) == 0 {
+		return nil, nil
+	}
+
+	prs, err := db.Store.QueryOpenPullRequests()
+	if err != nil {
+		return nil, err
+	}
+
+	var queued []*OutboxMessage
+	for _, pr := range prs {
+		if pr.WaitingSince.IsZero() || pr.Draft {
+			continue
+		}
+
+		s := matchReminderSchedule(db.Reminders, pr)
+		if s == nil {
+			continue
+		}
+
+		waited := s.Hours.Between(pr.WaitingSince, now)
+		due := -1
+		for idx := pr.ReminderStep; idx < len(s.Steps); idx++ {
+			if waited >= time.Duration(s.Steps[idx].AfterHours*float64(time.Hour)) {
+				due = idx
+			}
+		}
+		if due < 0 {
+			continue
+		}
+
+		expectedVersion := pr.Version
+		pr.Version++
+		pr.ReminderStep = due + 1
+		pr.UpdatedAt = now.UTC()
+
+		var outbox []*OutboxMessage
+		if msg := db.reminderMessage(pr, s.Steps[due], now); msg != nil {
+			outbox = append(outbox, msg)
+		}
+
+		err := db.Store.PutPullRequestIfVersion(pr, expectedVersion, outbox...)
+		if err == VersionConflictError {
+			// Changed since it was read, so it's looked at again next time.
+			continue
+		} else if err != nil {
+			fmt.Printf("Failed to queue reminder for %s: %s\n", pr.PK, err)
+			continue
+		}
+
+		eventType := EventReminded
+		if s.Steps[due].Action == utils.ReminderEscalate {
+			eventType = EventEscalated
+		}
+		event := NewHistoryEvent(pr.PK, eventType, "", fmt.Sprintf("%s step %d", s.Name, due+1), now)
+		if err := db.Store.PutHistoryEvent(event); err != nil {
+			fmt.Printf("Failed to record %s event for %s: %s\n", event.Type, pr.PK, err)
+		}
+
+		queued = append(queued, outbox...)
+	}
+
+	return queued, nil
+}
+
+// Create the outbox message for a reminder step, or nil if it has nowhere to go.
+func (db *Database) reminderMessage(pr *pr_gh.PullRequest, step utils.ReminderStep, now time.Time) *OutboxMessage {
+	channel := step.Channel
+	if channel == "" {
+		channel = db.NotifyChannel
+	}
+	if channel == "" {
+		return nil
+	}
+
+	if step.Action == utils.ReminderEscalate {
+		return NewOutboxMessage(pr.PK, EventEscalated, pr.Version, channel, now)
+	}
+
+	msg := NewOutboxMessage(pr.PK, EventReminded, pr.Version, channel, now)
+	msg.ThreadTS = db.originalMessage(pr.PK, channel)
+	if step.Action == utils.ReminderMentionReviewers {
+		for _, reviewer := range pr.RequestedReviewers {
+			if user, ok := db.SlackUsers[reviewer]; ok {
+				msg.Users = append(msg.Users, user)
+			}
+		}
+	}
+	return msg
+}
+
+// Get the timestamp of the latest message which announced a pull request as ready for
+// review in a channel, or an empty string if there isn't one.
+func (db *Database) originalMessage(pr_uid string, channel string) string {
+	var latest *MessageRef
+	for _, event := range waitingEvents {
+		ref, err := db.Store.GetMessageRef(messageRefKey(pr_uid, event, channel))
+		if err != nil {
+			continue
+		}
+		if latest == nil || ref.Posted.After(latest.Posted) {
+			latest = ref
+		}
+	}
+
+	if latest == nil {
+		return ""
+	}
+	return latest.Timestamp
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+)
+
+// Reasons a record is selected to be purged.
+const (
+	PurgeExpired         string = "expired"
+	PurgeNoExpiry        string = "closed without expiry"
+	PurgeOrphanedHistory string = "orphaned history"
+	PurgeExpiredOutbox   string = "expired notification"
+)
+
+// PurgeCandidate is a pull request whose record and history can be deleted, or a
+// notification which can be deleted from the outbox, if OutboxID is set.
+type PurgeCandidate struct {
+	PK        string
+	Reason    string
+	State     string
+	ExpiresAt time.Time
+	OutboxID  string
+}
+
+// Determine when a pull request should expire. Open pull requests are kept forever,
+// while merged or closed ones expire once the retention period has passed since we
+// first saw them leave the open state.
+func (db *Database) expiresAt(existingPR *pr_gh.PullRequest, pr *pr_gh.PullRequest, now time.Time) int64 {
+	if pr.State == pr_gh.StateOpen || db.Retention <= 0 {
+		return 0
+	}
+	if existingPR != nil && existingPR.ExpiresAt != 0 {
+		return existingPR.ExpiresAt
+	}
+	return now.Add(db.Retention).Unix()
+}
+
+// Find every record which should be deleted, along with its history:
+//   - Records whose expiry has passed. DynamoDB deletes these itself eventually, but
+//     other backends don't, and DynamoDB doesn't delete the history that goes with them.
+//   - Merged or closed records stored before a retention period was configured. Since
+//     we don't know when they were closed, they're purged once they were opened longer
+//     ago than the retention period.
+//   - History of pull requests whose record no longer exists.
+//   - Notifications which were sent or discarded longer ago than they're kept, which
+//     DynamoDB deletes itself eventually, but other backends don't.
+func (db *Database) FindPurgeable(now time.Time) ([]*PurgeCandidate, error) {
+	prs, err := db.Store.ScanPullRequests()
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*PurgeCandidate
+	stored := make(map[string]bool)
+	for _, pr := range prs {
+		stored[pr.PK] = true
+
+		candidate := &PurgeCandidate{PK: pr.PK, State: pr.State}
+		if pr.ExpiresAt != 0 {
+			candidate.ExpiresAt = time.Unix(pr.ExpiresAt, 0)
+		}
+
+		if pr.ExpiresAt != 0 && pr.ExpiresAt <= now.Unix() {
+			candidate.Reason = PurgeExpired
+		} else if pr.ExpiresAt == 0 && pr.State != pr_gh.StateOpen &&
+			db.Retention > 0 && pr.Created.Add(db.Retention).Before(now) {
+			candidate.Reason = PurgeNoExpiry
+		} else {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	pr_uids, err := db.Store.ListHistoryPKs()
+	if err != nil {
+		return nil, err
+	}
+	for _, pr_uid := range pr_uids {
+		if !stored[pr_uid] {
+			candidates = append(candidates, &PurgeCandidate{PK: pr_uid, Reason: PurgeOrphanedHistory})
+		}
+	}
+
+	for _, status := range []string{OutboxSent, OutboxDiscarded} {
+		msgs, err := db.Store.ListOutboxMessages(status)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			if msg.ExpiresAt == 0 || msg.ExpiresAt > now.Unix() {
+				continue
+			}
+			candidates = append(candidates, &PurgeCandidate{
+				PK:        msg.PK,
+				Reason:    PurgeExpiredOutbox,
+				State:     msg.Status,
+				ExpiresAt: time.Unix(msg.ExpiresAt, 0),
+				OutboxID:  msg.ID,
+			})
+		}
+	}
+
+	return candidates, nil
+}
+
+// Delete the records and history of purge candidates, and the notifications which are
+// candidates. History is deleted first, so anything left over from a failure is still
+// found by the next purge.
+func (db *Database) Purge(candidates []*PurgeCandidate) (int, error) {
+	var purged int
+	for _, candidate := range candidates {
+		if candidate.OutboxID != "" {
+			if err := db.Store.DeleteOutboxMessage(candidate.OutboxID); err != nil {
+				return purged, fmt.Errorf("failed to delete notification %s: %w", candidate.OutboxID, err)
+			}
+			purged++
+			continue
+		}
+
+		if err := db.Store.DeleteHistory(candidate.PK); err != nil {
+			return purged, fmt.Errorf("failed to delete history of %s: %w", candidate.PK, err)
+		}
+		if candidate.Reason != PurgeOrphanedHistory {
+			if err := db.Store.DeletePullRequest(candidate.PK); err != nil {
+				return purged, fmt.Errorf("failed to delete %s: %w", candidate.PK, err)
+			}
+		}
+		purged++
+	}
+	return purged, nil
+}
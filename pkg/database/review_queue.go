@@ -0,0 +1,64 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+)
+
+// Event which review queue messages are recorded under.
+const EventReviewQueue string = "review_queue"
+
+// Get the pull requests waiting for a user's review, oldest first. They're waiting
+// for the user if the user, or a team the user is a member of, is a requested reviewer.
+func (db *Database) ReviewQueue(login string) ([]*pr_gh.PullRequest, error) {
+	waiting, err := db.AwaitingReview(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var queue []*pr_gh.PullRequest
+	for _, pr := range waiting {
+		for _, reviewer := range pr.RequestedReviewers {
+			if strings.EqualFold(reviewer, login) || db.isTeamMember(reviewer, login) {
+				queue = append(queue, pr)
+				break
+			}
+		}
+	}
+	return queue, nil
+}
+
+func (db *Database) isTeamMember(team string, login string) bool {
+	for name, members := range db.Teams {
+		if !strings.EqualFold(name, team) {
+			continue
+		}
+		for _, member := range members {
+			if strings.EqualFold(member, login) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Review queue messages are recorded like messages about pull requests, under a key
+// for the user instead of a pull request.
+func reviewQueueKey(login string) string {
+	return EventReviewQueue + "#" + strings.ToLower(login)
+}
+
+// Get the last review queue message sent to a user, which is posted in channel.
+// Returns ItemNotFoundError if one was never sent.
+func (db *Database) GetReviewQueueMessage(login string, user string) (*MessageRef, error) {
+	return db.Store.GetMessageRef(messageRefKey(reviewQueueKey(login), EventReviewQueue, user))
+}
+
+// Record the review queue message sent to a user, which was posted in channel.
+func (db *Database) PutReviewQueueMessage(login string, user string, channel string, ts string, posted time.Time) error {
+	ref := NewMessageRef(reviewQueueKey(login), EventReviewQueue, channel, ts, posted)
+	ref.Key = messageRefKey(reviewQueueKey(login), EventReviewQueue, user)
+	return db.Store.PutMessageRef(ref)
+}
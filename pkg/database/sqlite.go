@@ -0,0 +1,587 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	_ "modernc.org/sqlite"
+)
+
+const defaultSQLitePath string = "./pr-slacker.db"
+
+// SQLiteStore keeps state in a local SQLite database file. Records are stored as JSON
+// alongside the columns needed to look them up.
+type SQLiteStore struct {
+	DB       *sql.DB
+	replacer *strings.Replacer
+}
+
+// Names of the tables used by the SQLite backend, unless configured otherwise.
+func DefaultSQLiteTables() Tables {
+	return Tables{
+		PullRequests: "pull_requests",
+		History:      "pull_request_history",
+		Leases:       "leases",
+		Meta:         "meta",
+		Messages:     "messages",
+		Outbox:       "outbox",
+	}
+}
+
+func NewSQLiteStore(path string, tables Tables) (*SQLiteStore, error) {
+	if path == "" {
+		path = defaultSQLitePath
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only supports a single writer, so avoid "database is locked" errors
+	// by funnelling every statement through one connection.
+	db.SetMaxOpenConns(1)
+
+	// Queries refer to tables by placeholders, which are replaced with quoted names.
+	quote := func(name string) string {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	replacer := strings.NewReplacer(
+		"{pull_requests}", quote(tables.PullRequests),
+		"{pull_requests_state}", quote(tables.PullRequests+"_state"),
+		"{history}", quote(tables.History),
+		"{leases}", quote(tables.Leases),
+		"{meta}", quote(tables.Meta),
+		"{messages}", quote(tables.Messages),
+		"{outbox}", quote(tables.Outbox),
+		"{outbox_status}", quote(tables.Outbox+"_status"),
+		"{outbox_sent}", quote(tables.Outbox+"_sent"),
+	)
+
+	store := &SQLiteStore{
+		DB:       db,
+		replacer: replacer,
+	}
+
+	return store, nil
+}
+
+func (ss *SQLiteStore) sql(query string) string {
+	return ss.replacer.Replace(query)
+}
+
+func (ss *SQLiteStore) Provision() error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS {pull_requests} (
+			pr_uid TEXT PRIMARY KEY,
+			state  TEXT NOT NULL,
+			data   TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS {history} (
+			pr_uid   TEXT NOT NULL,
+			event_id TEXT NOT NULL,
+			data     TEXT NOT NULL,
+			PRIMARY KEY (pr_uid, event_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS {pull_requests_state} ON {pull_requests} (state)`,
+		`CREATE TABLE IF NOT EXISTS {leases} (
+			lease_name TEXT PRIMARY KEY,
+			owner      TEXT NOT NULL,
+			expires    INTEGER NOT NULL,
+			heartbeat  TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS {meta} (
+			meta_key TEXT PRIMARY KEY,
+			value    TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS {messages} (
+			message_key TEXT PRIMARY KEY,
+			data        TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS {outbox} (
+			outbox_id TEXT PRIMARY KEY,
+			status    TEXT NOT NULL,
+			created   TEXT NOT NULL,
+			data      TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS {outbox_status} ON {outbox} (status, created)`,
+		`CREATE INDEX IF NOT EXISTS {outbox_sent} ON {outbox} (status, julianday(json_extract(data, '$.sent')))`,
+	}
+
+	for _, statement := range statements {
+		if _, err := ss.DB.Exec(ss.sql(statement)); err != nil {
+			fmt.Println("Failed to create SQLite table:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *SQLiteStore) GetMeta(key string) (string, error) {
+	var value string
+	row := ss.DB.QueryRow(ss.sql(`SELECT value FROM {meta} WHERE meta_key = ?`), key)
+	if err := row.Scan(&value); err == sql.ErrNoRows {
+		return "", ItemNotFoundError
+	} else if err != nil {
+		fmt.Println("Failed to select meta value:", err)
+		return "", err
+	}
+	return value, nil
+}
+
+func (ss *SQLiteStore) PutMeta(key string, value string) error {
+	_, err := ss.DB.Exec(ss.sql(`INSERT OR REPLACE INTO {meta} (meta_key, value) VALUES (?, ?)`), key, value)
+	if err != nil {
+		fmt.Println("Failed to insert meta value:", err)
+	}
+	return err
+}
+
+func (ss *SQLiteStore) GetPullRequest(pr_uid string) (*pr_gh.PullRequest, error) {
+	var data string
+	row := ss.DB.QueryRow(ss.sql(`SELECT data FROM {pull_requests} WHERE pr_uid = ?`), pr_uid)
+	if err := row.Scan(&data); err == sql.ErrNoRows {
+		return nil, ItemNotFoundError
+	} else if err != nil {
+		fmt.Println("Failed to select PullRequest:", err)
+		return nil, err
+	}
+
+	return unmarshalSQLitePullRequest(pr_uid, data)
+}
+
+func (ss *SQLiteStore) PutPullRequest(pr *pr_gh.PullRequest) error {
+	return ss.putPullRequest(ss.DB, pr)
+}
+
+func (ss *SQLiteStore) PutPullRequestIfVersion(pr *pr_gh.PullRequest, expectedVersion int, outbox ...*OutboxMessage) error {
+	if len(outbox) == 0 {
+		return ss.putPullRequestIfVersion(ss.DB, pr, expectedVersion)
+	}
+
+	tx, err := ss.DB.Begin()
+	if err != nil {
+		fmt.Println("Failed to begin transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := ss.putPullRequestIfVersion(tx, pr, expectedVersion); err != nil {
+		return err
+	}
+	for _, msg := range outbox {
+		if err := ss.putOutboxMessage(tx, msg); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Failed to commit PullRequest:", err)
+		return err
+	}
+	return nil
+}
+
+func (ss *SQLiteStore) putPullRequestIfVersion(execer sqlExecer, pr *pr_gh.PullRequest, expectedVersion int) error {
+	data, err := json.Marshal(pr)
+	if err != nil {
+		fmt.Println("Failed to marshal PullRequest:", err)
+		return err
+	}
+
+	var result sql.Result
+	if expectedVersion == 0 {
+		// Insert, or replace a row which was written before versioning.
+		result, err = execer.Exec(
+			ss.sql(`INSERT INTO {pull_requests} (pr_uid, state, data) VALUES (?, ?, ?)
+			ON CONFLICT (pr_uid) DO UPDATE SET state = excluded.state, data = excluded.data
+			WHERE COALESCE(json_extract({pull_requests}.data, '$.version'), 0) = 0`),
+			pr.PK, pr.State, string(data),
+		)
+	} else {
+		result, err = execer.Exec(
+			ss.sql(`UPDATE {pull_requests} SET state = ?, data = ?
+			WHERE pr_uid = ? AND json_extract(data, '$.version') = ?`),
+			pr.State, string(data), pr.PK, expectedVersion,
+		)
+	}
+	if err != nil {
+		fmt.Println("Failed to write PullRequest:", err)
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return VersionConflictError
+	}
+
+	return nil
+}
+
+func (ss *SQLiteStore) BatchGetPullRequests(pr_uids []string) (map[string]*pr_gh.PullRequest, error) {
+	prs := make(map[string]*pr_gh.PullRequest)
+	if len(pr_uids) == 0 {
+		return prs, nil
+	}
+
+	args := make([]interface{}, len(pr_uids))
+	for idx, pr_uid := range pr_uids {
+		args[idx] = pr_uid
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(pr_uids)), ",")
+	query := ss.sql(fmt.Sprintf(`SELECT pr_uid, data FROM {pull_requests} WHERE pr_uid IN (%s)`, placeholders))
+
+	rows, err := ss.DB.Query(query, args...)
+	if err != nil {
+		fmt.Println("Failed to select PullRequests:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pr_uid, data string
+		if err := rows.Scan(&pr_uid, &data); err != nil {
+			return nil, err
+		}
+
+		pr, err := unmarshalSQLitePullRequest(pr_uid, data)
+		if err != nil {
+			return nil, err
+		}
+		prs[pr_uid] = pr
+	}
+
+	return prs, rows.Err()
+}
+
+func (ss *SQLiteStore) QueryOpenPullRequests() ([]*pr_gh.PullRequest, error) {
+	return ss.queryPullRequests(ss.sql(`SELECT pr_uid, data FROM {pull_requests} WHERE state = ?`), pr_gh.StateOpen)
+}
+
+func (ss *SQLiteStore) ScanPullRequests() ([]*pr_gh.PullRequest, error) {
+	return ss.queryPullRequests(ss.sql(`SELECT pr_uid, data FROM {pull_requests}`))
+}
+
+// The first pr_uid at or after the prefix is found with the primary key's index.
+func (ss *SQLiteStore) HasPullRequests(prefix string) (bool, error) {
+	var pr_uid string
+	row := ss.DB.QueryRow(ss.sql(`SELECT pr_uid FROM {pull_requests} WHERE pr_uid >= ? ORDER BY pr_uid LIMIT 1`), prefix)
+	if err := row.Scan(&pr_uid); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		fmt.Println("Failed to select PullRequest:", err)
+		return false, err
+	}
+	return strings.HasPrefix(pr_uid, prefix), nil
+}
+
+func (ss *SQLiteStore) queryPullRequests(query string, args ...interface{}) ([]*pr_gh.PullRequest, error) {
+	rows, err := ss.DB.Query(query, args...)
+	if err != nil {
+		fmt.Println("Failed to select PullRequests:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prs []*pr_gh.PullRequest
+	for rows.Next() {
+		var pr_uid, data string
+		if err := rows.Scan(&pr_uid, &data); err != nil {
+			return nil, err
+		}
+
+		pr, err := unmarshalSQLitePullRequest(pr_uid, data)
+		if err != nil {
+			return nil, err
+		}
+		prs = append(prs, pr)
+	}
+
+	return prs, rows.Err()
+}
+
+func (ss *SQLiteStore) DeletePullRequest(pr_uid string) error {
+	_, err := ss.DB.Exec(ss.sql(`DELETE FROM {pull_requests} WHERE pr_uid = ?`), pr_uid)
+	if err != nil {
+		fmt.Println("Failed to delete PullRequest:", err)
+	}
+	return err
+}
+
+func (ss *SQLiteStore) PutHistoryEvent(event *HistoryEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		fmt.Println("Failed to marshal HistoryEvent:", err)
+		return err
+	}
+
+	_, err = ss.DB.Exec(
+		ss.sql(`INSERT OR REPLACE INTO {history} (pr_uid, event_id, data) VALUES (?, ?, ?)`),
+		event.PK, event.EventID, string(data),
+	)
+	if err != nil {
+		fmt.Println("Failed to insert HistoryEvent:", err)
+		return err
+	}
+
+	return nil
+}
+
+func (ss *SQLiteStore) GetHistory(pr_uid string) ([]*HistoryEvent, error) {
+	rows, err := ss.DB.Query(
+		ss.sql(`SELECT data FROM {history} WHERE pr_uid = ? ORDER BY event_id`),
+		pr_uid,
+	)
+	if err != nil {
+		fmt.Println("Failed to select HistoryEvents:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*HistoryEvent
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var event HistoryEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+func (ss *SQLiteStore) DeleteHistory(pr_uid string) error {
+	_, err := ss.DB.Exec(ss.sql(`DELETE FROM {history} WHERE pr_uid = ?`), pr_uid)
+	if err != nil {
+		fmt.Println("Failed to delete HistoryEvents:", err)
+	}
+	return err
+}
+
+func (ss *SQLiteStore) ListHistoryPKs() ([]string, error) {
+	rows, err := ss.DB.Query(ss.sql(`SELECT DISTINCT pr_uid FROM {history} ORDER BY pr_uid`))
+	if err != nil {
+		fmt.Println("Failed to select history keys:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pr_uids []string
+	for rows.Next() {
+		var pr_uid string
+		if err := rows.Scan(&pr_uid); err != nil {
+			return nil, err
+		}
+		pr_uids = append(pr_uids, pr_uid)
+	}
+
+	return pr_uids, rows.Err()
+}
+
+func (ss *SQLiteStore) PutMessageRef(ref *MessageRef) error {
+	data, err := json.Marshal(ref)
+	if err != nil {
+		fmt.Println("Failed to marshal MessageRef:", err)
+		return err
+	}
+
+	_, err = ss.DB.Exec(
+		ss.sql(`INSERT OR REPLACE INTO {messages} (message_key, data) VALUES (?, ?)`),
+		ref.Key, string(data),
+	)
+	if err != nil {
+		fmt.Println("Failed to insert MessageRef:", err)
+	}
+	return err
+}
+
+func (ss *SQLiteStore) GetMessageRef(key string) (*MessageRef, error) {
+	var data string
+	row := ss.DB.QueryRow(ss.sql(`SELECT data FROM {messages} WHERE message_key = ?`), key)
+	if err := row.Scan(&data); err == sql.ErrNoRows {
+		return nil, ItemNotFoundError
+	} else if err != nil {
+		fmt.Println("Failed to select MessageRef:", err)
+		return nil, err
+	}
+
+	var ref MessageRef
+	if err := json.Unmarshal([]byte(data), &ref); err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+func (ss *SQLiteStore) ListMessageRefs() ([]*MessageRef, error) {
+	rows, err := ss.DB.Query(ss.sql(`SELECT data FROM {messages} ORDER BY message_key`))
+	if err != nil {
+		fmt.Println("Failed to select MessageRefs:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []*MessageRef
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var ref MessageRef
+		if err := json.Unmarshal([]byte(data), &ref); err != nil {
+			return nil, err
+		}
+		refs = append(refs, &ref)
+	}
+
+	return refs, rows.Err()
+}
+
+func (ss *SQLiteStore) PutOutboxMessage(msg *OutboxMessage) error {
+	return ss.putOutboxMessage(ss.DB, msg)
+}
+
+func (ss *SQLiteStore) putOutboxMessage(execer sqlExecer, msg *OutboxMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Println("Failed to marshal OutboxMessage:", err)
+		return err
+	}
+
+	_, err = execer.Exec(
+		ss.sql(`INSERT OR REPLACE INTO {outbox} (outbox_id, status, created, data) VALUES (?, ?, ?, ?)`),
+		msg.ID, msg.Status, msg.Created.Format(historyTimeFormat), string(data),
+	)
+	if err != nil {
+		fmt.Println("Failed to insert OutboxMessage:", err)
+	}
+	return err
+}
+
+func (ss *SQLiteStore) ListOutboxMessages(status string) ([]*OutboxMessage, error) {
+	return ss.queryOutboxMessages(
+		ss.sql(`SELECT data FROM {outbox} WHERE status = ? ORDER BY created, outbox_id`),
+		status,
+	)
+}
+
+// Sent times are stored as RFC 3339 strings with trailing zeros trimmed, which don't
+// sort by time, so they're compared as Julian days, which an index is kept on.
+func (ss *SQLiteStore) ListSentOutboxMessages(since time.Time) ([]*OutboxMessage, error) {
+	return ss.queryOutboxMessages(
+		ss.sql(`SELECT data FROM {outbox} WHERE status = ? AND julianday(json_extract(data, '$.sent')) >= julianday(?) ORDER BY created, outbox_id`),
+		OutboxSent, since.UTC().Format(historyTimeFormat),
+	)
+}
+
+func (ss *SQLiteStore) queryOutboxMessages(query string, args ...interface{}) ([]*OutboxMessage, error) {
+	rows, err := ss.DB.Query(query, args...)
+	if err != nil {
+		fmt.Println("Failed to select OutboxMessages:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []*OutboxMessage
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var msg OutboxMessage
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, &msg)
+	}
+
+	return msgs, rows.Err()
+}
+
+func (ss *SQLiteStore) DeleteOutboxMessage(id string) error {
+	_, err := ss.DB.Exec(ss.sql(`DELETE FROM {outbox} WHERE outbox_id = ?`), id)
+	if err != nil {
+		fmt.Println("Failed to delete OutboxMessage:", err)
+	}
+	return err
+}
+
+func (ss *SQLiteStore) AcquireLease(name string, owner string, ttl time.Duration) (*Lease, error) {
+	lease := newLease(name, owner, ttl)
+	result, err := ss.DB.Exec(
+		ss.sql(`INSERT INTO {leases} (lease_name, owner, expires, heartbeat) VALUES (?, ?, ?, ?)
+		ON CONFLICT (lease_name) DO UPDATE
+		SET owner = excluded.owner, expires = excluded.expires, heartbeat = excluded.heartbeat
+		WHERE {leases}.owner = excluded.owner OR {leases}.expires <= ?`),
+		lease.Name, lease.Owner, lease.Expires, lease.Heartbeat.Format(time.RFC3339Nano),
+		lease.Heartbeat.UnixMilli(),
+	)
+	if err != nil {
+		fmt.Println("Failed to acquire lease:", err)
+		return nil, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if affected == 0 {
+		return nil, LeaseHeldError
+	}
+
+	return lease, nil
+}
+
+func (ss *SQLiteStore) ReleaseLease(name string, owner string) error {
+	_, err := ss.DB.Exec(ss.sql(`DELETE FROM {leases} WHERE lease_name = ? AND owner = ?`), name, owner)
+	if err != nil {
+		fmt.Println("Failed to release lease:", err)
+	}
+	return err
+}
+
+// Common interface of *sql.DB and *sql.Tx, so writes can optionally join a transaction.
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (ss *SQLiteStore) putPullRequest(execer sqlExecer, pr *pr_gh.PullRequest) error {
+	data, err := json.Marshal(pr)
+	if err != nil {
+		fmt.Println("Failed to marshal PullRequest:", err)
+		return err
+	}
+
+	_, err = execer.Exec(
+		ss.sql(`INSERT OR REPLACE INTO {pull_requests} (pr_uid, state, data) VALUES (?, ?, ?)`),
+		pr.PK, pr.State, string(data),
+	)
+	if err != nil {
+		fmt.Println("Failed to insert PullRequest:", err)
+		return err
+	}
+
+	return nil
+}
+
+// The pr_uid isn't part of the JSON representation of a PullRequest, so it's
+// restored from its own column.
+func unmarshalSQLitePullRequest(pr_uid string, data string) (*pr_gh.PullRequest, error) {
+	var pr pr_gh.PullRequest
+	if err := json.Unmarshal([]byte(data), &pr); err != nil {
+		fmt.Println("Failed to convert PullRequest data to object:", err)
+		return nil, err
+	}
+	pr.PK = pr_uid
+	return &pr, nil
+}
@@ -0,0 +1,299 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+const (
+	BackendDynamoDB string = "dynamodb"
+	BackendSQLite   string = "sqlite"
+	BackendMemory   string = "memory"
+)
+
+// Layout used for history event sort keys. Unlike time.RFC3339Nano it never trims
+// trailing zeros, so keys sort lexicographically in chronological order.
+const historyTimeFormat string = "2006-01-02T15:04:05.000000000Z07:00"
+
+var (
+	UnknownBackendError     error = errors.New("Unknown database backend.")
+	UnknownProfileError     error = errors.New("Unknown database profile.")
+	UnknownCredentialsError error = errors.New("Unknown AWS credentials source.")
+	UnprocessedItemsError   error = errors.New("Unprocessed items remain after retries.")
+	VersionConflictError    error = errors.New("Item was modified by another writer.")
+	LeaseHeldError          error = errors.New("Lease is held by another owner.")
+)
+
+// BatchWriteError is returned when some items in a batch write could not be written.
+// Items which aren't listed were written successfully.
+type BatchWriteError struct {
+	PKs []string
+}
+
+func (e *BatchWriteError) Error() string {
+	return fmt.Sprintf("Failed to write %d item(s).", len(e.PKs))
+}
+
+// Store is implemented by each database backend that pull request state can be kept in.
+type Store interface {
+	// Create any tables and indexes which don't exist yet, waiting until they're usable.
+	Provision() error
+
+	// Get a value from the key/value metadata table, returning ItemNotFoundError if
+	// it doesn't exist.
+	GetMeta(key string) (string, error)
+
+	// Create or replace a value in the key/value metadata table.
+	PutMeta(key string, value string) error
+
+	// Get a single pull request, returning ItemNotFoundError if it doesn't exist.
+	GetPullRequest(pr_uid string) (*pr_gh.PullRequest, error)
+
+	// Create or replace a single pull request.
+	PutPullRequest(pr *pr_gh.PullRequest) error
+
+	// Create or replace a single pull request, only if the stored version is equal to
+	// expectedVersion. A pull request which doesn't exist yet, or was written before
+	// versioning, has version 0. Returns VersionConflictError if the condition fails.
+	// The caller is responsible for setting pr.Version to the new version.
+	// Any outbox messages are written in the same transaction, so they're queued if
+	// and only if the pull request is written.
+	PutPullRequestIfVersion(pr *pr_gh.PullRequest, expectedVersion int, outbox ...*OutboxMessage) error
+
+	// Get multiple pull requests at once, keyed by pr_uid. Missing items are omitted.
+	BatchGetPullRequests(pr_uids []string) (map[string]*pr_gh.PullRequest, error)
+
+	// Get every pull request which is currently open.
+	QueryOpenPullRequests() ([]*pr_gh.PullRequest, error)
+
+	// Get every pull request, regardless of state.
+	ScanPullRequests() ([]*pr_gh.PullRequest, error)
+
+	// Whether any pull request whose pr_uid starts with prefix is stored, without
+	// reading every pull request. An empty prefix matches any pull request.
+	HasPullRequests(prefix string) (bool, error)
+
+	// Delete a single pull request. Deleting one which doesn't exist isn't an error.
+	DeletePullRequest(pr_uid string) error
+
+	// Append an event to the history of a pull request.
+	PutHistoryEvent(event *HistoryEvent) error
+
+	// Get the history of a pull request, oldest event first.
+	GetHistory(pr_uid string) ([]*HistoryEvent, error)
+
+	// Delete the entire history of a pull request.
+	DeleteHistory(pr_uid string) error
+
+	// Get the pr_uid of every pull request which has history, including any whose
+	// record no longer exists.
+	ListHistoryPKs() ([]string, error)
+
+	// Create or replace a reference to a Slack message.
+	PutMessageRef(ref *MessageRef) error
+
+	// Get a reference to a Slack message, returning ItemNotFoundError if it doesn't exist.
+	GetMessageRef(key string) (*MessageRef, error)
+
+	// Get every reference to a Slack message.
+	ListMessageRefs() ([]*MessageRef, error)
+
+	// Create or replace a message in the notification outbox.
+	PutOutboxMessage(msg *OutboxMessage) error
+
+	// Get every message in the notification outbox with the given status, oldest first.
+	ListOutboxMessages(status string) ([]*OutboxMessage, error)
+
+	// Get the messages in the notification outbox which were sent since the given
+	// time, oldest first, without reading every sent message.
+	ListSentOutboxMessages(since time.Time) ([]*OutboxMessage, error)
+
+	// Delete a message from the notification outbox. Deleting one which doesn't exist
+	// isn't an error.
+	DeleteOutboxMessage(id string) error
+
+	// Acquire or renew a lease for owner, which succeeds if the lease is free, expired,
+	// or already held by owner. Returns LeaseHeldError if another owner holds it.
+	AcquireLease(name string, owner string, ttl time.Duration) (*Lease, error)
+
+	// Give up a lease, if it's held by owner.
+	ReleaseLease(name string, owner string) error
+}
+
+// MessageRef records a Slack message which was posted about a pull request, so it
+// can be found again later.
+type MessageRef struct {
+	Key       string    `json:"message_key" dynamodbav:"message_key"`
+	PK        string    `json:"pr_uid" dynamodbav:"pr_uid"`
+	Event     string    `json:"event" dynamodbav:"event"`
+	Channel   string    `json:"channel" dynamodbav:"channel"`
+	Timestamp string    `json:"ts" dynamodbav:"ts"` // Slack's ID of the message
+	Posted    time.Time `json:"posted" dynamodbav:"posted"`
+
+	// Idempotency key of the outbox message which was delivered as this message.
+	IdempotencyKey string `json:"idempotency_key,omitempty" dynamodbav:"idempotency_key,omitempty"`
+}
+
+// Create a reference to a Slack message about a pull request. Each pull request has
+// at most one message per event and channel.
+func NewMessageRef(pr_uid string, event string, channel string, ts string, posted time.Time) *MessageRef {
+	return &MessageRef{
+		Key:       messageRefKey(pr_uid, event, channel),
+		PK:        pr_uid,
+		Event:     event,
+		Channel:   channel,
+		Timestamp: ts,
+		Posted:    posted.UTC(),
+	}
+}
+
+// Statuses of a message in the notification outbox.
+const (
+	OutboxPending string = "pending"
+	OutboxSent    string = "sent"
+	OutboxDead    string = "dead"
+)
+
+// OutboxMessage is a notification about a pull request which is waiting to be
+// delivered to Slack. It's only marked as sent once Slack has accepted it.
+type OutboxMessage struct {
+	ID          string    `json:"outbox_id" dynamodbav:"outbox_id"`
+	PK          string    `json:"pr_uid" dynamodbav:"pr_uid"`
+	Event       string    `json:"event" dynamodbav:"event"`
+	Channel     string    `json:"channel" dynamodbav:"channel"`                               // channel or user ID
+	Mention     string    `json:"mention,omitempty" dynamodbav:"mention,omitempty"`           // user group ID
+	Users       []string  `json:"users,omitempty" dynamodbav:"users,omitempty"`               // user IDs to mention
+	ThreadTS    string    `json:"thread_ts,omitempty" dynamodbav:"thread_ts,omitempty"`       // message to reply to
+	Broadcast   bool      `json:"broadcast,omitempty" dynamodbav:"broadcast,omitempty"`       // reply is also shown in the channel
+	Rule        string    `json:"rule,omitempty" dynamodbav:"rule,omitempty"`                 // notification rule which queued it
+	Reviewer    string    `json:"reviewer,omitempty" dynamodbav:"reviewer,omitempty"`         // whose review it's about
+	Held        bool      `json:"held,omitempty" dynamodbav:"held,omitempty"`                 // held during quiet hours
+	BatchedWith string    `json:"batched_with,omitempty" dynamodbav:"batched_with,omitempty"` // first message of the batch it was sent in
+	Status      string    `json:"status" dynamodbav:"status"`
+	Attempts    int       `json:"attempts" dynamodbav:"attempts"`
+	NextAttempt time.Time `json:"next_attempt" dynamodbav:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty" dynamodbav:"last_error,omitempty"`
+	Created     time.Time `json:"created" dynamodbav:"created"`
+	Sent        time.Time `json:"sent" dynamodbav:"sent"`
+	ExpiresAt   int64     `json:"expires_at,omitempty" dynamodbav:"expires_at,omitempty"` // unix seconds
+}
+
+// Deterministic key which identifies a single delivery of this message. It's attached
+// to the Slack message, so a message which was posted, but not recorded as sent,
+// can be recognized rather than posted again.
+func (msg *OutboxMessage) IdempotencyKey() string {
+	sum := sha256.Sum256([]byte(msg.ID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Create a pending outbox message for an event in the life of a pull request. The ID
+// is derived from the pull request, the event, the version of the pull request which
+// caused it, and the destination, so queueing the same notification twice results
+// in a single message.
+func NewOutboxMessage(pr_uid string, event string, version int, channel string, now time.Time) *OutboxMessage {
+	now = now.UTC()
+	return &OutboxMessage{
+		ID:          fmt.Sprintf("%s#%s#%d#%s", pr_uid, event, version, channel),
+		PK:          pr_uid,
+		Event:       event,
+		Channel:     channel,
+		Status:      OutboxPending,
+		NextAttempt: now,
+		Created:     now,
+	}
+}
+
+func messageRefKey(pr_uid string, event string, channel string) string {
+	return fmt.Sprintf("%s#%s#%s", pr_uid, event, channel)
+}
+
+// Lease is a named lock which is held by a single owner until it expires.
+type Lease struct {
+	Name      string    `json:"lease_name" dynamodbav:"lease_name"`
+	Owner     string    `json:"owner" dynamodbav:"owner"`
+	Expires   int64     `json:"expires" dynamodbav:"expires"` // unix milliseconds
+	Heartbeat time.Time `json:"heartbeat" dynamodbav:"heartbeat"`
+}
+
+func newLease(name string, owner string, ttl time.Duration) *Lease {
+	now := time.Now()
+	return &Lease{
+		Name:      name,
+		Owner:     owner,
+		Expires:   now.Add(ttl).UnixMilli(),
+		Heartbeat: now.UTC(),
+	}
+}
+
+// HistoryEvent is a single entry in the append-only history of a pull request.
+type HistoryEvent struct {
+	PK        string    `json:"pr_uid" dynamodbav:"pr_uid"`
+	EventID   string    `json:"event_id" dynamodbav:"event_id"`
+	Type      string    `json:"type" dynamodbav:"type"`
+	Timestamp time.Time `json:"timestamp" dynamodbav:"timestamp"`
+	Old       string    `json:"old" dynamodbav:"old"`
+	New       string    `json:"new" dynamodbav:"new"`
+}
+
+// Create a history event, deriving its EventID from the timestamp and type.
+// Writing the same event twice results in a single entry.
+func NewHistoryEvent(pr_uid string, eventType string, old string, new string, ts time.Time) *HistoryEvent {
+	ts = ts.UTC()
+	return &HistoryEvent{
+		PK:        pr_uid,
+		EventID:   fmt.Sprintf("%s#%s", ts.Format(historyTimeFormat), eventType),
+		Type:      eventType,
+		Timestamp: ts,
+		Old:       old,
+		New:       new,
+	}
+}
+
+// Create the Store for the backend and database profile selected in config.
+func NewStore(cfg *utils.Config) (Store, error) {
+	profile, ok := cfg.GetDatabaseProfile()
+	if !ok {
+		return nil, UnknownProfileError
+	}
+
+	var store Store
+	var err error
+	switch cfg.DatabaseBackend {
+	case "", BackendDynamoDB:
+		store, err = NewDynamoStore(cfg, DefaultDynamoTables().withProfile(profile))
+	case BackendSQLite:
+		store, err = NewSQLiteStore(cfg.SQLitePath, DefaultSQLiteTables().withProfile(profile))
+	case BackendMemory:
+		store = NewMemoryStore()
+	default:
+		err = UnknownBackendError
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if profile.KeyPrefix != "" {
+		store = NewPrefixedStore(store, profile.KeyPrefix)
+	}
+	return store, nil
+}
+
+func copyPullRequest(pr *pr_gh.PullRequest) *pr_gh.PullRequest {
+	cp := *pr
+	if pr.Labels != nil {
+		cp.Labels = append([]string{}, pr.Labels...)
+	}
+	if pr.RequestedReviewers != nil {
+		cp.RequestedReviewers = append([]string{}, pr.RequestedReviewers...)
+	}
+	if pr.Reviews != nil {
+		cp.Reviews = append([]pr_gh.Review{}, pr.Reviews...)
+	}
+	return &cp
+}
@@ -0,0 +1,111 @@
+package database_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/ooojustin/pr-puller/pkg/database"
+	"github.com/ooojustin/pr-puller/pkg/database/storetest"
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+func newSQLiteStore(t *testing.T, tables database.Tables) *database.SQLiteStore {
+	path := filepath.Join(t.TempDir(), "pr-slacker.db")
+	store, err := database.NewSQLiteStore(path, tables)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %s", err)
+	}
+	t.Cleanup(func() { store.DB.Close() })
+	return store
+}
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) database.Store {
+		return database.NewMemoryStore()
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) database.Store {
+		return newSQLiteStore(t, database.DefaultSQLiteTables())
+	})
+}
+
+func TestSQLiteStoreCustomTables(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) database.Store {
+		return newSQLiteStore(t, database.Tables{
+			PullRequests: "staging-pull-requests",
+			History:      "staging-history",
+			Leases:       "staging-leases",
+			Meta:         "staging-meta",
+			Messages:     "staging-messages",
+			Outbox:       "staging-outbox",
+		})
+	})
+}
+
+func TestPrefixedStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) database.Store {
+		shared := database.NewMemoryStore()
+
+		// Records of another environment in the same tables must be invisible.
+		other := database.NewPrefixedStore(shared, "production#")
+		for number := 1; number <= 5; number++ {
+			pr := storetest.NewPullRequest(number)
+			pr.Title = "production"
+			if err := other.PutPullRequest(pr); err != nil {
+				t.Fatalf("PutPullRequest: %s", err)
+			}
+		}
+		if err := other.PutMeta("key", "production"); err != nil {
+			t.Fatalf("PutMeta: %s", err)
+		}
+
+		return database.NewPrefixedStore(shared, "staging#")
+	})
+}
+
+// Runs against DynamoDB Local (or any other endpoint) when one is configured,
+// such as PR_SLACKER_TEST_DYNAMODB_ENDPOINT=http://localhost:8000
+func TestDynamoStore(t *testing.T) {
+	endpoint := os.Getenv("PR_SLACKER_TEST_DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("PR_SLACKER_TEST_DYNAMODB_ENDPOINT is not set")
+	}
+
+	cfg := &utils.Config{
+		AwsRegion:          "us-east-1",
+		AwsAccessKeyID:     "local",
+		AwsAccessKeySecret: "local",
+		DynamoDBEndpoint:   endpoint,
+	}
+
+	storetest.Run(t, func(t *testing.T) database.Store {
+		prefix := fmt.Sprintf("test-%d-", time.Now().UnixNano())
+		tables := database.Tables{
+			PullRequests: prefix + "pull-requests",
+			History:      prefix + "pull-request-history",
+			Leases:       prefix + "leases",
+			Meta:         prefix + "meta",
+			Messages:     prefix + "messages",
+			Outbox:       prefix + "outbox",
+		}
+
+		store, err := database.NewDynamoStore(cfg, tables)
+		if err != nil {
+			t.Fatalf("NewDynamoStore: %s", err)
+		}
+
+		t.Cleanup(func() {
+			for _, table := range []string{tables.PullRequests, tables.History, tables.Leases, tables.Meta, tables.Messages, tables.Outbox} {
+				store.DynamoDB.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(table)})
+			}
+		})
+		return store
+	})
+}
@@ -0,0 +1,646 @@
+// Package storetest provides a conformance suite which every database.Store
+// implementation is expected to pass.
+package storetest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/database"
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+)
+
+// Run executes the conformance suite. newStore is called once per test and must
+// return an empty store, which the suite provisions.
+func Run(t *testing.T, newStore func(t *testing.T) database.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store database.Store)
+	}{
+		{"Provision", testProvision},
+		{"Meta", testMeta},
+		{"GetMissing", testGetMissing},
+		{"PutGet", testPutGet},
+		{"PutReplaces", testPutReplaces},
+		{"StoredCopy", testStoredCopy},
+		{"PutIfVersion", testPutIfVersion},
+		{"BatchGet", testBatchGet},
+		{"QueryOpen", testQueryOpen},
+		{"Scan", testScan},
+		{"HasPullRequests", testHasPullRequests},
+		{"Delete", testDelete},
+		{"History", testHistory},
+		{"HistoryIdempotent", testHistoryIdempotent},
+		{"DeleteHistory", testDeleteHistory},
+		{"MessageRefs", testMessageRefs},
+		{"Outbox", testOutbox},
+		{"OutboxTransaction", testOutboxTransaction},
+		{"SentOutbox", testSentOutbox},
+		{"Lease", testLease},
+		{"LeaseExpiry", testLeaseExpiry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newStore(t)
+			if err := store.Provision(); err != nil {
+				t.Fatalf("Provision: %s", err)
+			}
+			tt.fn(t, store)
+		})
+	}
+}
+
+// Create a PullRequest with every field populated.
+func NewPullRequest(number int) *pr_gh.PullRequest {
+	return &pr_gh.PullRequest{
+		PK:             fmt.Sprintf("org#repo#%d", number),
+		ID:             1000 + number,
+		Created:        time.Date(2022, 7, 1, 12, 0, number, 0, time.UTC),
+		Creator:        "octocat",
+		Repository:     "repo",
+		Organization:   "org",
+		Title:          fmt.Sprintf("Pull request %d", number),
+		URL:            fmt.Sprintf("https://github.com/org/repo/pull/%d", number),
+		Labels:         []string{"bug", "enhancement"},
+		Draft:          false,
+		ReviewDecision: "Review required",
+		Number:         number,
+		State:          pr_gh.StateOpen,
+	}
+}
+
+func assertPullRequest(t *testing.T, got *pr_gh.PullRequest, want *pr_gh.PullRequest) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("got nil PullRequest, want %s", want.PK)
+	}
+	if !got.Created.Equal(want.Created) {
+		t.Errorf("Created = %s, want %s", got.Created, want.Created)
+	}
+
+	gotCopy, wantCopy := *got, *want
+	gotCopy.Created, wantCopy.Created = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(gotCopy, wantCopy) {
+		t.Errorf("PullRequest mismatch:\n got: %+v\nwant: %+v", gotCopy, wantCopy)
+	}
+}
+
+func testProvision(t *testing.T, store database.Store) {
+	// Provisioning a store which is already provisioned does nothing.
+	if err := store.PutPullRequest(NewPullRequest(1)); err != nil {
+		t.Fatalf("PutPullRequest: %s", err)
+	}
+	if err := store.Provision(); err != nil {
+		t.Fatalf("Provision: %s", err)
+	}
+	if _, err := store.GetPullRequest(NewPullRequest(1).PK); err != nil {
+		t.Fatalf("GetPullRequest after Provision: %s", err)
+	}
+}
+
+func testMeta(t *testing.T, store database.Store) {
+	if _, err := store.GetMeta("missing"); err != database.ItemNotFoundError {
+		t.Fatalf("GetMeta error = %v, want ItemNotFoundError", err)
+	}
+
+	for _, value := range []string{"1", "2"} {
+		if err := store.PutMeta("key", value); err != nil {
+			t.Fatalf("PutMeta: %s", err)
+		}
+		got, err := store.GetMeta("key")
+		if err != nil {
+			t.Fatalf("GetMeta: %s", err)
+		}
+		if got != value {
+			t.Fatalf("GetMeta = %q, want %q", got, value)
+		}
+	}
+}
+
+func testGetMissing(t *testing.T, store database.Store) {
+	_, err := store.GetPullRequest("org#repo#404")
+	if err != database.ItemNotFoundError {
+		t.Fatalf("GetPullRequest error = %v, want ItemNotFoundError", err)
+	}
+}
+
+func testPutGet(t *testing.T, store database.Store) {
+	pr := NewPullRequest(1)
+	if err := store.PutPullRequest(pr); err != nil {
+		t.Fatalf("PutPullRequest: %s", err)
+	}
+
+	got, err := store.GetPullRequest(pr.PK)
+	if err != nil {
+		t.Fatalf("GetPullRequest: %s", err)
+	}
+	assertPullRequest(t, got, pr)
+}
+
+func testPutReplaces(t *testing.T, store database.Store) {
+	pr := NewPullRequest(1)
+	if err := store.PutPullRequest(pr); err != nil {
+		t.Fatalf("PutPullRequest: %s", err)
+	}
+
+	updated := NewPullRequest(1)
+	updated.Title = "Renamed"
+	updated.ReviewDecision = "Approved"
+	updated.Notified = true
+	if err := store.PutPullRequest(updated); err != nil {
+		t.Fatalf("PutPullRequest: %s", err)
+	}
+
+	got, err := store.GetPullRequest(pr.PK)
+	if err != nil {
+		t.Fatalf("GetPullRequest: %s", err)
+	}
+	assertPullRequest(t, got, updated)
+}
+
+func testStoredCopy(t *testing.T, store database.Store) {
+	pr := NewPullRequest(1)
+	if err := store.PutPullRequest(pr); err != nil {
+		t.Fatalf("PutPullRequest: %s", err)
+	}
+
+	// Changes made after a put, or to a returned value, must not leak into the store.
+	pr.Title = "Changed after put"
+	pr.Labels[0] = "changed"
+	got, err := store.GetPullRequest(pr.PK)
+	if err != nil {
+		t.Fatalf("GetPullRequest: %s", err)
+	}
+	got.Title = "Changed after get"
+
+	again, err := store.GetPullRequest(pr.PK)
+	if err != nil {
+		t.Fatalf("GetPullRequest: %s", err)
+	}
+	assertPullRequest(t, again, NewPullRequest(1))
+}
+
+func testPutIfVersion(t *testing.T, store database.Store) {
+	// Items that don't exist yet have version 0.
+	pr := NewPullRequest(1)
+	pr.Version = 1
+	if err := store.PutPullRequestIfVersion(pr, 1); err != database.VersionConflictError {
+		t.Fatalf("PutPullRequestIfVersion(new, 1) error = %v, want VersionConflictError", err)
+	}
+	if err := store.PutPullRequestIfVersion(pr, 0); err != nil {
+		t.Fatalf("PutPullRequestIfVersion(new, 0): %s", err)
+	}
+
+	// A second writer which read version 0 loses.
+	rival := NewPullRequest(1)
+	rival.Title = "Rival"
+	rival.Version = 1
+	if err := store.PutPullRequestIfVersion(rival, 0); err != database.VersionConflictError {
+		t.Fatalf("PutPullRequestIfVersion(stale) error = %v, want VersionConflictError", err)
+	}
+
+	next := NewPullRequest(1)
+	next.Title = "Next"
+	next.Version = 2
+	if err := store.PutPullRequestIfVersion(next, 1); err != nil {
+		t.Fatalf("PutPullRequestIfVersion(1): %s", err)
+	}
+
+	got, err := store.GetPullRequest(pr.PK)
+	if err != nil {
+		t.Fatalf("GetPullRequest: %s", err)
+	}
+	assertPullRequest(t, got, next)
+
+	// Items written without a version are treated as version 0.
+	legacy := NewPullRequest(2)
+	if err := store.PutPullRequest(legacy); err != nil {
+		t.Fatalf("PutPullRequest: %s", err)
+	}
+	legacy.Version = 1
+	if err := store.PutPullRequestIfVersion(legacy, 0); err != nil {
+		t.Fatalf("PutPullRequestIfVersion(legacy, 0): %s", err)
+	}
+}
+
+func testBatchGet(t *testing.T, store database.Store) {
+	// More than a single DynamoDB BatchGetItem request can hold.
+	var pr_uids []string
+	for number := 1; number <= 120; number++ {
+		pr := NewPullRequest(number)
+		if err := store.PutPullRequest(pr); err != nil {
+			t.Fatalf("PutPullRequest: %s", err)
+		}
+		pr_uids = append(pr_uids, pr.PK)
+	}
+	pr_uids = append(pr_uids, "org#repo#404")
+
+	prs, err := store.BatchGetPullRequests(pr_uids)
+	if err != nil {
+		t.Fatalf("BatchGetPullRequests: %s", err)
+	}
+	if len(prs) != 120 {
+		t.Fatalf("BatchGetPullRequests returned %d items, want 120", len(prs))
+	}
+	for number := 1; number <= 120; number++ {
+		want := NewPullRequest(number)
+		assertPullRequest(t, prs[want.PK], want)
+	}
+
+	prs, err = store.BatchGetPullRequests(nil)
+	if err != nil {
+		t.Fatalf("BatchGetPullRequests(nil): %s", err)
+	}
+	if len(prs) != 0 {
+		t.Fatalf("BatchGetPullRequests(nil) returned %d items, want 0", len(prs))
+	}
+}
+
+func testQueryOpen(t *testing.T, store database.Store) {
+	open := NewPullRequest(1)
+	draft := NewPullRequest(2)
+	draft.Draft = true
+	merged := NewPullRequest(3)
+	merged.State = pr_gh.StateMerged
+	closed := NewPullRequest(4)
+	closed.State = pr_gh.StateClosed
+
+	for _, pr := range []*pr_gh.PullRequest{open, draft, merged, closed} {
+		if err := store.PutPullRequest(pr); err != nil {
+			t.Fatalf("PutPullRequest: %s", err)
+		}
+	}
+
+	prs, err := store.QueryOpenPullRequests()
+	if err != nil {
+		t.Fatalf("QueryOpenPullRequests: %s", err)
+	}
+
+	got := make(map[string]bool)
+	for _, pr := range prs {
+		got[pr.PK] = true
+	}
+	want := map[string]bool{open.PK: true, draft.PK: true}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("QueryOpenPullRequests = %v, want %v", got, want)
+	}
+}
+
+func testScan(t *testing.T, store database.Store) {
+	open := NewPullRequest(1)
+	merged := NewPullRequest(2)
+	merged.State = pr_gh.StateMerged
+	for _, pr := range []*pr_gh.PullRequest{open, merged} {
+		if err := store.PutPullRequest(pr); err != nil {
+			t.Fatalf("PutPullRequest: %s", err)
+		}
+	}
+
+	prs, err := store.ScanPullRequests()
+	if err != nil {
+		t.Fatalf("ScanPullRequests: %s", err)
+	}
+	if len(prs) != 2 {
+		t.Fatalf("ScanPullRequests returned %d items, want 2", len(prs))
+	}
+}
+
+func testHasPullRequests(t *testing.T, store database.Store) {
+	if found, err := store.HasPullRequests(""); err != nil || found {
+		t.Fatalf("HasPullRequests(\"\") on an empty store = %t, %v, want false", found, err)
+	}
+
+	pr := NewPullRequest(1)
+	if err := store.PutPullRequest(pr); err != nil {
+		t.Fatalf("PutPullRequest: %s", err)
+	}
+	if found, err := store.HasPullRequests(""); err != nil || !found {
+		t.Fatalf("HasPullRequests(\"\") = %t, %v, want true", found, err)
+	}
+	if found, err := store.HasPullRequests(pr.PK[:len(pr.PK)-1]); err != nil || !found {
+		t.Fatalf("HasPullRequests(%q) = %t, %v, want true", pr.PK[:len(pr.PK)-1], found, err)
+	}
+	if found, err := store.HasPullRequests("zzz"); err != nil || found {
+		t.Fatalf("HasPullRequests(zzz) = %t, %v, want false", found, err)
+	}
+}
+
+func testDelete(t *testing.T, store database.Store) {
+	pr := NewPullRequest(1)
+	pr.ExpiresAt = time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC).Unix()
+	if err := store.PutPullRequest(pr); err != nil {
+		t.Fatalf("PutPullRequest: %s", err)
+	}
+
+	got, err := store.GetPullRequest(pr.PK)
+	if err != nil {
+		t.Fatalf("GetPullRequest: %s", err)
+	}
+	assertPullRequest(t, got, pr)
+
+	if err := store.DeletePullRequest(pr.PK); err != nil {
+		t.Fatalf("DeletePullRequest: %s", err)
+	}
+	if _, err := store.GetPullRequest(pr.PK); err != database.ItemNotFoundError {
+		t.Fatalf("GetPullRequest after delete error = %v, want ItemNotFoundError", err)
+	}
+
+	// Deleting something which doesn't exist is fine.
+	if err := store.DeletePullRequest(pr.PK); err != nil {
+		t.Fatalf("DeletePullRequest(missing): %s", err)
+	}
+}
+
+func testHistory(t *testing.T, store database.Store) {
+	pr_uid := NewPullRequest(1).PK
+	base := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	// Written out of order, and with a timestamp that RFC3339Nano would shorten.
+	events := []*database.HistoryEvent{
+		database.NewHistoryEvent(pr_uid, "approved", "Review required", "Approved", base.Add(2*time.Hour)),
+		database.NewHistoryEvent(pr_uid, "opened", "", "open", base),
+		database.NewHistoryEvent(pr_uid, "ready_for_review", "true", "false", base.Add(time.Hour+500*time.Millisecond)),
+		database.NewHistoryEvent("org#repo#2", "opened", "", "open", base),
+	}
+	for _, event := range events {
+		if err := store.PutHistoryEvent(event); err != nil {
+			t.Fatalf("PutHistoryEvent: %s", err)
+		}
+	}
+
+	history, err := store.GetHistory(pr_uid)
+	if err != nil {
+		t.Fatalf("GetHistory: %s", err)
+	}
+
+	var types []string
+	for _, event := range history {
+		types = append(types, event.Type)
+	}
+	want := []string{"opened", "ready_for_review", "approved"}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("GetHistory types = %v, want %v", types, want)
+	}
+
+	got := history[1]
+	if got.PK != pr_uid || got.Old != "true" || got.New != "false" {
+		t.Errorf("GetHistory event = %+v", got)
+	}
+	if !got.Timestamp.Equal(base.Add(time.Hour + 500*time.Millisecond)) {
+		t.Errorf("Timestamp = %s", got.Timestamp)
+	}
+
+	empty, err := store.GetHistory("org#repo#404")
+	if err != nil {
+		t.Fatalf("GetHistory: %s", err)
+	}
+	if len(empty) != 0 {
+		t.Fatalf("GetHistory for unknown PR returned %d events, want 0", len(empty))
+	}
+}
+
+func testHistoryIdempotent(t *testing.T, store database.Store) {
+	ts := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		event := database.NewHistoryEvent("org#repo#1", "opened", "", "open", ts)
+		if err := store.PutHistoryEvent(event); err != nil {
+			t.Fatalf("PutHistoryEvent: %s", err)
+		}
+	}
+
+	history, err := store.GetHistory("org#repo#1")
+	if err != nil {
+		t.Fatalf("GetHistory: %s", err)
+	}
+	if len(history) != 1 {
+		t.Fatalf("GetHistory returned %d events, want 1", len(history))
+	}
+}
+
+func testMessageRefs(t *testing.T, store database.Store) {
+	posted := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	ref := database.NewMessageRef("org#repo#1", "notified", "C123", "1656676800.000100", posted)
+	if _, err := store.GetMessageRef(ref.Key); err != database.ItemNotFoundError {
+		t.Fatalf("GetMessageRef error = %v, want ItemNotFoundError", err)
+	}
+
+	if err := store.PutMessageRef(ref); err != nil {
+		t.Fatalf("PutMessageRef: %s", err)
+	}
+	other := database.NewMessageRef("org#repo#1", "notified", "C456", "1656676800.000200", posted)
+	if err := store.PutMessageRef(other); err != nil {
+		t.Fatalf("PutMessageRef: %s", err)
+	}
+
+	got, err := store.GetMessageRef(ref.Key)
+	if err != nil {
+		t.Fatalf("GetMessageRef: %s", err)
+	}
+	if got.PK != ref.PK || got.Channel != ref.Channel || got.Timestamp != ref.Timestamp || !got.Posted.Equal(posted) {
+		t.Fatalf("GetMessageRef = %+v, want %+v", got, ref)
+	}
+
+	refs, err := store.ListMessageRefs()
+	if err != nil {
+		t.Fatalf("ListMessageRefs: %s", err)
+	}
+	if len(refs) != 2 || refs[0].Key != ref.Key || refs[1].Key != other.Key {
+		t.Fatalf("ListMessageRefs = %+v", refs)
+	}
+}
+
+func testOutbox(t *testing.T, store database.Store) {
+	created := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	older := database.NewOutboxMessage("org#repo#2", "notified", 1, "C123", created)
+	newer := database.NewOutboxMessage("org#repo#1", "notified", 1, "C123", created.Add(time.Minute))
+	for _, msg := range []*database.OutboxMessage{newer, older} {
+		if err := store.PutOutboxMessage(msg); err != nil {
+			t.Fatalf("PutOutboxMessage: %s", err)
+		}
+	}
+
+	pending, err := store.ListOutboxMessages(database.OutboxPending)
+	if err != nil {
+		t.Fatalf("ListOutboxMessages: %s", err)
+	}
+	if len(pending) != 2 || pending[0].ID != older.ID || pending[1].ID != newer.ID {
+		t.Fatalf("ListOutboxMessages(pending) = %+v, want oldest first", pending)
+	}
+
+	newer.Status = database.OutboxDead
+	newer.Attempts = 3
+	newer.LastError = "channel_not_found"
+	if err := store.PutOutboxMessage(newer); err != nil {
+		t.Fatalf("PutOutboxMessage: %s", err)
+	}
+
+	dead, err := store.ListOutboxMessages(database.OutboxDead)
+	if err != nil {
+		t.Fatalf("ListOutboxMessages: %s", err)
+	}
+	if len(dead) != 1 || dead[0].ID != newer.ID || dead[0].Attempts != 3 || dead[0].LastError != newer.LastError {
+		t.Fatalf("ListOutboxMessages(dead) = %+v", dead)
+	}
+	if pending, _ = store.ListOutboxMessages(database.OutboxPending); len(pending) != 1 {
+		t.Fatalf("ListOutboxMessages(pending) returned %d messages after update, want 1", len(pending))
+	}
+}
+
+func testSentOutbox(t *testing.T, store database.Store) {
+	sent := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	var msgs []*database.OutboxMessage
+	for idx, age := range []time.Duration{2 * time.Hour, 30 * time.Minute, 0} {
+		msg := database.NewOutboxMessage(fmt.Sprintf("org#repo#%d", idx+1), "notified", 1, "C123", sent.Add(-3*time.Hour))
+		msg.Status = database.OutboxSent
+		msg.Sent = sent.Add(-age)
+		msgs = append(msgs, msg)
+	}
+	// Sent just after the start of the range, whose RFC 3339 form sorts before it.
+	edge := database.NewOutboxMessage("org#repo#5", "notified", 1, "C123", sent.Add(-3*time.Hour))
+	edge.Status = database.OutboxSent
+	edge.Sent = sent.Add(-time.Hour + 500*time.Millisecond)
+	pending := database.NewOutboxMessage("org#repo#4", "notified", 1, "C123", sent)
+	for _, msg := range append(msgs, edge, pending) {
+		if err := store.PutOutboxMessage(msg); err != nil {
+			t.Fatalf("PutOutboxMessage: %s", err)
+		}
+	}
+
+	recent, err := store.ListSentOutboxMessages(sent.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("ListSentOutboxMessages: %s", err)
+	}
+	if len(recent) != 3 || recent[0].ID != msgs[1].ID || recent[1].ID != msgs[2].ID || recent[2].ID != edge.ID {
+		t.Fatalf("ListSentOutboxMessages(1h ago) = %+v, want the last 3 sent", recent)
+	}
+
+	if err := store.DeleteOutboxMessage(msgs[2].ID); err != nil {
+		t.Fatalf("DeleteOutboxMessage: %s", err)
+	}
+	if err := store.DeleteOutboxMessage("missing"); err != nil {
+		t.Fatalf("DeleteOutboxMessage(missing): %s", err)
+	}
+	if recent, _ = store.ListSentOutboxMessages(sent.Add(-time.Hour)); len(recent) != 2 {
+		t.Fatalf("ListSentOutboxMessages returned %d messages after delete, want 2", len(recent))
+	}
+}
+
+func testOutboxTransaction(t *testing.T, store database.Store) {
+	pr := NewPullRequest(1)
+	pr.Version = 1
+	queued := database.NewOutboxMessage(pr.PK, "notified", 1, "C123", time.Now())
+	if err := store.PutPullRequestIfVersion(pr, 0, queued); err != nil {
+		t.Fatalf("PutPullRequestIfVersion(0, outbox): %s", err)
+	}
+
+	// A write which loses the race must not queue its messages.
+	lost := database.NewOutboxMessage(pr.PK, "notified", 2, "C123", time.Now())
+	if err := store.PutPullRequestIfVersion(pr, 0, lost); err != database.VersionConflictError {
+		t.Fatalf("PutPullRequestIfVersion(stale, outbox) error = %v, want VersionConflictError", err)
+	}
+
+	pending, err := store.ListOutboxMessages(database.OutboxPending)
+	if err != nil {
+		t.Fatalf("ListOutboxMessages: %s", err)
+	}
+	if len(pending) != 1 || pending[0].ID != queued.ID {
+		t.Fatalf("ListOutboxMessages = %+v, want only the committed message", pending)
+	}
+}
+
+func testLease(t *testing.T, store database.Store) {
+	lease, err := store.AcquireLease("leader", "a", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease(a): %s", err)
+	}
+	if lease.Owner != "a" || lease.Expires <= time.Now().UnixMilli() {
+		t.Fatalf("AcquireLease(a) = %+v", lease)
+	}
+
+	if _, err := store.AcquireLease("leader", "b", time.Minute); err != database.LeaseHeldError {
+		t.Fatalf("AcquireLease(b) error = %v, want LeaseHeldError", err)
+	}
+
+	// Renewing a held lease, and holding unrelated leases, both succeed.
+	if _, err := store.AcquireLease("leader", "a", time.Minute); err != nil {
+		t.Fatalf("AcquireLease(a) renewal: %s", err)
+	}
+	if _, err := store.AcquireLease("other", "b", time.Minute); err != nil {
+		t.Fatalf("AcquireLease(other, b): %s", err)
+	}
+
+	// Releasing someone else's lease does nothing.
+	if err := store.ReleaseLease("leader", "b"); err != nil {
+		t.Fatalf("ReleaseLease(b): %s", err)
+	}
+	if _, err := store.AcquireLease("leader", "b", time.Minute); err != database.LeaseHeldError {
+		t.Fatalf("AcquireLease(b) after foreign release error = %v, want LeaseHeldError", err)
+	}
+
+	if err := store.ReleaseLease("leader", "a"); err != nil {
+		t.Fatalf("ReleaseLease(a): %s", err)
+	}
+	if _, err := store.AcquireLease("leader", "b", time.Minute); err != nil {
+		t.Fatalf("AcquireLease(b) after release: %s", err)
+	}
+}
+
+func testLeaseExpiry(t *testing.T, store database.Store) {
+	if _, err := store.AcquireLease("leader", "a", 50*time.Millisecond); err != nil {
+		t.Fatalf("AcquireLease(a): %s", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	lease, err := store.AcquireLease("leader", "b", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease(b) after expiry: %s", err)
+	}
+	if lease.Owner != "b" {
+		t.Fatalf("lease owner = %s, want b", lease.Owner)
+	}
+}
+
+func testDeleteHistory(t *testing.T, store database.Store) {
+	ts := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	for _, pr_uid := range []string{"org#repo#2", "org#repo#1"} {
+		// More events than a single DynamoDB BatchWriteItem request can hold.
+		for i := 0; i < 30; i++ {
+			event := database.NewHistoryEvent(pr_uid, "opened", "", "open", ts.Add(time.Duration(i)*time.Second))
+			if err := store.PutHistoryEvent(event); err != nil {
+				t.Fatalf("PutHistoryEvent: %s", err)
+			}
+		}
+	}
+
+	pr_uids, err := store.ListHistoryPKs()
+	if err != nil {
+		t.Fatalf("ListHistoryPKs: %s", err)
+	}
+	if !reflect.DeepEqual(pr_uids, []string{"org#repo#1", "org#repo#2"}) {
+		t.Fatalf("ListHistoryPKs = %v", pr_uids)
+	}
+
+	if err := store.DeleteHistory("org#repo#1"); err != nil {
+		t.Fatalf("DeleteHistory: %s", err)
+	}
+
+	history, err := store.GetHistory("org#repo#1")
+	if err != nil {
+		t.Fatalf("GetHistory: %s", err)
+	}
+	if len(history) != 0 {
+		t.Fatalf("GetHistory after delete returned %d events, want 0", len(history))
+	}
+
+	pr_uids, err = store.ListHistoryPKs()
+	if err != nil {
+		t.Fatalf("ListHistoryPKs: %s", err)
+	}
+	if !reflect.DeepEqual(pr_uids, []string{"org#repo#2"}) {
+		t.Fatalf("ListHistoryPKs after delete = %v", pr_uids)
+	}
+}
@@ -0,0 +1,34 @@
+package database
+
+import (
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+// Tables holds the name of each table used by a store.
+type Tables struct {
+	PullRequests string
+	History      string
+	Leases       string
+	Meta         string
+	Messages     string
+	Outbox       string
+}
+
+// Apply the table names from a database profile to a backend's default names.
+func (defaults Tables) withProfile(profile utils.DatabaseProfile) Tables {
+	name := func(configured string, fallback string) string {
+		if configured != "" {
+			return configured
+		}
+		return profile.TablePrefix + fallback
+	}
+
+	return Tables{
+		PullRequests: name(profile.PullRequestsTable, defaults.PullRequests),
+		History:      name(profile.HistoryTable, defaults.History),
+		Leases:       name(profile.LeasesTable, defaults.Leases),
+		Meta:         name(profile.MetaTable, defaults.Meta),
+		Messages:     name(profile.MessagesTable, defaults.Messages),
+		Outbox:       name(profile.OutboxTable, defaults.Outbox),
+	}
+}
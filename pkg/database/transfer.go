@@ -0,0 +1,219 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+)
+
+// Types of records in an export.
+const (
+	RecordPullRequest string = "pull_request"
+	RecordHistory     string = "history"
+	RecordMessage     string = "slack_message"
+)
+
+// Policies for importing a record which already exists.
+const (
+	ConflictSkip       string = "skip"
+	ConflictOverwrite  string = "overwrite"
+	ConflictNewestWins string = "newest-wins"
+)
+
+// Longest line accepted when importing.
+const maxImportLineSize int = 1024 * 1024
+
+var (
+	UnknownConflictPolicyError error = errors.New("Unknown conflict policy.")
+)
+
+// ExportRecord is a single line of an export. Pull requests don't include their
+// pr_uid when encoded as JSON, so it's stored alongside the record.
+type ExportRecord struct {
+	Type   string          `json:"type"`
+	PK     string          `json:"pr_uid,omitempty"`
+	Record json.RawMessage `json:"record"`
+}
+
+// TransferStats counts the records of each type which were exported or imported.
+// Skipped counts records which weren't imported because of the conflict policy.
+type TransferStats struct {
+	PullRequests int
+	History      int
+	Messages     int
+	Skipped      int
+}
+
+// Write every pull request, history event and Slack message reference to w as
+// JSON Lines, one record per line.
+func (db *Database) Export(w io.Writer) (TransferStats, error) {
+	var stats TransferStats
+	encoder := json.NewEncoder(w)
+	write := func(recordType string, pk string, record interface{}) error {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return encoder.Encode(&ExportRecord{Type: recordType, PK: pk, Record: data})
+	}
+
+	prs, err := db.Store.ScanPullRequests()
+	if err != nil {
+		return stats, err
+	}
+	sort.Slice(prs, func(i, j int) bool {
+		return prs[i].PK < prs[j].PK
+	})
+	for _, pr := range prs {
+		if err := write(RecordPullRequest, pr.PK, pr); err != nil {
+			return stats, err
+		}
+		stats.PullRequests++
+	}
+
+	pr_uids, err := db.Store.ListHistoryPKs()
+	if err != nil {
+		return stats, err
+	}
+	for _, pr_uid := range pr_uids {
+		events, err := db.Store.GetHistory(pr_uid)
+		if err != nil {
+			return stats, err
+		}
+		for _, event := range events {
+			if err := write(RecordHistory, "", event); err != nil {
+				return stats, err
+			}
+			stats.History++
+		}
+	}
+
+	refs, err := db.Store.ListMessageRefs()
+	if err != nil {
+		return stats, err
+	}
+	for _, ref := range refs {
+		if err := write(RecordMessage, "", ref); err != nil {
+			return stats, err
+		}
+		stats.Messages++
+	}
+
+	return stats, nil
+}
+
+// Read records written by Export from r, and store them. Importing the same records
+// more than once has the same result as importing them once. The conflict policy
+// decides what happens to records which already exist:
+//   - skip keeps the existing record.
+//   - overwrite replaces it with the imported record.
+//   - newest-wins keeps whichever was updated (or posted) most recently.
+//
+// History events are identified by their time and type, so they never conflict.
+func (db *Database) Import(r io.Reader, policy string) (TransferStats, error) {
+	var stats TransferStats
+	switch policy {
+	case ConflictSkip, ConflictOverwrite, ConflictNewestWins:
+	default:
+		return stats, UnknownConflictPolicyError
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineSize)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record ExportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return stats, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if err := db.importRecord(&record, policy, &stats); err != nil {
+			return stats, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+
+	return stats, scanner.Err()
+}
+
+func (db *Database) importRecord(record *ExportRecord, policy string, stats *TransferStats) error {
+	switch record.Type {
+	case RecordPullRequest:
+		var pr pr_gh.PullRequest
+		if err := json.Unmarshal(record.Record, &pr); err != nil {
+			return err
+		}
+		pr.PK = record.PK
+
+		existing, err := db.Store.GetPullRequest(pr.PK)
+		if err != nil && err != ItemNotFoundError {
+			return err
+		}
+		if existing != nil && !replaceOnConflict(policy, existing.UpdatedAt, pr.UpdatedAt) {
+			stats.Skipped++
+			return nil
+		}
+
+		if err := db.Store.PutPullRequest(&pr); err != nil {
+			return err
+		}
+		stats.PullRequests++
+
+	case RecordHistory:
+		var event HistoryEvent
+		if err := json.Unmarshal(record.Record, &event); err != nil {
+			return err
+		}
+
+		if err := db.Store.PutHistoryEvent(&event); err != nil {
+			return err
+		}
+		stats.History++
+
+	case RecordMessage:
+		var ref MessageRef
+		if err := json.Unmarshal(record.Record, &ref); err != nil {
+			return err
+		}
+
+		existing, err := db.Store.GetMessageRef(ref.Key)
+		if err != nil && err != ItemNotFoundError {
+			return err
+		}
+		if existing != nil && !replaceOnConflict(policy, existing.Posted, ref.Posted) {
+			stats.Skipped++
+			return nil
+		}
+
+		if err := db.Store.PutMessageRef(&ref); err != nil {
+			return err
+		}
+		stats.Messages++
+
+	default:
+		return fmt.Errorf("unknown record type %q", record.Type)
+	}
+
+	return nil
+}
+
+// Whether an existing record should be replaced by an imported one.
+func replaceOnConflict(policy string, existing time.Time, imported time.Time) bool {
+	switch policy {
+	case ConflictOverwrite:
+		return true
+	case ConflictNewestWins:
+		return imported.After(existing)
+	default:
+		return false
+	}
+}
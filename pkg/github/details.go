@@ -0,0 +1,166 @@
+package github
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var (
+	NotPullRequestPageError error = errors.New("Page isn't the conversation page of a pull request.")
+)
+
+// Commit links in the timeline of a pull request, which end with the commit's SHA.
+var commitLinkPattern = regexp.MustCompile(`/pull/\d+/commits/([0-9a-f]{40})$`)
+
+// Load the details of pull requests which aren't shown in search results, from each
+// one's conversation page: base branch, head commit, size, CI status, requested
+// reviewers and reviews. Pull requests whose page fails to load, or isn't the page of a
+// pull request (ex: rate limited, or redirected to log in), keep their details unknown,
+// so the stored ones are kept.
+func (ghc *GithubClient) LoadPullRequestDetails(prs []*PullRequest, now time.Time) {
+	for _, pr := range prs {
+		resp, err := ghc.client.Get(pr.URL)
+		if err != nil {
+			fmt.Printf("Failed to load details of %s: %s\n", pr.PK, err)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			fmt.Printf("Failed to load details of %s: %s\n", pr.PK, resp.Status)
+			continue
+		}
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			fmt.Printf("Failed to parse details of %s: %s\n", pr.PK, err)
+			continue
+		}
+
+		if !ParsePullRequestPage(doc, pr) {
+			fmt.Printf("Failed to parse details of %s: %s\n", pr.PK, NotPullRequestPageError)
+			continue
+		}
+		pr.DetailsLoaded = now.UTC()
+
+		if pr.ReviewDecision == ReviewApproved && len(pr.Reviews) == 0 {
+			// Either the approval is hidden in a collapsed part of the timeline, or the
+			// page has changed and reviews can't be found anymore.
+			fmt.Printf("Found no reviews on the page of approved pull request %s.\n", pr.PK)
+		}
+	}
+}
+
+// Parse the details and reviews shown on the conversation page of a pull request into
+// pr. Details which can't be found are left unknown. Returns false, leaving pr as it
+// was, if the page isn't the conversation page of a pull request.
+func ParsePullRequestPage(doc *goquery.Document, pr *PullRequest) bool {
+	if doc.Find(".gh-header-meta").Length() == 0 || doc.Find(".js-timeline-item").Length() == 0 {
+		return false
+	}
+
+	parseDetails(doc, pr)
+	pr.Reviews = parseReviews(doc, pr.URL)
+	return true
+}
+
+func parseDetails(doc *goquery.Document, pr *PullRequest) {
+	// Pull requests from forks show their base as "owner:branch".
+	if base := strings.TrimSpace(doc.Find(".base-ref").First().Text()); base != "" {
+		pr.BaseBranch = base[strings.LastIndex(base, ":")+1:]
+	}
+
+	if sha, ok := doc.Find(`input[name="expected_head_oid"]`).First().Attr("value"); ok && sha != "" {
+		pr.HeadSHA = sha
+	} else {
+		// The timeline is oldest first, so the last commit link is the head.
+		doc.Find(`a[href*="/commits/"]`).Each(func(_ int, link *goquery.Selection) {
+			href, _ := link.Attr("href")
+			if match := commitLinkPattern.FindStringSubmatch(href); match != nil {
+				pr.HeadSHA = match[1]
+			}
+		})
+	}
+
+	diffstat := doc.Find("#diffstat")
+	pr.Additions = parseCount(diffstat.Find(".color-fg-success").First().Text())
+	pr.Deletions = parseCount(diffstat.Find(".color-fg-danger").First().Text())
+	pr.ChangedFiles = parseCount(doc.Find("#files_tab_counter").First().Text())
+
+	pr.CIStatus = parseCIStatus(doc)
+
+	// Without the form, requested reviewers are unknown rather than none.
+	form := doc.Find(".js-issue-sidebar-form[action$=\"/review-requests\"]")
+	if form.Length() == 0 {
+		return
+	}
+	reviewers := []string{}
+	form.Find("p").Each(func(_ int, row *goquery.Selection) {
+		if row.Find(".hx_dot-fill-pending-icon").Length() == 0 {
+			// Reviewers who already reviewed are listed too.
+			return
+		}
+		if reviewer, ok := parseReviewer(row); ok {
+			reviewers = append(reviewers, reviewer)
+		}
+	})
+	pr.RequestedReviewers = reviewers
+}
+
+// Get the Github username of a requested reviewer, or "org/team" if a team was
+// requested.
+func parseReviewer(row *goquery.Selection) (string, bool) {
+	link := row.Find("a.assignee").First()
+	href, ok := link.Attr("href")
+	if !ok {
+		return "", false
+	}
+
+	// Teams link to "/orgs/{org}/teams/{team}".
+	parts := strings.Split(strings.Trim(href, "/"), "/")
+	if len(parts) == 4 && parts[0] == "orgs" && parts[2] == "teams" {
+		return parts[1] + "/" + parts[3], true
+	}
+
+	reviewer := strings.TrimSpace(link.Text())
+	return reviewer, reviewer != ""
+}
+
+// Get the combined status of the checks on the head commit, from the status icon of
+// the last commit in the timeline. Returns "" if no checks ran.
+func parseCIStatus(doc *goquery.Document) string {
+	statuses := doc.Find(".commit-build-statuses")
+	if statuses.Length() == 0 {
+		return ""
+	}
+
+	icon := statuses.Last().Find("summary").First()
+	switch {
+	case icon.HasClass("color-fg-success"):
+		return CISuccess
+	case icon.HasClass("color-fg-danger"):
+		return CIFailure
+	case icon.HasClass("color-fg-attention"):
+		return CIPending
+	}
+	return ""
+}
+
+// Parse a count shown by Github, ignoring signs and separators. (ex: "+1,204" is 1204)
+func parseCount(text string) int {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, text)
+	count, _ := strconv.Atoi(digits)
+	return count
+}
@@ -0,0 +1,110 @@
+package github
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func loadTestPage(t *testing.T, path string) *goquery.Document {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	doc, err := goquery.NewDocumentFromReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestParsePullRequestPage(t *testing.T) {
+	pr := &PullRequest{PK: "org#api#7", URL: "https://github.com/org/api/pull/7", State: StateOpen}
+	ParsePullRequestPage(loadTestPage(t, "testdata/pull_request.html"), pr)
+
+	if pr.BaseBranch != "main" {
+		t.Errorf("BaseBranch = %q, want main", pr.BaseBranch)
+	}
+	if pr.HeadSHA != "2222222222222222222222222222222222222222" {
+		t.Errorf("HeadSHA = %q, want the last commit in the timeline", pr.HeadSHA)
+	}
+	if pr.Additions != 1204 || pr.Deletions != 4 || pr.ChangedFiles != 5 {
+		t.Errorf("size = +%d −%d in %d files, want +1204 −4 in 5 files", pr.Additions, pr.Deletions, pr.ChangedFiles)
+	}
+	if pr.CIStatus != CIFailure {
+		t.Errorf("CIStatus = %q, want the status of the last commit", pr.CIStatus)
+	}
+	if want := []string{"alice", "org/backend"}; !reflect.DeepEqual(pr.RequestedReviewers, want) {
+		t.Errorf("RequestedReviewers = %v, want %v", pr.RequestedReviewers, want)
+	}
+	want := []Review{{Reviewer: "bob", State: ReviewApproved, URL: pr.URL + "#pullrequestreview-11",
+		Submitted: time.Date(2022, 7, 2, 9, 0, 0, 0, time.UTC)}}
+	if !reflect.DeepEqual(pr.Reviews, want) {
+		t.Errorf("Reviews = %+v, want %+v", pr.Reviews, want)
+	}
+}
+
+func TestParsePullRequestPageUnknown(t *testing.T) {
+	pr := &PullRequest{URL: "https://github.com/org/api/pull/7"}
+	if ParsePullRequestPage(loadTestPage(t, "testdata/empty.html"), pr) {
+		t.Error("ParsePullRequestPage(empty page) = true, want false")
+	}
+
+	if _, ok := pr.Size(); ok || pr.BaseBranch != "" || pr.HeadSHA != "" || pr.CIStatus != "" {
+		t.Errorf("details of an empty page = %+v, want them unknown", pr)
+	}
+	if pr.RequestedReviewers != nil || pr.Reviews != nil {
+		t.Errorf("reviewers and reviews of an empty page = %v, %v; want nil", pr.RequestedReviewers, pr.Reviews)
+	}
+}
+
+func TestLoadPullRequestDetails(t *testing.T) {
+	page, err := os.ReadFile("testdata/pull_request.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/org/api/pull/7":
+			w.Write(page)
+		case "/org/api/pull/8":
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		case "/org/api/pull/9":
+			http.Redirect(w, r, "/login", http.StatusFound)
+		default:
+			w.Write([]byte("<html><body><p>Page not found</p></body></html>"))
+		}
+	}))
+	defer server.Close()
+
+	client := server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	ghc := &GithubClient{client: client}
+
+	var prs []*PullRequest
+	for _, number := range []int{7, 8, 9, 10} {
+		prs = append(prs, &PullRequest{PK: fmt.Sprintf("org#api#%d", number), URL: fmt.Sprintf("%s/org/api/pull/%d", server.URL, number)})
+	}
+	now := time.Date(2022, 7, 4, 9, 0, 0, 0, time.UTC)
+	ghc.LoadPullRequestDetails(prs, now)
+
+	if !prs[0].DetailsLoaded.Equal(now) || len(prs[0].Reviews) != 1 {
+		t.Errorf("details of a pull request page = %+v, want them loaded", prs[0])
+	}
+	for _, pr := range prs[1:] {
+		if !pr.DetailsLoaded.IsZero() || pr.RequestedReviewers != nil || pr.Reviews != nil {
+			t.Errorf("details of %s = %+v, want them unknown", pr.PK, pr)
+		}
+	}
+}
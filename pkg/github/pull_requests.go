@@ -2,6 +2,8 @@ package github
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"mime/multipart"
@@ -16,6 +18,35 @@ import (
 	"golang.org/x/net/html"
 )
 
+const (
+	StateOpen   string = "open"
+	StateClosed string = "closed"
+	StateMerged string = "merged"
+)
+
+// Combined status of a pull request's checks.
+const (
+	CIPending string = "pending"
+	CISuccess string = "success"
+	CIFailure string = "failure"
+)
+
+// Review decisions, as displayed by Github.
+const (
+	ReviewRequired         string = "Review required"
+	ReviewApproved         string = "Approved"
+	ReviewChangesRequested string = "Changes requested"
+)
+
+// Review is the latest review which approved or requested changes, by one reviewer.
+// State is ReviewApproved or ReviewChangesRequested.
+type Review struct {
+	Reviewer  string    `json:"reviewer" dynamodbav:"reviewer"`
+	State     string    `json:"state" dynamodbav:"state"`
+	URL       string    `json:"url" dynamodbav:"url"`
+	Submitted time.Time `json:"submitted" dynamodbav:"submitted"`
+}
+
 type PullRequest struct {
 	PK             string    `json:"-" dynamodbav:"pr_uid"`
 	ID             int       `json:"id" dynamodbav:"id"`
@@ -29,7 +60,36 @@ type PullRequest struct {
 	Draft          bool      `json:"draft" dynamodbav:"draft"`
 	ReviewDecision string    `json:"review_decision" dynamodbav:"review_decision"`
 	Number         int       `json:"number" dynamodbav:"number"`
-	Notified       bool      `json:"notified" dynamodbav:"notified"`
+	State          string    `json:"state" dynamodbav:"state"`
+	HeadSHA        string    `json:"head_sha,omitempty" dynamodbav:"head_sha,omitempty"` // empty when it isn't known
+
+	// Details which aren't shown in search results, so they're empty unless they were
+	// loaded from elsewhere.
+	BaseBranch         string    `json:"base_branch,omitempty" dynamodbav:"base_branch,omitempty"`
+	Additions          int       `json:"additions,omitempty" dynamodbav:"additions,omitempty"`
+	Deletions          int       `json:"deletions,omitempty" dynamodbav:"deletions,omitempty"`
+	ChangedFiles       int       `json:"changed_files,omitempty" dynamodbav:"changed_files,omitempty"`
+	CIStatus           string    `json:"ci_status,omitempty" dynamodbav:"ci_status,omitempty"`
+	RequestedReviewers []string  `json:"requested_reviewers,omitempty" dynamodbav:"requested_reviewers,omitempty"`
+	Reviews            []Review  `json:"reviews,omitempty" dynamodbav:"reviews,omitempty"`
+	DetailsLoaded      time.Time `json:"details_loaded" dynamodbav:"details_loaded"` // when the details were last loaded
+
+	// State we keep about the pull request, which isn't scraped.
+	Notified    bool      `json:"notified" dynamodbav:"notified"`
+	ContentHash string    `json:"content_hash" dynamodbav:"content_hash"`
+	Version     int       `json:"version" dynamodbav:"version"`
+	ExpiresAt   int64     `json:"expires_at,omitempty" dynamodbav:"expires_at,omitempty"` // unix seconds
+	UpdatedAt   time.Time `json:"updated_at" dynamodbav:"updated_at"`
+
+	// When the pull request started waiting for review (zero if it isn't waiting), and
+	// how many reminder steps have been taken since.
+	WaitingSince time.Time `json:"waiting_since" dynamodbav:"waiting_since"`
+	ReminderStep int       `json:"reminder_step,omitempty" dynamodbav:"reminder_step,omitempty"`
+
+	// The Slack message which first announced the pull request in a channel, which is
+	// updated in place as it changes.
+	SlackChannel string `json:"slack_channel,omitempty" dynamodbav:"slack_channel,omitempty"`
+	SlackTS      string `json:"slack_ts,omitempty" dynamodbav:"slack_ts,omitempty"`
 }
 
 func (pr PullRequest) ToString() (string, error) {
@@ -40,6 +100,127 @@ func (pr PullRequest) ToString() (string, error) {
 	return string(prBytes), nil
 }
 
+// Generate a hash of the fields scraped from Github. Two scrapes of a pull request
+// which hasn't changed produce the same hash, regardless of stored bookkeeping or when
+// its details were loaded.
+func (pr PullRequest) Hash() string {
+	scraped := pr.Scraped()
+	scraped.DetailsLoaded = time.Time{}
+	prBytes, _ := json.Marshal(scraped)
+	sum := sha256.Sum256(prBytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// Get a copy of the pull request with only the fields which are scraped from Github.
+// Every other field is state we keep about it, such as whether it was notified.
+func (pr PullRequest) Scraped() PullRequest {
+	scraped := pr.SearchResult()
+	scraped.HeadSHA = pr.HeadSHA
+	scraped.BaseBranch = pr.BaseBranch
+	scraped.Additions = pr.Additions
+	scraped.Deletions = pr.Deletions
+	scraped.ChangedFiles = pr.ChangedFiles
+	scraped.CIStatus = pr.CIStatus
+	scraped.RequestedReviewers = pr.RequestedReviewers
+	scraped.Reviews = pr.Reviews
+	scraped.DetailsLoaded = pr.DetailsLoaded
+	return scraped
+}
+
+// Get a copy of the pull request with only the fields which are shown in search
+// results, which are scraped on every refresh. The rest are loaded from the page of
+// the pull request by LoadPullRequestDetails.
+func (pr PullRequest) SearchResult() PullRequest {
+	return PullRequest{
+		PK:             pr.PK,
+		ID:             pr.ID,
+		Created:        pr.Created,
+		Creator:        pr.Creator,
+		Repository:     pr.Repository,
+		Organization:   pr.Organization,
+		Title:          pr.Title,
+		URL:            pr.URL,
+		Labels:         pr.Labels,
+		Draft:          pr.Draft,
+		ReviewDecision: pr.ReviewDecision,
+		Number:         pr.Number,
+		State:          pr.State,
+	}
+}
+
+// Get the latest review a reviewer submitted, if they have.
+func (pr PullRequest) Review(reviewer string) (*Review, bool) {
+	for idx := range pr.Reviews {
+		if strings.EqualFold(pr.Reviews[idx].Reviewer, reviewer) {
+			return &pr.Reviews[idx], true
+		}
+	}
+	return nil, false
+}
+
+// Number of lines added and deleted, and whether it's known.
+func (pr PullRequest) Size() (int, bool) {
+	if pr.Additions == 0 && pr.Deletions == 0 && pr.ChangedFiles == 0 {
+		return 0, false
+	}
+	return pr.Additions + pr.Deletions, true
+}
+
+// Merge a fresh scrape into the stored record of a pull request, returning a new
+// record. Scraped fields are refreshed, and every other field is kept as stored.
+// Some fields can't always be scraped, so they're only refreshed when they're known:
+//   - ID is only shown for open pull requests which aren't drafts.
+//   - ReviewDecision is only loaded for pull requests with an ID, and may be missing
+//     if loading it fails.
+//   - HeadSHA and the other details below it aren't shown in search results, and are
+//     only loaded from the page of the pull request now and then.
+func (pr PullRequest) Merge(scraped *PullRequest) *PullRequest {
+	merged := pr
+	merged.Labels = append([]string(nil), scraped.Labels...)
+	merged.PK = scraped.PK
+	merged.Created = scraped.Created
+	merged.Creator = scraped.Creator
+	merged.Repository = scraped.Repository
+	merged.Organization = scraped.Organization
+	merged.Title = scraped.Title
+	merged.URL = scraped.URL
+	merged.Draft = scraped.Draft
+	merged.Number = scraped.Number
+	merged.State = scraped.State
+
+	if scraped.ID != 0 {
+		merged.ID = scraped.ID
+	}
+	if scraped.ReviewDecision != "" {
+		merged.ReviewDecision = scraped.ReviewDecision
+	}
+	if scraped.HeadSHA != "" {
+		merged.HeadSHA = scraped.HeadSHA
+	}
+	if scraped.BaseBranch != "" {
+		merged.BaseBranch = scraped.BaseBranch
+	}
+	if _, ok := scraped.Size(); ok {
+		merged.Additions = scraped.Additions
+		merged.Deletions = scraped.Deletions
+		merged.ChangedFiles = scraped.ChangedFiles
+	}
+	if scraped.CIStatus != "" {
+		merged.CIStatus = scraped.CIStatus
+	}
+	if scraped.RequestedReviewers != nil {
+		merged.RequestedReviewers = append([]string(nil), scraped.RequestedReviewers...)
+	}
+	if scraped.Reviews != nil {
+		merged.Reviews = append([]Review(nil), scraped.Reviews...)
+	}
+	if !scraped.DetailsLoaded.IsZero() {
+		merged.DetailsLoaded = scraped.DetailsLoaded
+	}
+
+	return &merged
+}
+
 // Generate all pull request objects for a given org.
 func (ghc *GithubClient) GetAllPullRequests(
 	org string,
@@ -86,7 +267,27 @@ func (ghc *GithubClient) GetPullRequests(
 		}
 	}
 
-	// Parse document and extract data from nodes to generate PR objects for this page.
+	ghc.parsePullRequestDocument(doc, prs)
+}
+
+// Generate pull request objects for the most recently closed (or merged) pull requests
+// in a given org, so that open pull requests we're tracking can be seen leaving that state.
+func (ghc *GithubClient) GetClosedPullRequests(
+	page int,
+	org string,
+	prs *[]*PullRequest,
+) {
+	qualifiers := []string{"org:" + org, "is:closed", "sort:updated-desc"}
+	doc, ok := ghc.loadPullRequestSearchDocument(page, qualifiers)
+	if !ok {
+		return
+	}
+
+	ghc.parsePullRequestDocument(doc, prs)
+}
+
+// Parse document and extract data from nodes to generate PR objects for this page.
+func (ghc *GithubClient) parsePullRequestDocument(doc *goquery.Document, prs *[]*PullRequest) {
 	var prsNew []*PullRequest
 	prNodes := getPullRequestNodes(doc)
 	for _, prNode := range prNodes {
@@ -111,6 +312,12 @@ func (ghc *GithubClient) loadPullRequestDocument(page int, org string, open bool
 		items = append(items, "is:open")
 	}
 
+	return ghc.loadPullRequestSearchDocument(page, items)
+}
+
+// Download Github pull requests page HTML for a search query made up of the given
+// qualifiers, and process it as a goquery Document for parsing.
+func (ghc *GithubClient) loadPullRequestSearchDocument(page int, items []string) (*goquery.Document, bool) {
 	encodedItems := []string{}
 	for _, item := range items {
 		encodedItems = append(encodedItems, url.QueryEscape(item))
@@ -147,6 +354,7 @@ func generatePullRequestObject(prNode *html.Node) (*PullRequest, bool) {
 	}
 
 	draft := strings.Contains(lbl, "draft")
+	state := getPullRequestState(lbl)
 	aTagRepo := iconBoxNode.NextSibling.NextSibling.NextSibling.NextSibling.FirstChild.NextSibling
 	repositoryPath := strings.TrimSpace(aTagRepo.FirstChild.Data)
 	repositoryPathSplit := strings.Split(repositoryPath, "/")
@@ -166,8 +374,9 @@ func generatePullRequestObject(prNode *html.Node) (*PullRequest, bool) {
 	pk := fmt.Sprintf("%s#%s#%d", organization, repositoryName, number)
 	url := GITHUB_URL + href
 
+	// Only open, non-draft pull requests have the input containing their ID.
 	var id int
-	if !draft {
+	if !draft && state == StateOpen {
 		idInput := opened.NextSibling.NextSibling.FirstChild.NextSibling.FirstChild.NextSibling
 		if prId, ok := utils.GetAttribute(idInput, "value"); ok {
 			id, _ = strconv.Atoi(prId)
@@ -186,11 +395,25 @@ func generatePullRequestObject(prNode *html.Node) (*PullRequest, bool) {
 		Labels:       labels,
 		Draft:        draft,
 		Number:       number,
+		State:        state,
 	}
 
 	return pr, true
 }
 
+// Determine the state of a pull request from the aria-label of its icon.
+// (ex: "Open pull request", "Open draft pull request", "Merged pull request")
+func getPullRequestState(lbl string) string {
+	switch {
+	case strings.HasPrefix(lbl, "Merged"):
+		return StateMerged
+	case strings.HasPrefix(lbl, "Closed"):
+		return StateClosed
+	default:
+		return StateOpen
+	}
+}
+
 // Get the 'opened by' node, used as a starting point to find the created timestamp
 // of the pull request and the username of the person who created it.
 func getPullRequestOpenedNode(aTagPR *html.Node) *html.Node {
@@ -0,0 +1,94 @@
+package github
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func storedPullRequest() *PullRequest {
+	return &PullRequest{
+		PK:             "org#repo#1",
+		ID:             1001,
+		Created:        time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC),
+		Creator:        "octocat",
+		Repository:     "repo",
+		Organization:   "org",
+		Title:          "Add a feature",
+		URL:            "https://github.com/org/repo/pull/1",
+		Labels:         []string{"bug"},
+		ReviewDecision: ReviewRequired,
+		Number:         1,
+		State:          StateOpen,
+		HeadSHA:        "aaa",
+		Notified:       true,
+		ContentHash:    "hash",
+		Version:        3,
+		ExpiresAt:      1700000000,
+		UpdatedAt:      time.Date(2022, 7, 2, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestMergeRefreshesScrapedFields(t *testing.T) {
+	stored := storedPullRequest()
+	scraped := storedPullRequest().Scraped()
+	scraped.ID = 2002
+	scraped.Creator = "hubot"
+	scraped.Title = "Add two features"
+	scraped.URL = "https://github.com/org/repo/pull/1/"
+	scraped.Labels = []string{"enhancement"}
+	scraped.Draft = true
+	scraped.ReviewDecision = ReviewChangesRequested
+	scraped.State = StateClosed
+	scraped.HeadSHA = "bbb"
+
+	merged := stored.Merge(&scraped)
+	if got := merged.Scraped(); !reflect.DeepEqual(got, scraped) {
+		t.Errorf("scraped fields = %+v, want %+v", got, scraped)
+	}
+	if merged.Notified != stored.Notified || merged.ContentHash != stored.ContentHash ||
+		merged.Version != stored.Version || merged.ExpiresAt != stored.ExpiresAt || !merged.UpdatedAt.Equal(stored.UpdatedAt) {
+		t.Errorf("stateful fields = %+v, want them kept from %+v", merged, stored)
+	}
+
+	scraped.Labels[0] = "changed"
+	if merged.Labels[0] != "enhancement" {
+		t.Errorf("merged record shares labels with the scrape")
+	}
+	if !reflect.DeepEqual(stored, storedPullRequest()) {
+		t.Errorf("Merge modified the stored record")
+	}
+}
+
+func TestMergeKeepsUnknownFields(t *testing.T) {
+	stored := storedPullRequest()
+	stored.ReviewDecision = ReviewApproved
+
+	// Merged pull requests don't show their ID, so their review decision isn't loaded either.
+	scraped := storedPullRequest().Scraped()
+	scraped.ID = 0
+	scraped.ReviewDecision = ""
+	scraped.HeadSHA = ""
+	scraped.State = StateMerged
+
+	merged := stored.Merge(&scraped)
+	if merged.ID != stored.ID || merged.ReviewDecision != ReviewApproved || merged.HeadSHA != stored.HeadSHA {
+		t.Errorf("Merge = %+v, want ID, review decision and head kept", merged)
+	}
+	if merged.State != StateMerged {
+		t.Errorf("State = %s, want %s", merged.State, StateMerged)
+	}
+}
+
+func TestHashIgnoresStatefulFields(t *testing.T) {
+	stored := storedPullRequest()
+	scraped := stored.Scraped()
+	if stored.Hash() != scraped.Hash() {
+		t.Errorf("hash depends on stateful fields")
+	}
+
+	scraped.Title = "Renamed"
+	if stored.Hash() == scraped.Hash() {
+		t.Errorf("hash doesn't depend on the title")
+	}
+}
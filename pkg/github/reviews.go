@@ -0,0 +1,66 @@
+package github
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Parse the reviews in the timeline of a pull request's conversation page, keeping
+// the latest one from each reviewer which approved or requested changes. Reviews
+// which only left comments don't change a reviewer's state.
+func parseReviews(doc *goquery.Document, prURL string) []Review {
+	latest := make(map[string]Review)
+	doc.Find(`[id^="pullrequestreview-"]`).Each(func(_ int, item *goquery.Selection) {
+		id, _ := item.Attr("id")
+		reviewer := strings.TrimSpace(item.Find("a.author").First().Text())
+		if reviewer == "" {
+			return
+		}
+
+		state, ok := reviewState(item)
+		if !ok {
+			return
+		}
+
+		review := Review{Reviewer: reviewer, State: state, URL: prURL + "#" + id}
+		if datetime, ok := item.Find("relative-time").First().Attr("datetime"); ok {
+			review.Submitted, _ = time.Parse(time.RFC3339, datetime)
+		}
+
+		// The timeline is oldest first, so later reviews replace earlier ones.
+		latest[strings.ToLower(reviewer)] = review
+	})
+
+	reviews := make([]Review, 0, len(latest))
+	for _, review := range latest {
+		reviews = append(reviews, review)
+	}
+	sort.Slice(reviews, func(i, j int) bool {
+		return strings.ToLower(reviews[i].Reviewer) < strings.ToLower(reviews[j].Reviewer)
+	})
+	return reviews
+}
+
+// Get the state of a review in the timeline, from what it says or, if the page isn't in
+// English, from the icon on its badge. Returns false for reviews which only commented.
+func reviewState(item *goquery.Selection) (string, bool) {
+	text := strings.Join(strings.Fields(item.Text()), " ")
+	switch {
+	case strings.Contains(text, "approved these changes"):
+		return ReviewApproved, true
+	case strings.Contains(text, "requested changes"):
+		return ReviewChangesRequested, true
+	}
+
+	badge := item.Find(".TimelineItem-badge .octicon").First()
+	switch {
+	case badge.HasClass("octicon-check"):
+		return ReviewApproved, true
+	case badge.HasClass("octicon-file-diff"):
+		return ReviewChangesRequested, true
+	}
+	return "", false
+}
@@ -0,0 +1,60 @@
+package github
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const reviewsPage = `<html><body>
+<div class="js-timeline-item">
+  <div id="pullrequestreview-1">
+    <a class="author" href="/alice">alice</a> requested changes
+    <relative-time datetime="2022-07-01T15:00:00Z">Jul 1, 2022</relative-time>
+  </div>
+</div>
+<div class="js-timeline-item">
+  <div id="pullrequestreview-2">
+    <a class="author" href="/bob">bob</a> reviewed
+  </div>
+</div>
+<div class="js-timeline-item">
+  <div id="pullrequestreview-4">
+    <a class="author" href="/carol">carol</a>
+    <span class="TimelineItem-badge"><svg class="octicon octicon-file-diff"></svg></span>
+    a demandé des modifications
+  </div>
+</div>
+<div class="js-timeline-item">
+  <div id="pullrequestreview-3">
+    <a class="author" href="/alice">alice</a>
+    approved these changes
+    <relative-time datetime="2022-07-02T09:00:00Z">Jul 2, 2022</relative-time>
+  </div>
+</div>
+</body></html>`
+
+func TestParseReviews(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(reviewsPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := "https://github.com/org/repo/pull/1"
+	want := []Review{{
+		Reviewer:  "alice",
+		State:     ReviewApproved,
+		URL:       url + "#pullrequestreview-3",
+		Submitted: time.Date(2022, 7, 2, 9, 0, 0, 0, time.UTC),
+	}, {
+		Reviewer: "carol",
+		State:    ReviewChangesRequested,
+		URL:      url + "#pullrequestreview-4",
+	}}
+	if got := parseReviews(doc, url); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseReviews() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,150 @@
+package leader
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/database"
+	"github.com/ooojustin/pr-puller/pkg/metrics"
+)
+
+const LeaseName string = "pr-slacker-leader"
+
+// How long a lease lasts without a heartbeat, unless configured otherwise.
+const DefaultTTL time.Duration = 30 * time.Second
+
+// Elector competes with other replicas for a lease in the store. Whichever replica
+// holds the lease is the leader, and renews it with a heartbeat until it stops.
+// Standby replicas keep trying, and take over once the lease lapses.
+type Elector struct {
+	store    database.Store
+	owner    string
+	ttl      time.Duration
+	interval time.Duration
+
+	// Called in a new goroutine whenever this replica gains leadership.
+	OnElected func()
+
+	mu       sync.Mutex
+	leader   bool
+	renewed  time.Time
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func NewElector(store database.Store, ttl time.Duration) *Elector {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	hostname, _ := os.Hostname()
+	return &Elector{
+		store:    store,
+		owner:    fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano()),
+		ttl:      ttl,
+		interval: ttl / 3,
+		stopped:  make(chan struct{}),
+	}
+}
+
+func (e *Elector) Owner() string {
+	return e.owner
+}
+
+// Whether this replica currently holds the lease.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Don't trust a lease we haven't been able to renew before it expired.
+	return e.leader && time.Since(e.renewed) < e.ttl
+}
+
+// Start competing for leadership in the background.
+func (e *Elector) Start() {
+	go func() {
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		e.heartbeat()
+		for {
+			select {
+			case <-ticker.C:
+				e.heartbeat()
+			case <-e.stopped:
+				return
+			}
+		}
+	}()
+}
+
+// Stop competing, giving up the lease if it's held so a standby can take over.
+func (e *Elector) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.stopped)
+		if e.IsLeader() {
+			e.store.ReleaseLease(LeaseName, e.owner)
+		}
+		e.setLeader(false, time.Time{})
+	})
+}
+
+func (e *Elector) heartbeat() {
+	// The lease's heartbeat is stored in UTC, which drops the monotonic clock reading, so
+	// its expiry is tracked from when the renewal started instead. This is never later
+	// than the stored heartbeat, and isn't affected by the wall clock jumping.
+	renewed := time.Now()
+	_, err := e.store.AcquireLease(LeaseName, e.owner, e.ttl)
+	if err == database.LeaseHeldError {
+		e.setLeader(false, time.Time{})
+		return
+	} else if err != nil {
+		fmt.Println("Failed to renew leader lease:", err)
+		if !e.IsLeader() {
+			// The lease lapsed before we could renew it, so another replica may take over.
+			e.setLeader(false, time.Time{})
+		}
+		return
+	}
+
+	if elected := e.setLeader(true, renewed); elected && e.OnElected != nil {
+		go e.OnElected()
+	}
+}
+
+// Record the result of a heartbeat, returning true if leadership was just gained. A
+// lease which lapsed since the last heartbeat was lost, even if it's renewed now, since
+// another replica may have led in between.
+func (e *Elector) setLeader(leader bool, renewed time.Time) bool {
+	e.mu.Lock()
+	wasLeader := e.leader
+	lapsed := e.leader && time.Since(e.renewed) >= e.ttl
+	e.leader = leader
+	e.renewed = renewed
+	e.mu.Unlock()
+
+	if lapsed {
+		e.changed(false)
+		wasLeader = false
+	}
+	if leader == wasLeader {
+		return false
+	}
+
+	e.changed(leader)
+	return leader
+}
+
+// Log and count a change of leadership.
+func (e *Elector) changed(leader bool) {
+	metrics.LeadershipChanges.Add(1)
+	if leader {
+		metrics.Leader.Set(1)
+		fmt.Printf("Acquired leadership as %s.\n", e.owner)
+	} else {
+		metrics.Leader.Set(0)
+		fmt.Printf("Lost leadership as %s.\n", e.owner)
+	}
+}
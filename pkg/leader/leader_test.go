@@ -0,0 +1,94 @@
+package leader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/database"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestElectorFailover(t *testing.T) {
+	store := database.NewMemoryStore()
+	ttl := 90 * time.Millisecond
+
+	elected := make(chan string, 2)
+	a := NewElector(store, ttl)
+	a.OnElected = func() { elected <- "a" }
+	a.Start()
+	waitFor(t, "a to lead", a.IsLeader)
+
+	b := NewElector(store, ttl)
+	b.OnElected = func() { elected <- "b" }
+	b.Start()
+	defer b.Stop()
+
+	// The standby never leads while the leader keeps heartbeating.
+	time.Sleep(2 * ttl)
+	if b.IsLeader() {
+		t.Fatal("standby acquired leadership while the lease was held")
+	}
+
+	a.Stop()
+	waitFor(t, "b to take over", b.IsLeader)
+	if a.IsLeader() {
+		t.Fatal("stopped elector still reports leadership")
+	}
+
+	if first, second := <-elected, <-elected; first != "a" || second != "b" {
+		t.Fatalf("elected order = %s, %s; want a, b", first, second)
+	}
+}
+
+// A store whose clock is behind, so the heartbeats it records look stale.
+type skewedStore struct {
+	database.Store
+}
+
+func (s skewedStore) AcquireLease(name string, owner string, ttl time.Duration) (*database.Lease, error) {
+	lease, err := s.Store.AcquireLease(name, owner, ttl)
+	if lease != nil {
+		lease.Heartbeat = lease.Heartbeat.Add(-time.Hour)
+	}
+	return lease, err
+}
+
+func TestElectorIgnoresStoredHeartbeat(t *testing.T) {
+	e := NewElector(skewedStore{database.NewMemoryStore()}, time.Minute)
+	e.heartbeat()
+	defer e.Stop()
+
+	// Expiry is tracked with the local clock, not the time the store recorded.
+	if !e.IsLeader() {
+		t.Fatal("elector lost leadership to the store's clock")
+	}
+}
+
+func TestElectorLapsedLease(t *testing.T) {
+	e := NewElector(database.NewMemoryStore(), 50*time.Millisecond)
+	elected := make(chan struct{}, 2)
+	e.OnElected = func() { elected <- struct{}{} }
+	defer e.Stop()
+
+	e.heartbeat()
+	<-elected
+
+	// The lease lapses before it's renewed, which is a new term.
+	time.Sleep(60 * time.Millisecond)
+	e.heartbeat()
+	select {
+	case <-elected:
+	case <-time.After(time.Second):
+		t.Fatal("renewing a lapsed lease didn't start a new term")
+	}
+}
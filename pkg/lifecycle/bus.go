@@ -0,0 +1,98 @@
+package lifecycle
+
+import (
+	"sync"
+)
+
+// Handler is called with each event it's subscribed to, once the change it describes
+// is stored.
+type Handler func(event *Event)
+
+// Preparer is called with each event it's subscribed to before the change it describes
+// is stored, and returns anything which should be stored along with the change, such
+// as queued notifications. Those are only stored if the change is.
+type Preparer func(event *Event) []interface{}
+
+type subscription struct {
+	types    map[string]bool
+	handler  Handler
+	preparer Preparer
+}
+
+func (sub *subscription) matches(event *Event) bool {
+	return sub.types == nil || sub.types[event.Type]
+}
+
+// Bus delivers lifecycle events to the handlers which subscribed to them, such as
+// notifiers, history and metrics. Handlers are called synchronously, in the order they
+// subscribed. A nil *Bus has no subscribers.
+type Bus struct {
+	mu            sync.RWMutex
+	subscriptions []subscription
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Call handler with every published event of the given types, or with every event if
+// no types are given.
+func (bus *Bus) Subscribe(handler Handler, eventTypes ...string) {
+	bus.subscribe(subscription{handler: handler}, eventTypes)
+}
+
+// Call preparer with every prepared event of the given types, or with every event if
+// no types are given.
+func (bus *Bus) SubscribePrepare(preparer Preparer, eventTypes ...string) {
+	bus.subscribe(subscription{preparer: preparer}, eventTypes)
+}
+
+func (bus *Bus) subscribe(sub subscription, eventTypes []string) {
+	if len(eventTypes) > 0 {
+		sub.types = make(map[string]bool)
+		for _, eventType := range eventTypes {
+			sub.types[eventType] = true
+		}
+	}
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.subscriptions = append(bus.subscriptions, sub)
+}
+
+// Deliver events to their preparers before the change they describe is stored, and
+// collect what the preparers ask to store along with it, in order.
+func (bus *Bus) Prepare(events ...*Event) []interface{} {
+	var prepared []interface{}
+	subscriptions := bus.snapshot()
+	for _, event := range events {
+		for _, sub := range subscriptions {
+			if sub.preparer != nil && sub.matches(event) {
+				prepared = append(prepared, sub.preparer(event)...)
+			}
+		}
+	}
+	return prepared
+}
+
+// Deliver events to their handlers once the change they describe is stored.
+func (bus *Bus) Publish(events ...*Event) {
+	subscriptions := bus.snapshot()
+	for _, event := range events {
+		for _, sub := range subscriptions {
+			if sub.handler != nil && sub.matches(event) {
+				sub.handler(event)
+			}
+		}
+	}
+}
+
+func (bus *Bus) snapshot() []subscription {
+	if bus == nil {
+		return nil
+	}
+
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	return bus.subscriptions
+}
@@ -0,0 +1,191 @@
+package lifecycle
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+)
+
+// Types of events in the life of a pull request.
+const (
+	Opened           string = "opened"
+	ReadyForReview   string = "ready_for_review"
+	ConvertedToDraft string = "converted_to_draft"
+	ReviewRequired   string = "review_required"
+	ChangesRequested string = "changes_requested"
+	Approved         string = "approved"
+	Reviewed         string = "reviewed"
+	LabelsChanged    string = "labels_changed"
+	TitleChanged     string = "title_changed"
+	NewCommits       string = "new_commits"
+	CIFailed         string = "ci_failed"
+	Closed           string = "closed"
+	Merged           string = "merged"
+)
+
+// Types of events which aren't found by Diff, but by watching how long a pull request
+// has waited for review.
+const (
+	Reminded  string = "reminded"
+	Escalated string = "escalated"
+)
+
+// Event is a single change between two snapshots of a pull request.
+type Event struct {
+	Type      string
+	PK        string
+	Old       string
+	New       string
+	Timestamp time.Time
+
+	// The snapshot which was stored before the change (nil if there wasn't one),
+	// and the one which was fetched after it.
+	Previous *pr_gh.PullRequest
+	Current  *pr_gh.PullRequest
+
+	// The review which was submitted, for reviewed events.
+	Review *pr_gh.Review
+}
+
+// Compare the stored snapshot of a pull request (nil if it wasn't stored yet) with a
+// freshly fetched one, and return the events which happened in between, in the order
+// they should be recorded.
+func Diff(previous *pr_gh.PullRequest, current *pr_gh.PullRequest, now time.Time) []*Event {
+	var events []*Event
+	add := func(eventType string, old string, new string, ts time.Time) {
+		events = append(events, &Event{
+			Type:      eventType,
+			PK:        current.PK,
+			Old:       old,
+			New:       new,
+			Timestamp: ts.UTC(),
+			Previous:  previous,
+			Current:   current,
+		})
+	}
+
+	before := previous
+	if before == nil {
+		// "Old" notes whether the pull request was opened as a draft.
+		var opened string
+		if current.Draft {
+			opened = "draft"
+		}
+		add(Opened, opened, current.State, current.Created)
+		if current.State != pr_gh.StateOpen {
+			return events
+		}
+
+		// Everything else is compared with how the pull request looked when it was opened,
+		// which is only known to be open, with the same draft status, title and labels.
+		before = &pr_gh.PullRequest{
+			Draft:   current.Draft,
+			State:   pr_gh.StateOpen,
+			Title:   current.Title,
+			Labels:  current.Labels,
+			HeadSHA: current.HeadSHA,
+		}
+	}
+
+	if before.Draft != current.Draft {
+		eventType := ReadyForReview
+		if current.Draft {
+			eventType = ConvertedToDraft
+		}
+		add(eventType, strconv.FormatBool(before.Draft), strconv.FormatBool(current.Draft), now)
+	}
+
+	if before.ReviewDecision != current.ReviewDecision {
+		var eventType string
+		switch current.ReviewDecision {
+		case pr_gh.ReviewRequired:
+			eventType = ReviewRequired
+		case pr_gh.ReviewChangesRequested:
+			eventType = ChangesRequested
+		case pr_gh.ReviewApproved:
+			eventType = Approved
+		}
+		if eventType != "" {
+			add(eventType, before.ReviewDecision, current.ReviewDecision, now)
+		}
+	}
+
+	// Reviews aren't always known. Reviews on a pull request which wasn't stored yet
+	// happened before it was seen, so they aren't reported either.
+	if previous != nil {
+		for idx := range current.Reviews {
+			review := &current.Reviews[idx]
+			oldReview, known := previous.Review(review.Reviewer)
+			if known && oldReview.URL == review.URL && oldReview.State == review.State {
+				continue
+			}
+
+			var old string
+			if known {
+				old = describeReview(oldReview)
+			}
+			ts := review.Submitted
+			if ts.IsZero() {
+				ts = now
+			}
+			add(Reviewed, old, describeReview(review), ts)
+			events[len(events)-1].Review = review
+		}
+	}
+
+	if oldLabels, newLabels := joinLabels(before.Labels), joinLabels(current.Labels); oldLabels != newLabels {
+		add(LabelsChanged, oldLabels, newLabels, now)
+	}
+
+	if before.Title != current.Title {
+		add(TitleChanged, before.Title, current.Title, now)
+	}
+
+	// The head commit isn't always known, so only compare it when both snapshots have it.
+	if before.HeadSHA != "" && current.HeadSHA != "" && before.HeadSHA != current.HeadSHA {
+		add(NewCommits, before.HeadSHA, current.HeadSHA, now)
+	}
+
+	// Like the head commit, CI status isn't always known.
+	if before.CIStatus != "" && before.CIStatus != current.CIStatus && current.CIStatus == pr_gh.CIFailure {
+		add(CIFailed, before.CIStatus, current.CIStatus, now)
+	}
+
+	if before.State != current.State {
+		switch current.State {
+		case pr_gh.StateMerged:
+			add(Merged, before.State, current.State, now)
+		case pr_gh.StateClosed:
+			add(Closed, before.State, current.State, now)
+		}
+	}
+
+	return events
+}
+
+// Describe a review for the history of a pull request. (ex: "Approved by octocat")
+func describeReview(review *pr_gh.Review) string {
+	return review.State + " by " + review.Reviewer
+}
+
+// Labels in a stable order, so reordering them isn't reported as a change.
+func joinLabels(labels []string) string {
+	sorted := append([]string(nil), labels...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// Whether any of the events has one of the given types.
+func Contains(events []*Event, eventTypes ...string) bool {
+	for _, event := range events {
+		for _, eventType := range eventTypes {
+			if event.Type == eventType {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package lifecycle_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+)
+
+func newPullRequest() *pr_gh.PullRequest {
+	return &pr_gh.PullRequest{
+		PK:             "org#repo#1",
+		Created:        time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC),
+		Title:          "Add a feature",
+		Labels:         []string{"bug", "enhancement"},
+		ReviewDecision: pr_gh.ReviewRequired,
+		State:          pr_gh.StateOpen,
+		HeadSHA:        "aaa",
+	}
+}
+
+func types(events []*lifecycle.Event) []string {
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous func(pr *pr_gh.PullRequest)
+		current  func(pr *pr_gh.PullRequest)
+		want     []string
+	}{
+		{"Unchanged", func(pr *pr_gh.PullRequest) {}, func(pr *pr_gh.PullRequest) {}, nil},
+		{"ReadyForReview",
+			func(pr *pr_gh.PullRequest) { pr.Draft = true },
+			func(pr *pr_gh.PullRequest) {},
+			[]string{lifecycle.ReadyForReview}},
+		{"ConvertedToDraft",
+			func(pr *pr_gh.PullRequest) {},
+			func(pr *pr_gh.PullRequest) { pr.Draft = true },
+			[]string{lifecycle.ConvertedToDraft}},
+		{"ReviewRequired",
+			func(pr *pr_gh.PullRequest) { pr.ReviewDecision = pr_gh.ReviewChangesRequested },
+			func(pr *pr_gh.PullRequest) {},
+			[]string{lifecycle.ReviewRequired}},
+		{"ChangesRequested",
+			func(pr *pr_gh.PullRequest) {},
+			func(pr *pr_gh.PullRequest) { pr.ReviewDecision = pr_gh.ReviewChangesRequested },
+			[]string{lifecycle.ChangesRequested}},
+		{"Approved",
+			func(pr *pr_gh.PullRequest) {},
+			func(pr *pr_gh.PullRequest) { pr.ReviewDecision = pr_gh.ReviewApproved },
+			[]string{lifecycle.Approved}},
+		{"LabelsChanged",
+			func(pr *pr_gh.PullRequest) {},
+			func(pr *pr_gh.PullRequest) { pr.Labels = []string{"bug"} },
+			[]string{lifecycle.LabelsChanged}},
+		{"LabelsReordered",
+			func(pr *pr_gh.PullRequest) {},
+			func(pr *pr_gh.PullRequest) { pr.Labels = []string{"enhancement", "bug"} },
+			nil},
+		{"TitleChanged",
+			func(pr *pr_gh.PullRequest) {},
+			func(pr *pr_gh.PullRequest) { pr.Title = "Add two features" },
+			[]string{lifecycle.TitleChanged}},
+		{"NewCommits",
+			func(pr *pr_gh.PullRequest) {},
+			func(pr *pr_gh.PullRequest) { pr.HeadSHA = "bbb" },
+			[]string{lifecycle.NewCommits}},
+		{"HeadUnknown",
+			func(pr *pr_gh.PullRequest) { pr.HeadSHA = "" },
+			func(pr *pr_gh.PullRequest) {},
+			nil},
+		{"CIFailed",
+			func(pr *pr_gh.PullRequest) { pr.CIStatus = pr_gh.CIPending },
+			func(pr *pr_gh.PullRequest) { pr.CIStatus = pr_gh.CIFailure },
+			[]string{lifecycle.CIFailed}},
+		{"CIUnknown",
+			func(pr *pr_gh.PullRequest) {},
+			func(pr *pr_gh.PullRequest) { pr.CIStatus = pr_gh.CIFailure },
+			nil},
+		{"Merged",
+			func(pr *pr_gh.PullRequest) { pr.ReviewDecision = pr_gh.ReviewApproved },
+			func(pr *pr_gh.PullRequest) { pr.ReviewDecision = pr_gh.ReviewApproved; pr.State = pr_gh.StateMerged },
+			[]string{lifecycle.Merged}},
+		{"Closed",
+			func(pr *pr_gh.PullRequest) {},
+			func(pr *pr_gh.PullRequest) { pr.State = pr_gh.StateClosed },
+			[]string{lifecycle.Closed}},
+		{"Several",
+			func(pr *pr_gh.PullRequest) { pr.Draft = true },
+			func(pr *pr_gh.PullRequest) { pr.Title = "Renamed"; pr.ReviewDecision = pr_gh.ReviewApproved },
+			[]string{lifecycle.ReadyForReview, lifecycle.Approved, lifecycle.TitleChanged}},
+	}
+
+	now := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous, current := newPullRequest(), newPullRequest()
+			tt.previous(previous)
+			tt.current(current)
+
+			events := lifecycle.Diff(previous, current, now)
+			if got := types(events); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Diff = %v, want %v", got, tt.want)
+			}
+			for _, event := range events {
+				if event.PK != current.PK || !event.Timestamp.Equal(now) || event.Previous != previous || event.Current != current {
+					t.Errorf("event %s = %+v", event.Type, event)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffReviews(t *testing.T) {
+	approval := pr_gh.Review{Reviewer: "alice", State: pr_gh.ReviewApproved, URL: "https://github.com/org/repo/pull/1#pullrequestreview-2",
+		Submitted: time.Date(2022, 7, 2, 9, 0, 0, 0, time.UTC)}
+	changes := pr_gh.Review{Reviewer: "alice", State: pr_gh.ReviewChangesRequested, URL: "https://github.com/org/repo/pull/1#pullrequestreview-1"}
+
+	previous, current := newPullRequest(), newPullRequest()
+	previous.Reviews = []pr_gh.Review{changes}
+	current.Reviews = []pr_gh.Review{approval, {Reviewer: "bob", State: pr_gh.ReviewApproved, URL: "https://github.com/org/repo/pull/1#pullrequestreview-3"}}
+
+	now := time.Now()
+	events := lifecycle.Diff(previous, current, now)
+	if got := types(events); !reflect.DeepEqual(got, []string{lifecycle.Reviewed, lifecycle.Reviewed}) {
+		t.Fatalf("Diff = %v, want a reviewed event for each new review", got)
+	}
+	if events[0].Review.Reviewer != "alice" || events[0].Old != "Changes requested by alice" || events[0].New != "Approved by alice" ||
+		!events[0].Timestamp.Equal(approval.Submitted) {
+		t.Errorf("alice's review = %+v", events[0])
+	}
+	if events[1].Review.Reviewer != "bob" || events[1].Old != "" || !events[1].Timestamp.Equal(now) {
+		t.Errorf("bob's review = %+v", events[1])
+	}
+
+	// Reviews which were already stored, or which happened before it was stored, aren't new.
+	if events := lifecycle.Diff(current, current, now); len(events) != 0 {
+		t.Errorf("Diff(unchanged) = %v", types(events))
+	}
+	if got := types(lifecycle.Diff(nil, current, now)); !reflect.DeepEqual(got, []string{lifecycle.Opened, lifecycle.ReviewRequired}) {
+		t.Errorf("Diff(nil) = %v, want no reviewed events", got)
+	}
+}
+
+func TestDiffOpened(t *testing.T) {
+	pr := newPullRequest()
+	events := lifecycle.Diff(nil, pr, time.Now())
+	if got, want := types(events), []string{lifecycle.Opened, lifecycle.ReviewRequired}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Diff(nil, open) = %v, want %v", got, want)
+	}
+	if !events[0].Timestamp.Equal(pr.Created) || events[0].Old != "" {
+		t.Errorf("opened = %+v, want creation time, not a draft", events[0])
+	}
+
+	pr.Draft = true
+	pr.ReviewDecision = ""
+	if events = lifecycle.Diff(nil, pr, time.Now()); len(events) != 1 || events[0].Old != "draft" {
+		t.Errorf("Diff(nil, draft) = %+v", events)
+	}
+
+	pr.State = pr_gh.StateMerged
+	if got := types(lifecycle.Diff(nil, pr, time.Now())); !reflect.DeepEqual(got, []string{lifecycle.Opened}) {
+		t.Errorf("Diff(nil, merged) = %v", got)
+	}
+}
+
+func TestBus(t *testing.T) {
+	var nilBus *lifecycle.Bus
+	nilBus.Publish(&lifecycle.Event{Type: lifecycle.Opened})
+
+	bus := lifecycle.NewBus()
+	var all, merged []string
+	bus.Subscribe(func(event *lifecycle.Event) { all = append(all, event.Type) })
+	bus.Subscribe(func(event *lifecycle.Event) { merged = append(merged, event.Type) }, lifecycle.Merged, lifecycle.Closed)
+
+	bus.Publish(&lifecycle.Event{Type: lifecycle.Approved}, &lifecycle.Event{Type: lifecycle.Merged})
+	if !reflect.DeepEqual(all, []string{lifecycle.Approved, lifecycle.Merged}) {
+		t.Errorf("unfiltered subscriber got %v", all)
+	}
+	if !reflect.DeepEqual(merged, []string{lifecycle.Merged}) {
+		t.Errorf("filtered subscriber got %v", merged)
+	}
+
+	if prepared := nilBus.Prepare(&lifecycle.Event{Type: lifecycle.Opened}); prepared != nil {
+		t.Errorf("nil bus prepared %v", prepared)
+	}
+	bus.SubscribePrepare(func(event *lifecycle.Event) []interface{} {
+		return []interface{}{event.Type + " message"}
+	}, lifecycle.Merged)
+	prepared := bus.Prepare(&lifecycle.Event{Type: lifecycle.Approved}, &lifecycle.Event{Type: lifecycle.Merged})
+	if !reflect.DeepEqual(prepared, []interface{}{"merged message"}) {
+		t.Errorf("Prepare() = %v", prepared)
+	}
+	if len(all) != 2 {
+		t.Errorf("handlers were called while preparing: %v", all)
+	}
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// 1 while this replica holds the leader lease, otherwise 0.
+	Leader = expvar.NewInt("leader")
+
+	// Number of times this replica has gained or lost leadership.
+	LeadershipChanges = expvar.NewInt("leadership_changes")
+
+	// Number of lifecycle events seen, by type.
+	LifecycleEvents = expvar.NewMap("lifecycle_events")
+
+	// Number of notifications which Slack has accepted.
+	NotificationsSent = expvar.NewInt("notifications_sent")
+
+	// Number of failed attempts to deliver a notification.
+	NotificationFailures = expvar.NewInt("notification_failures")
+
+	// Number of notifications which were given up on after repeated failures.
+	NotificationsDead = expvar.NewInt("notifications_dead")
+)
+
+// Serve metrics as JSON over HTTP at /debug/vars, in the background.
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Println("Failed to serve metrics:", err)
+		}
+	}()
+}
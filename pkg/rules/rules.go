@@ -0,0 +1,285 @@
+package rules
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+// Names of the rules which are used when none are configured.
+const (
+	DefaultRuleName       string = "default"
+	DefaultAuthorRuleName string = "default_author"
+)
+
+// Engine evaluates notification rules in order. The first rule which matches an
+// event fires, and the rest are ignored.
+type Engine struct {
+	rules          []utils.NotificationRule
+	defaultChannel string
+	users          map[string]string
+	optOut         map[string]bool // authors who don't want direct messages
+}
+
+// Notification is a single Slack message which a rule asked for. Destination is a
+// channel ID, or the ID of a user to send a direct message to.
+type Notification struct {
+	Destination string
+	Mention     string // user group ID to mention, if any
+}
+
+// Result is the outcome of the rule which fired for an event.
+type Result struct {
+	Rule          string
+	Event         string
+	Suppressed    bool
+	Important     bool // replies in the pull request's thread are broadcast
+	Notifications []Notification
+}
+
+// Explanation describes why a single rule did or didn't match an event.
+type Explanation struct {
+	Rule    string
+	Matched bool
+	Reason  string
+}
+
+// Create an engine for the given rules. If there are none, a default rule announces
+// pull requests which are ready for review in defaultChannel. users maps Github
+// usernames to Slack user IDs, for direct messages.
+func NewEngine(rules []utils.NotificationRule, defaultChannel string, users map[string]string) *Engine {
+	if len(rules) == 0 {
+		rules = DefaultRules()
+	}
+	return &Engine{rules: rules, defaultChannel: defaultChannel, users: users}
+}
+
+// Create an engine from the rules, default channel, user mapping and author opt-outs
+// in config.
+func NewEngineFromConfig(cfg *utils.Config) *Engine {
+	engine := NewEngine(cfg.NotificationRules, cfg.SlackChannelID, cfg.SlackUsers)
+	engine.OptOut(cfg.AuthorOptOut...)
+	return engine
+}
+
+// Stop sending direct messages to the given authors. (Github usernames)
+func (engine *Engine) OptOut(authors ...string) {
+	if engine.optOut == nil {
+		engine.optOut = make(map[string]bool)
+	}
+	for _, author := range authors {
+		engine.optOut[strings.ToLower(author)] = true
+	}
+}
+
+// Rules which announce a pull request when it's opened, leaves draft, or needs review
+// again, as long as it isn't a draft or already approved, and tell its author when
+// it's approved or has changes requested.
+func DefaultRules() []utils.NotificationRule {
+	draft := false
+	return []utils.NotificationRule{{
+		Name:            DefaultRuleName,
+		Events:          []string{lifecycle.Opened, lifecycle.ReadyForReview, lifecycle.ReviewRequired},
+		Draft:           &draft,
+		ReviewDecisions: []string{"", pr_gh.ReviewRequired, pr_gh.ReviewChangesRequested},
+		Actions:         []utils.RuleAction{{Type: utils.ActionPost}},
+	}, {
+		Name:            DefaultAuthorRuleName,
+		Events:          []string{lifecycle.Reviewed},
+		ReviewDecisions: []string{pr_gh.ReviewApproved, pr_gh.ReviewChangesRequested},
+		Actions:         []utils.RuleAction{{Type: utils.ActionDMAuthor}},
+	}}
+}
+
+// Evaluate the rules against an event, returning the result of the rule which fired,
+// or nil if none matched.
+func (engine *Engine) Evaluate(event *lifecycle.Event) *Result {
+	for idx := range engine.rules {
+		rule := &engine.rules[idx]
+		if ok, _ := match(rule, event); ok {
+			return engine.fire(idx, rule, event)
+		}
+	}
+	return nil
+}
+
+// Evaluate the rules against an event like Evaluate, and also explain the outcome of
+// each rule up to and including the one which fired.
+func (engine *Engine) Explain(event *lifecycle.Event) ([]Explanation, *Result) {
+	var explanations []Explanation
+	for idx := range engine.rules {
+		rule := &engine.rules[idx]
+		ok, reason := match(rule, event)
+		explanations = append(explanations, Explanation{Rule: ruleName(idx, rule), Matched: ok, Reason: reason})
+		if ok {
+			return explanations, engine.fire(idx, rule, event)
+		}
+	}
+	return explanations, nil
+}
+
+func (engine *Engine) fire(idx int, rule *utils.NotificationRule, event *lifecycle.Event) *Result {
+	result := &Result{Rule: ruleName(idx, rule), Event: event.Type, Important: rule.Important}
+	for _, action := range rule.Actions {
+		switch action.Type {
+		case utils.ActionSuppress:
+			result.Suppressed = true
+			result.Notifications = nil
+			return result
+		case utils.ActionPost:
+			result.add(Notification{Destination: engine.channel(action)})
+		case utils.ActionMention:
+			result.add(Notification{Destination: engine.channel(action), Mention: action.Group})
+		case utils.ActionDMReviewers:
+			for _, reviewer := range event.Current.RequestedReviewers {
+				if user, ok := engine.users[reviewer]; ok {
+					result.add(Notification{Destination: user})
+				}
+			}
+		case utils.ActionDMAuthor:
+			if user, ok := engine.authorUser(event); ok {
+				result.add(Notification{Destination: user})
+			}
+		}
+	}
+	return result
+}
+
+// Get the Slack user to send the author of a pull request a direct message, unless
+// they opted out. Approvals are only worth telling the author about once the pull
+// request can be merged: it's approved overall, and its checks haven't failed.
+func (engine *Engine) authorUser(event *lifecycle.Event) (string, bool) {
+	pr := event.Current
+	if engine.optOut[strings.ToLower(pr.Creator)] {
+		return "", false
+	}
+	if event.Review != nil && event.Review.State == pr_gh.ReviewApproved &&
+		(pr.ReviewDecision != pr_gh.ReviewApproved || pr.CIStatus == pr_gh.CIFailure) {
+		return "", false
+	}
+
+	user, ok := engine.users[pr.Creator]
+	return user, ok
+}
+
+// Add a notification, unless one was already added for its destination.
+func (result *Result) add(notification Notification) {
+	if notification.Destination == "" {
+		return
+	}
+	for idx, existing := range result.Notifications {
+		if existing.Destination == notification.Destination {
+			if existing.Mention == "" {
+				result.Notifications[idx].Mention = notification.Mention
+			}
+			return
+		}
+	}
+	result.Notifications = append(result.Notifications, notification)
+}
+
+func (engine *Engine) channel(action utils.RuleAction) string {
+	if action.Channel != "" {
+		return action.Channel
+	}
+	return engine.defaultChannel
+}
+
+func ruleName(idx int, rule *utils.NotificationRule) string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return fmt.Sprintf("#%d", idx+1)
+}
+
+// Whether a rule matches an event. If it doesn't, the reason is the first condition
+// which failed.
+func match(rule *utils.NotificationRule, event *lifecycle.Event) (bool, string) {
+	pr := event.Current
+
+	if len(rule.Events) > 0 && !contains(rule.Events, event.Type) {
+		return false, fmt.Sprintf("event %s isn't one of %s", event.Type, list(rule.Events))
+	}
+	if len(rule.Repositories) > 0 && !matchesGlob(rule.Repositories, pr.Repository) {
+		return false, fmt.Sprintf("repository %s doesn't match %s", pr.Repository, list(rule.Repositories))
+	}
+	if len(rule.Labels) > 0 && !containsAny(pr.Labels, rule.Labels) {
+		return false, fmt.Sprintf("labels %s don't include any of %s", list(pr.Labels), list(rule.Labels))
+	}
+	if containsAny(pr.Labels, rule.ExcludeLabels) {
+		return false, fmt.Sprintf("labels %s include one of %s", list(pr.Labels), list(rule.ExcludeLabels))
+	}
+	if len(rule.Authors) > 0 && !contains(rule.Authors, pr.Creator) {
+		return false, fmt.Sprintf("author %s isn't one of %s", pr.Creator, list(rule.Authors))
+	}
+	if len(rule.BaseBranches) > 0 {
+		if pr.BaseBranch == "" {
+			return false, "base branch isn't known"
+		} else if !matchesGlob(rule.BaseBranches, pr.BaseBranch) {
+			return false, fmt.Sprintf("base branch %s doesn't match %s", pr.BaseBranch, list(rule.BaseBranches))
+		}
+	}
+	if rule.MinSize > 0 || rule.MaxSize > 0 {
+		size, ok := pr.Size()
+		if !ok {
+			return false, "size isn't known"
+		} else if size < rule.MinSize {
+			return false, fmt.Sprintf("size %d is below the minimum of %d", size, rule.MinSize)
+		} else if rule.MaxSize > 0 && size > rule.MaxSize {
+			return false, fmt.Sprintf("size %d is above the maximum of %d", size, rule.MaxSize)
+		}
+	}
+	if len(rule.CIStatuses) > 0 {
+		if pr.CIStatus == "" {
+			return false, "CI status isn't known"
+		} else if !contains(rule.CIStatuses, pr.CIStatus) {
+			return false, fmt.Sprintf("CI status %s isn't one of %s", pr.CIStatus, list(rule.CIStatuses))
+		}
+	}
+	if rule.Draft != nil && *rule.Draft != pr.Draft {
+		if pr.Draft {
+			return false, "pull request is a draft"
+		}
+		return false, "pull request isn't a draft"
+	}
+	if len(rule.ReviewDecisions) > 0 && !contains(rule.ReviewDecisions, pr.ReviewDecision) {
+		return false, fmt.Sprintf("review decision %q isn't one of %s", pr.ReviewDecision, list(rule.ReviewDecisions))
+	}
+
+	return true, "all conditions matched"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values []string, wanted []string) bool {
+	for _, value := range wanted {
+		if contains(values, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesGlob(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func list(values []string) string {
+	return "[" + strings.Join(values, ", ") + "]"
+}
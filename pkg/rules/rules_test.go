@@ -0,0 +1,219 @@
+package rules_test
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+	"github.com/ooojustin/pr-puller/pkg/rules"
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+func newEvent(eventType string, mutate func(pr *pr_gh.PullRequest)) *lifecycle.Event {
+	pr := &pr_gh.PullRequest{
+		PK:                 "org#web-app#1",
+		Creator:            "octocat",
+		Repository:         "web-app",
+		Organization:       "org",
+		Labels:             []string{"frontend"},
+		ReviewDecision:     pr_gh.ReviewRequired,
+		State:              pr_gh.StateOpen,
+		BaseBranch:         "main",
+		Additions:          120,
+		Deletions:          30,
+		ChangedFiles:       4,
+		CIStatus:           pr_gh.CISuccess,
+		RequestedReviewers: []string{"alice", "bob", "carol"},
+	}
+	if mutate != nil {
+		mutate(pr)
+	}
+	return &lifecycle.Event{Type: eventType, PK: pr.PK, Timestamp: time.Now(), Current: pr}
+}
+
+func TestDefaultRules(t *testing.T) {
+	engine := rules.NewEngine(nil, "C123", nil)
+
+	result := engine.Evaluate(newEvent(lifecycle.Opened, nil))
+	if result == nil || result.Rule != rules.DefaultRuleName ||
+		!reflect.DeepEqual(result.Notifications, []rules.Notification{{Destination: "C123"}}) {
+		t.Fatalf("Evaluate(opened) = %+v", result)
+	}
+
+	for name, event := range map[string]*lifecycle.Event{
+		"draft":    newEvent(lifecycle.Opened, func(pr *pr_gh.PullRequest) { pr.Draft = true }),
+		"approved": newEvent(lifecycle.Opened, func(pr *pr_gh.PullRequest) { pr.ReviewDecision = pr_gh.ReviewApproved }),
+		"merged":   newEvent(lifecycle.Merged, nil),
+	} {
+		if result := engine.Evaluate(event); result != nil {
+			t.Errorf("Evaluate(%s) = %+v, want no rule to fire", name, result)
+		}
+	}
+}
+
+func TestDMAuthor(t *testing.T) {
+	engine := rules.NewEngine(nil, "C123", map[string]string{"octocat": "UOCTO"})
+
+	reviewed := func(state string, mutate func(pr *pr_gh.PullRequest)) *lifecycle.Event {
+		event := newEvent(lifecycle.Reviewed, func(pr *pr_gh.PullRequest) {
+			pr.ReviewDecision = state
+			if mutate != nil {
+				mutate(pr)
+			}
+		})
+		event.Review = &pr_gh.Review{Reviewer: "alice", State: state}
+		return event
+	}
+
+	for name, event := range map[string]*lifecycle.Event{
+		"approved": reviewed(pr_gh.ReviewApproved, nil),
+		"changes":  reviewed(pr_gh.ReviewChangesRequested, nil),
+	} {
+		result := engine.Evaluate(event)
+		if result == nil || result.Rule != rules.DefaultAuthorRuleName ||
+			!reflect.DeepEqual(result.Notifications, []rules.Notification{{Destination: "UOCTO"}}) {
+			t.Errorf("Evaluate(%s) = %+v, want a DM to the author", name, result)
+		}
+	}
+
+	// Approvals are only sent once the pull request can be merged.
+	failing := reviewed(pr_gh.ReviewApproved, func(pr *pr_gh.PullRequest) { pr.CIStatus = pr_gh.CIFailure })
+	if result := engine.Evaluate(failing); result == nil || len(result.Notifications) != 0 {
+		t.Errorf("Evaluate(failing) = %+v, want no DM", result)
+	}
+	partial := reviewed(pr_gh.ReviewApproved, nil)
+	partial.Current.ReviewDecision = pr_gh.ReviewRequired
+	if result := engine.Evaluate(partial); result != nil {
+		t.Errorf("Evaluate(partial) = %+v, want no rule to fire", result)
+	}
+
+	engine.OptOut("OctoCat")
+	if result := engine.Evaluate(reviewed(pr_gh.ReviewChangesRequested, nil)); result == nil || len(result.Notifications) != 0 {
+		t.Errorf("Evaluate(opted out) = %+v, want no DM", result)
+	}
+}
+
+func TestConditions(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   utils.NotificationRule
+		mutate func(pr *pr_gh.PullRequest)
+		want   bool
+	}{
+		{"Event", utils.NotificationRule{Events: []string{lifecycle.Approved}}, nil, false},
+		{"RepositoryGlob", utils.NotificationRule{Repositories: []string{"web-*"}}, nil, true},
+		{"Repository", utils.NotificationRule{Repositories: []string{"api"}}, nil, false},
+		{"Labels", utils.NotificationRule{Labels: []string{"backend", "frontend"}}, nil, true},
+		{"MissingLabel", utils.NotificationRule{Labels: []string{"backend"}}, nil, false},
+		{"ExcludedLabel", utils.NotificationRule{ExcludeLabels: []string{"frontend"}}, nil, false},
+		{"Author", utils.NotificationRule{Authors: []string{"octocat"}}, nil, true},
+		{"OtherAuthor", utils.NotificationRule{Authors: []string{"hubot"}}, nil, false},
+		{"BaseBranch", utils.NotificationRule{BaseBranches: []string{"release/*", "main"}}, nil, true},
+		{"UnknownBaseBranch", utils.NotificationRule{BaseBranches: []string{"main"}},
+			func(pr *pr_gh.PullRequest) { pr.BaseBranch = "" }, false},
+		{"Size", utils.NotificationRule{MinSize: 100, MaxSize: 200}, nil, true},
+		{"TooLarge", utils.NotificationRule{MaxSize: 100}, nil, false},
+		{"TooSmall", utils.NotificationRule{MinSize: 500}, nil, false},
+		{"UnknownSize", utils.NotificationRule{MaxSize: 100},
+			func(pr *pr_gh.PullRequest) { pr.Additions, pr.Deletions, pr.ChangedFiles = 0, 0, 0 }, false},
+		{"CIStatus", utils.NotificationRule{CIStatuses: []string{pr_gh.CISuccess}}, nil, true},
+		{"FailingCI", utils.NotificationRule{CIStatuses: []string{pr_gh.CISuccess}},
+			func(pr *pr_gh.PullRequest) { pr.CIStatus = pr_gh.CIFailure }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Actions = []utils.RuleAction{{Type: utils.ActionPost}}
+			engine := rules.NewEngine([]utils.NotificationRule{tt.rule}, "C123", nil)
+
+			explanations, result := engine.Explain(newEvent(lifecycle.Opened, tt.mutate))
+			if got := result != nil; got != tt.want {
+				t.Fatalf("fired = %t, want %t (%+v)", got, tt.want, explanations)
+			}
+			if len(explanations) != 1 || explanations[0].Reason == "" {
+				t.Fatalf("explanations = %+v", explanations)
+			}
+		})
+	}
+}
+
+func TestActions(t *testing.T) {
+	engine := rules.NewEngine([]utils.NotificationRule{
+		{
+			Name:    "quiet-docs",
+			Labels:  []string{"docs"},
+			Actions: []utils.RuleAction{{Type: utils.ActionPost}, {Type: utils.ActionSuppress}},
+		},
+		{
+			Name:   "frontend",
+			Labels: []string{"frontend"},
+			Actions: []utils.RuleAction{
+				{Type: utils.ActionMention, Channel: "CFRONT", Group: "SFRONT"},
+				{Type: utils.ActionPost, Channel: "CFRONT"},
+				{Type: utils.ActionDMReviewers},
+			},
+		},
+		{Name: "everything-else", Important: true, Actions: []utils.RuleAction{{Type: utils.ActionPost}}},
+	}, "C123", map[string]string{"alice": "UALICE", "bob": "UBOB"})
+
+	explanations, result := engine.Explain(newEvent(lifecycle.Opened, nil))
+	want := []rules.Notification{
+		{Destination: "CFRONT", Mention: "SFRONT"},
+		{Destination: "UALICE"},
+		{Destination: "UBOB"},
+	}
+	if result == nil || result.Rule != "frontend" || result.Important || !reflect.DeepEqual(result.Notifications, want) {
+		t.Fatalf("Explain = %+v, want frontend rule with %+v", result, want)
+	}
+	if len(explanations) != 2 || explanations[0].Matched || !strings.Contains(explanations[0].Reason, "docs") {
+		t.Errorf("explanations = %+v", explanations)
+	}
+
+	docs := newEvent(lifecycle.Opened, func(pr *pr_gh.PullRequest) { pr.Labels = []string{"docs", "frontend"} })
+	if result := engine.Evaluate(docs); result == nil || !result.Suppressed || len(result.Notifications) != 0 {
+		t.Errorf("Evaluate(docs) = %+v, want suppressed", result)
+	}
+
+	other := newEvent(lifecycle.Opened, func(pr *pr_gh.PullRequest) { pr.Labels = nil })
+	if result := engine.Evaluate(other); result == nil || result.Rule != "everything-else" || !result.Important {
+		t.Errorf("Evaluate(other) = %+v, want the important catch-all rule", result)
+	}
+}
+
+// Conditions on details, and the dm_reviewers action, against a pull request whose
+// details were scraped from its page rather than filled in by hand.
+func TestScrapedDetails(t *testing.T) {
+	page, err := os.Open("../github/testdata/pull_request.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Close()
+	doc, err := goquery.NewDocumentFromReader(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr := &pr_gh.PullRequest{PK: "org#api#7", Creator: "octocat", Repository: "api", Organization: "org",
+		URL: "https://github.com/org/api/pull/7", State: pr_gh.StateOpen, ReviewDecision: pr_gh.ReviewRequired}
+	pr_gh.ParsePullRequestPage(doc, pr)
+	event := &lifecycle.Event{Type: lifecycle.Opened, PK: pr.PK, Timestamp: time.Now(), Current: pr}
+
+	engine := rules.NewEngine([]utils.NotificationRule{{
+		Name:         "broken-main",
+		BaseBranches: []string{"main"},
+		MinSize:      1000,
+		CIStatuses:   []string{pr_gh.CIFailure},
+		Actions:      []utils.RuleAction{{Type: utils.ActionDMReviewers}},
+	}}, "C123", map[string]string{"alice": "UALICE", "bob": "UBOB"})
+
+	result := engine.Evaluate(event)
+	want := []rules.Notification{{Destination: "UALICE"}}
+	if result == nil || result.Rule != "broken-main" || !reflect.DeepEqual(result.Notifications, want) {
+		t.Fatalf("Evaluate = %+v, want a direct message to the pending reviewer %+v", result, want)
+	}
+}
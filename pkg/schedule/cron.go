@@ -0,0 +1,48 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Cron is a schedule given by a standard cron expression. (ex: "0 9 * * 1-5")
+type Cron struct {
+	schedule cron.Schedule
+	location *time.Location
+}
+
+// Parse a cron expression. Times in it are in the given timezone, or the local
+// timezone if it's empty.
+func ParseCron(expr string, timezone string) (*Cron, error) {
+	loc := time.Local
+	if timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return nil, err
+		}
+		expr = fmt.Sprintf("CRON_TZ=%s %s", timezone, expr)
+	}
+
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Cron{schedule: schedule, location: loc}, nil
+}
+
+// Get the timezone of the schedule.
+func (c *Cron) Location() *time.Location {
+	return c.location
+}
+
+// Get the first time the schedule fires after t.
+func (c *Cron) Next(t time.Time) time.Time {
+	return c.schedule.Next(t)
+}
+
+// Whether the schedule has fired since the last run.
+func (c *Cron) Due(last time.Time, now time.Time) bool {
+	return !c.Next(last).After(now)
+}
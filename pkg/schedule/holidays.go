@@ -0,0 +1,175 @@
+package schedule
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	UnknownHolidayFormatError error = errors.New("Unknown holiday file format, expected .ics, .yaml or .yml.")
+	InvalidHolidayDateError   error = errors.New("Invalid holiday date.")
+)
+
+// Layout of the dates holidays are keyed by.
+const DateLayout string = "2006-01-02"
+
+// Holidays are days without working hours, keyed by date (ex: "2022-12-25"), with
+// their names.
+type Holidays map[string]string
+
+// Whether the day containing t, in its own location, is a holiday.
+func (holidays Holidays) Contains(t time.Time) bool {
+	_, ok := holidays[t.Format(DateLayout)]
+	return ok
+}
+
+// Load holidays from ICS or YAML files, depending on their extension.
+func LoadHolidays(paths []string) (Holidays, error) {
+	holidays := make(Holidays)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ics":
+			err = parseICS(data, holidays)
+		case ".yaml", ".yml":
+			err = parseYAML(data, holidays)
+		default:
+			err = UnknownHolidayFormatError
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return holidays, nil
+}
+
+// Holiday files in YAML are a list of dates and names.
+//
+//   - date: 2022-12-25
+//     name: Christmas Day
+type yamlHoliday struct {
+	Date string `yaml:"date"`
+	Name string `yaml:"name"`
+}
+
+func parseYAML(data []byte, holidays Holidays) error {
+	var entries []yamlHoliday
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		date, err := time.Parse(DateLayout, entry.Date)
+		if err != nil {
+			return fmt.Errorf("%w (%s)", InvalidHolidayDateError, entry.Date)
+		}
+		holidays[date.Format(DateLayout)] = entry.Name
+	}
+	return nil
+}
+
+// Parse the events of an iCalendar file, such as one exported from a shared calendar.
+// Every day an event covers is a holiday.
+func parseICS(data []byte, holidays Holidays) error {
+	var inEvent bool
+	var start, end, summary string
+
+	for _, line := range unfoldICS(data) {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, _, _ = strings.Cut(name, ";") // drop parameters, such as VALUE=DATE
+
+		switch strings.ToUpper(name) {
+		case "BEGIN":
+			if value == "VEVENT" {
+				inEvent = true
+				start, end, summary = "", "", ""
+			}
+		case "DTSTART":
+			start = value
+		case "DTEND":
+			end = value
+		case "SUMMARY":
+			summary = value
+		case "END":
+			if value != "VEVENT" || !inEvent {
+				continue
+			}
+			inEvent = false
+			if err := addICSEvent(holidays, start, end, summary); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Lines of an iCalendar file can be folded, by continuing them on lines which start
+// with a space or tab.
+func unfoldICS(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Add the days covered by an event. All day events end on the day after they do, so
+// the end date is only included if the event ends partway through it.
+func addICSEvent(holidays Holidays, start string, end string, summary string) error {
+	first, err := parseICSDate(start)
+	if err != nil {
+		return err
+	}
+
+	last := first
+	if end != "" {
+		endDate, err := parseICSDate(end)
+		if err != nil {
+			return err
+		}
+		if len(end) > 8 && !strings.HasPrefix(end[8:], "T000000") {
+			endDate = endDate.AddDate(0, 0, 1)
+		}
+		if endDate.After(first) {
+			last = endDate.AddDate(0, 0, -1)
+		}
+	}
+
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		holidays[day.Format(DateLayout)] = summary
+	}
+	return nil
+}
+
+// Get the date of an iCalendar date or date-time. (ex: "20221225", "20221225T090000Z")
+func parseICSDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("%w (%s)", InvalidHolidayDateError, value)
+	}
+	date, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w (%s)", InvalidHolidayDateError, value)
+	}
+	return date, nil
+}
@@ -0,0 +1,56 @@
+package schedule
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadHolidays(t *testing.T) {
+	ics := writeFile(t, "holidays.ics", "BEGIN:VCALENDAR\r\n"+
+		"BEGIN:VEVENT\r\n"+
+		"DTSTART;VALUE=DATE:20221225\r\n"+
+		"DTEND;VALUE=DATE:20221227\r\n"+
+		"SUMMARY:Christmas \r\n"+
+		" break\r\n"+
+		"END:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\n"+
+		"DTSTART:20221124T090000Z\r\n"+
+		"DTEND:20221124T170000Z\r\n"+
+		"SUMMARY:Thanksgiving\r\n"+
+		"END:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+	yml := writeFile(t, "holidays.yaml", "- date: 2022-07-04\n  name: Independence Day\n")
+
+	holidays, err := LoadHolidays([]string{ics, yml})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Holidays{
+		"2022-12-25": "Christmas break",
+		"2022-12-26": "Christmas break",
+		"2022-11-24": "Thanksgiving",
+		"2022-07-04": "Independence Day",
+	}
+	if !reflect.DeepEqual(holidays, want) {
+		t.Errorf("LoadHolidays() = %v, want %v", holidays, want)
+	}
+
+	if _, err := LoadHolidays([]string{writeFile(t, "holidays.txt", "")}); !errors.Is(err, UnknownHolidayFormatError) {
+		t.Errorf("LoadHolidays() error = %v, want %v", err, UnknownHolidayFormatError)
+	}
+	if _, err := LoadHolidays([]string{writeFile(t, "bad.yml", "- date: July 4th\n")}); !errors.Is(err, InvalidHolidayDateError) {
+		t.Errorf("LoadHolidays() error = %v, want %v", err, InvalidHolidayDateError)
+	}
+}
@@ -0,0 +1,163 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+var (
+	InvalidTimeOfDayError error = errors.New("Invalid time of day, expected HH:MM.")
+	InvalidWeekdayError   error = errors.New("Invalid day of the week.")
+	EmptyHoursError       error = errors.New("Business hours must end after they start.")
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Hours are the working hours of each working day, in a timezone. Holidays aren't
+// working days.
+type Hours struct {
+	Location *time.Location
+	Start    time.Duration // since midnight
+	End      time.Duration // since midnight
+	Days     map[time.Weekday]bool
+	Holidays Holidays
+}
+
+// How far ahead to look for the next working window, in days.
+const maxDaysAhead int = 366
+
+// Parse business hours from config, filling in defaults for anything which isn't set,
+// and loading the holiday files it lists.
+func ParseHours(cfg utils.BusinessHours) (*Hours, error) {
+	hours := &Hours{Location: time.Local, Days: make(map[time.Weekday]bool)}
+
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		hours.Location = loc
+	}
+
+	var err error
+	if hours.Start, err = parseTimeOfDay(cfg.Start, "09:00"); err != nil {
+		return nil, err
+	}
+	if hours.End, err = parseTimeOfDay(cfg.End, "17:00"); err != nil {
+		return nil, err
+	}
+	if hours.End <= hours.Start {
+		return nil, EmptyHoursError
+	}
+
+	days := cfg.Days
+	if len(days) == 0 {
+		days = []string{"mon", "tue", "wed", "thu", "fri"}
+	}
+	for _, day := range days {
+		weekday, ok := weekdays[strings.ToLower(day)[:min(3, len(day))]]
+		if !ok {
+			return nil, fmt.Errorf("%w (%s)", InvalidWeekdayError, day)
+		}
+		hours.Days[weekday] = true
+	}
+
+	holidays, err := LoadHolidays(cfg.Holidays)
+	if err != nil {
+		return nil, err
+	}
+	hours.Holidays = holidays
+
+	return hours, nil
+}
+
+func parseTimeOfDay(value string, fallback string) (time.Duration, error) {
+	if value == "" {
+		value = fallback
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%w (%s)", InvalidTimeOfDayError, value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Whether a day is a working day.
+func (hours *Hours) isWorkingDay(day time.Time) bool {
+	return hours.Days[day.Weekday()] && !hours.Holidays.Contains(day)
+}
+
+// Get the working window of the day containing t, in the hours' timezone.
+func (hours *Hours) window(t time.Time) (time.Time, time.Time) {
+	t = t.In(hours.Location)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, hours.Location)
+	return midnight.Add(hours.Start), midnight.Add(hours.End)
+}
+
+// How much working time there is between two times.
+func (hours *Hours) Between(from time.Time, to time.Time) time.Duration {
+	var total time.Duration
+	for day := from; day.Before(to); day = nextDay(day, hours.Location) {
+		if !hours.isWorkingDay(day.In(hours.Location)) {
+			continue
+		}
+
+		start, end := hours.window(day)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			total += end.Sub(start)
+		}
+	}
+	return total
+}
+
+// Whether t is within working hours.
+func (hours *Hours) Contains(t time.Time) bool {
+	if !hours.isWorkingDay(t.In(hours.Location)) {
+		return false
+	}
+	start, end := hours.window(t)
+	return !t.Before(start) && t.Before(end)
+}
+
+// Get the start of the next working window, or t if it's within working hours.
+// Returns false if there are no working days within the next year.
+func (hours *Hours) Next(t time.Time) (time.Time, bool) {
+	if hours.Contains(t) {
+		return t, true
+	}
+
+	day := t
+	for i := 0; i <= maxDaysAhead; i++ {
+		if hours.isWorkingDay(day.In(hours.Location)) {
+			if start, _ := hours.window(day); !start.Before(t) {
+				return start, true
+			}
+		}
+		day = nextDay(day, hours.Location)
+	}
+	return time.Time{}, false
+}
+
+// Midnight at the start of the day after t, in loc.
+func nextDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+}
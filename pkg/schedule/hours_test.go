@@ -0,0 +1,104 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+func TestParseHours(t *testing.T) {
+	hours, err := ParseHours(utils.BusinessHours{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hours.Start != 9*time.Hour || hours.End != 17*time.Hour || len(hours.Days) != 5 || hours.Days[time.Saturday] {
+		t.Errorf("defaults = %+v, want 09:00 to 17:00, Monday to Friday", hours)
+	}
+
+	tests := []struct {
+		name string
+		cfg  utils.BusinessHours
+		want error
+	}{
+		{"InvalidStart", utils.BusinessHours{Start: "9am"}, InvalidTimeOfDayError},
+		{"EndBeforeStart", utils.BusinessHours{Start: "17:00", End: "09:00"}, EmptyHoursError},
+		{"InvalidDay", utils.BusinessHours{Days: []string{"mon", "someday"}}, InvalidWeekdayError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseHours(tt.cfg); !errors.Is(err, tt.want) {
+				t.Errorf("ParseHours() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBetween(t *testing.T) {
+	hours, err := ParseHours(utils.BusinessHours{Timezone: "America/New_York"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ny := hours.Location
+
+	// Friday, July 1st 2022.
+	friday := func(hour, min int) time.Time { return time.Date(2022, 7, 1, hour, min, 0, 0, ny) }
+	monday := func(hour, min int) time.Time { return time.Date(2022, 7, 4, hour, min, 0, 0, ny) }
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     time.Duration
+	}{
+		{"WithinDay", friday(10, 0), friday(12, 30), 150 * time.Minute},
+		{"BeforeHours", friday(6, 0), friday(10, 0), time.Hour},
+		{"AfterHours", friday(16, 0), friday(23, 0), time.Hour},
+		{"OverWeekend", friday(15, 0), monday(11, 0), 4 * time.Hour},
+		{"StartsOnWeekend", time.Date(2022, 7, 2, 12, 0, 0, 0, ny), monday(9, 30), 30 * time.Minute},
+		{"OtherTimezone", friday(15, 0).UTC(), friday(16, 0).UTC(), time.Hour},
+		{"Backwards", friday(12, 0), friday(10, 0), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hours.Between(tt.from, tt.to); got != tt.want {
+				t.Errorf("Between() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if !hours.Contains(friday(9, 0)) || hours.Contains(friday(17, 0)) || hours.Contains(time.Date(2022, 7, 2, 12, 0, 0, 0, ny)) {
+		t.Errorf("Contains() doesn't match working hours")
+	}
+}
+
+func TestNext(t *testing.T) {
+	hours, err := ParseHours(utils.BusinessHours{Timezone: "America/New_York"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ny := hours.Location
+	hours.Holidays = Holidays{"2022-07-04": "Independence Day"}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want time.Time
+	}{
+		{"WithinHours", time.Date(2022, 7, 1, 10, 0, 0, 0, ny), time.Date(2022, 7, 1, 10, 0, 0, 0, ny)},
+		{"EarlyMorning", time.Date(2022, 7, 1, 3, 0, 0, 0, ny), time.Date(2022, 7, 1, 9, 0, 0, 0, ny)},
+		{"OverWeekendAndHoliday", time.Date(2022, 7, 1, 18, 0, 0, 0, ny), time.Date(2022, 7, 5, 9, 0, 0, 0, ny)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := hours.Next(tt.at)
+			if !ok || !got.Equal(tt.want) {
+				t.Errorf("Next() = %s, %t, want %s", got, ok, tt.want)
+			}
+		})
+	}
+
+	if hours.Between(time.Date(2022, 7, 1, 16, 0, 0, 0, ny), time.Date(2022, 7, 5, 10, 0, 0, 0, ny)) != 2*time.Hour {
+		t.Errorf("Between() counted a holiday")
+	}
+}
@@ -0,0 +1,79 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+// Prefix of the keys the last run of each job is stored under.
+const lastRunKeyPrefix string = "job#"
+
+// JobState stores when each job last ran, so a restart, or another replica taking
+// over, doesn't run jobs again. The database store's metadata table implements it.
+type JobState interface {
+	GetMeta(key string) (string, error)
+	PutMeta(key string, value string) error
+}
+
+// Job runs whenever its schedule fires.
+type Job struct {
+	Name     string
+	Schedule *Cron
+	Run      func(now time.Time) error
+}
+
+type Scheduler struct {
+	Jobs  []*Job
+	State JobState
+}
+
+func NewScheduler(state JobState) *Scheduler {
+	return &Scheduler{State: state}
+}
+
+func (s *Scheduler) Add(name string, schedule *Cron, run func(now time.Time) error) {
+	s.Jobs = append(s.Jobs, &Job{Name: name, Schedule: schedule, Run: run})
+}
+
+// Run each job whose schedule has fired since it last ran. Jobs which haven't run
+// before only start counting from now. A job which fails is tried again next time.
+// Returns the names of the jobs which ran successfully.
+func (s *Scheduler) RunDue(now time.Time) []string {
+	var ran []string
+	for _, job := range s.Jobs {
+		last, ok := s.lastRun(job.Name)
+		if !ok {
+			s.setLastRun(job.Name, now)
+			continue
+		}
+		if !job.Schedule.Due(last, now) {
+			continue
+		}
+
+		if err := job.Run(now); err != nil {
+			fmt.Printf("Failed to run %s: %s\n", job.Name, err)
+			continue
+		}
+		s.setLastRun(job.Name, now)
+		ran = append(ran, job.Name)
+	}
+	return ran
+}
+
+func (s *Scheduler) lastRun(name string) (time.Time, bool) {
+	value, err := s.State.GetMeta(lastRunKeyPrefix + name)
+	if err != nil {
+		return time.Time{}, false
+	}
+	last, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return last, true
+}
+
+func (s *Scheduler) setLastRun(name string, t time.Time) {
+	if err := s.State.PutMeta(lastRunKeyPrefix+name, t.UTC().Format(time.RFC3339)); err != nil {
+		fmt.Printf("Failed to record run of %s: %s\n", name, err)
+	}
+}
@@ -0,0 +1,80 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type memoryState map[string]string
+
+func (ms memoryState) GetMeta(key string) (string, error) {
+	value, ok := ms[key]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return value, nil
+}
+
+func (ms memoryState) PutMeta(key string, value string) error {
+	ms[key] = value
+	return nil
+}
+
+func TestScheduler(t *testing.T) {
+	cron, err := ParseCron("0 9 * * 1-5", "America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ny, _ := time.LoadLocation("America/New_York")
+
+	state := memoryState{}
+	var runs int
+	fail := false
+	newScheduler := func() *Scheduler {
+		scheduler := NewScheduler(state)
+		scheduler.Add("digest", cron, func(now time.Time) error {
+			if fail {
+				return errors.New("slack is down")
+			}
+			runs++
+			return nil
+		})
+		return scheduler
+	}
+	scheduler := newScheduler()
+
+	// Jobs which haven't run before don't catch up on the past.
+	friday := time.Date(2022, 7, 1, 8, 0, 0, 0, ny)
+	if ran := scheduler.RunDue(friday); len(ran) != 0 {
+		t.Fatalf("first RunDue() ran %v", ran)
+	}
+	if scheduler.RunDue(friday.Add(59 * time.Minute)); runs != 0 {
+		t.Fatalf("ran before 09:00")
+	}
+	if scheduler.RunDue(friday.Add(time.Hour)); runs != 1 {
+		t.Fatalf("didn't run at 09:00")
+	}
+
+	// A restart doesn't run it again the same day, or over the weekend.
+	scheduler = newScheduler()
+	if scheduler.RunDue(friday.Add(72 * time.Hour)); runs != 1 {
+		t.Fatalf("ran again before Monday")
+	}
+
+	// Failures are retried.
+	monday := time.Date(2022, 7, 4, 9, 0, 0, 0, ny)
+	fail = true
+	scheduler.RunDue(monday)
+	fail = false
+	if scheduler.RunDue(monday.Add(time.Minute)); runs != 2 {
+		t.Fatalf("failed run wasn't retried")
+	}
+	if scheduler.RunDue(monday.Add(2 * time.Minute)); runs != 2 {
+		t.Fatalf("ran twice on Monday")
+	}
+
+	if _, err := ParseCron("every morning", ""); err == nil {
+		t.Errorf("ParseCron() accepted an invalid expression")
+	}
+}
@@ -0,0 +1,35 @@
+package schedule
+
+import (
+	"fmt"
+
+	"github.com/ooojustin/pr-puller/pkg/utils"
+)
+
+// Key of the schedule which applies to destinations without their own.
+const DefaultKey string = "default"
+
+// Schedules are the hours notifications can be sent to each destination, keyed by
+// Slack channel or user ID.
+type Schedules map[string]*Hours
+
+// Parse the notification schedules from config.
+func ParseSchedules(cfg map[string]utils.BusinessHours) (Schedules, error) {
+	schedules := make(Schedules, len(cfg))
+	for destination, hoursCfg := range cfg {
+		hours, err := ParseHours(hoursCfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", destination, err)
+		}
+		schedules[destination] = hours
+	}
+	return schedules, nil
+}
+
+// Get the schedule of a destination, or nil if notifications can always be sent to it.
+func (schedules Schedules) For(destination string) *Hours {
+	if hours, ok := schedules[destination]; ok {
+		return hours
+	}
+	return schedules[DefaultKey]
+}
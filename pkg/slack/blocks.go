@@ -0,0 +1,255 @@
+package slack
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+	slack_go "github.com/slack-go/slack"
+)
+
+// Layouts of the message sent about a pull request.
+const (
+	LayoutFull    string = "full"    // every detail which is known, with the author's avatar
+	LayoutCompact string = "compact" // the title and a single line of details
+	LayoutLegacy  string = "legacy"  // a plain attachment with the title, link and author
+)
+
+// Key of the layout used for events which aren't listed.
+const DefaultLayoutKey string = "default"
+
+var (
+	UnknownLayoutError error = errors.New("Unknown message layout.")
+)
+
+// Layouts used for events which config doesn't set a layout for. Events which ask
+// someone to review get every detail, and the rest are compact.
+var defaultLayouts = map[string]string{
+	lifecycle.Opened:         LayoutFull,
+	lifecycle.ReadyForReview: LayoutFull,
+	lifecycle.ReviewRequired: LayoutFull,
+	lifecycle.Reminded:       LayoutFull,
+	lifecycle.Escalated:      LayoutFull,
+}
+
+// Colours of the bar beside a message, for each state of a pull request.
+const (
+	colorOpen             string = "#0969da"
+	colorDraft            string = "#6e7781"
+	colorApproved         string = "#2da44e"
+	colorChangesRequested string = "#d29922"
+	colorMerged           string = "#8250df"
+	colorClosed           string = "#cf222e"
+)
+
+// Layouts maps lifecycle event types, or DefaultLayoutKey, to a message layout.
+type Layouts map[string]string
+
+// Parse the message layouts from config, making sure each one exists.
+func ParseLayouts(cfg map[string]string) (Layouts, error) {
+	layouts := make(Layouts, len(cfg))
+	for event, layout := range cfg {
+		switch layout {
+		case LayoutFull, LayoutCompact, LayoutLegacy:
+			layouts[event] = layout
+		default:
+			return nil, fmt.Errorf("%s: %w", event, UnknownLayoutError)
+		}
+	}
+	return layouts, nil
+}
+
+// Get the layout of messages about an event.
+func (layouts Layouts) For(event string) string {
+	if layout, ok := layouts[event]; ok {
+		return layout
+	}
+	if layout, ok := layouts[DefaultLayoutKey]; ok {
+		return layout
+	}
+	if layout, ok := defaultLayouts[event]; ok {
+		return layout
+	}
+	return LayoutCompact
+}
+
+// Build the attachment which describes a pull request in a message. Block Kit layouts
+// are sent inside an attachment, so the bar beside them can be coloured by state.
+func PullRequestAttachment(pr *pr_gh.PullRequest, layout string, now time.Time) *slack_go.Attachment {
+	if layout == LayoutLegacy {
+		return &slack_go.Attachment{
+			Title:      pr.Title,
+			TitleLink:  pr.URL,
+			AuthorName: pr.Creator,
+		}
+	}
+
+	var blocks []slack_go.Block
+	if layout == LayoutFull {
+		blocks = fullBlocks(pr, now)
+	} else {
+		blocks = compactBlocks(pr, now)
+	}
+	blocks = append(blocks, footerBlock(pr))
+
+	return &slack_go.Attachment{
+		Color:    statusColor(pr),
+		Fallback: fmt.Sprintf("%s #%d %s by %s", repositoryName(pr), pr.Number, pr.Title, pr.Creator),
+		Blocks:   slack_go.Blocks{BlockSet: blocks},
+	}
+}
+
+// Every detail which is known: the title with the author's avatar, then labels, age,
+// size and CI status, then its state and requested reviewers.
+func fullBlocks(pr *pr_gh.PullRequest, now time.Time) []slack_go.Block {
+	avatar := slack_go.NewAccessory(slack_go.NewImageBlockElement(AvatarURL(pr.Creator), pr.Creator))
+	blocks := []slack_go.Block{slack_go.NewSectionBlock(markdown(titleText(pr)), nil, avatar)}
+
+	var details []*slack_go.TextBlockObject
+	if len(pr.Labels) > 0 {
+		details = append(details, field("Labels", escaper.Replace(strings.Join(pr.Labels, ", "))))
+	}
+	details = append(details, field("Age", FormatAge(now.Sub(pr.Created))))
+	if size, ok := sizeText(pr); ok {
+		details = append(details, field("Size", size))
+	}
+	if ci, ok := ciText(pr); ok {
+		details = append(details, field("CI", ci))
+	}
+	blocks = append(blocks, slack_go.NewSectionBlock(nil, details, nil))
+
+	status := []*slack_go.TextBlockObject{field("Status", statusText(pr))}
+	if detailsLoaded(pr) && len(pr.RequestedReviewers) > 0 {
+		status = append(status, field("Reviewers", escaper.Replace(strings.Join(pr.RequestedReviewers, ", "))))
+	}
+	return append(blocks, slack_go.NewSectionBlock(nil, status, nil))
+}
+
+// The title, followed by a line of details like a digest's.
+// (ex: "3d old, +120 −4 in 5 files, CI passing, Review required")
+func compactBlocks(pr *pr_gh.PullRequest, now time.Time) []slack_go.Block {
+	details := []string{fmt.Sprintf("%s old", FormatAge(now.Sub(pr.Created)))}
+	if size, ok := sizeText(pr); ok {
+		details = append(details, size)
+	}
+	if state, ok := ciStates[pr.CIStatus]; ok && detailsLoaded(pr) {
+		details = append(details, fmt.Sprintf("CI %s", state))
+	}
+	details = append(details, statusText(pr))
+
+	text := fmt.Sprintf("%s\n%s", titleText(pr), strings.Join(details, ", "))
+	return []slack_go.Block{slack_go.NewSectionBlock(markdown(text), nil, nil)}
+}
+
+// Who opened the pull request and when, along with the branch it targets if known.
+// Dates are formatted by Slack, in the timezone of whoever reads them.
+func footerBlock(pr *pr_gh.PullRequest) slack_go.Block {
+	text := fmt.Sprintf("Opened by %s <!date^%d^{date_short_pretty} at {time}|%s>",
+		escaper.Replace(pr.Creator), pr.Created.Unix(), pr.Created.UTC().Format(time.RFC1123))
+	if detailsLoaded(pr) && pr.BaseBranch != "" {
+		text += fmt.Sprintf(" into `%s`", escaper.Replace(pr.BaseBranch))
+	}
+	return slack_go.NewContextBlock("",
+		slack_go.NewImageBlockElement(AvatarURL(pr.Creator), pr.Creator),
+		markdown(text),
+	)
+}
+
+// Get the URL of a Github user's avatar.
+func AvatarURL(login string) string {
+	return fmt.Sprintf("https://github.com/%s.png?size=64", url.PathEscape(login))
+}
+
+// Link to the pull request, above its repository and number. The title is struck
+// through once it's closed without being merged. (ex: "*<url|Fix login>*\norg/api #12")
+func titleText(pr *pr_gh.PullRequest) string {
+	title := fmt.Sprintf("*<%s|%s>*", pr.URL, escaper.Replace(pr.Title))
+	if pr.State == pr_gh.StateClosed {
+		title = fmt.Sprintf("~%s~", title)
+	}
+	return fmt.Sprintf("%s\n%s #%d", title, escaper.Replace(repositoryName(pr)), pr.Number)
+}
+
+func repositoryName(pr *pr_gh.PullRequest) string {
+	if pr.Organization == "" {
+		return pr.Repository
+	}
+	return pr.Organization + "/" + pr.Repository
+}
+
+// Whether the details of a pull request which aren't shown in search results, such as
+// its size, CI status and requested reviewers, were loaded from its page. Until they
+// are, they're left out of messages.
+func detailsLoaded(pr *pr_gh.PullRequest) bool {
+	return !pr.DetailsLoaded.IsZero()
+}
+
+// Describe the size of a pull request, if it's known. (ex: "+120 −4 in 5 files")
+func sizeText(pr *pr_gh.PullRequest) (string, bool) {
+	if _, ok := pr.Size(); !ok || !detailsLoaded(pr) {
+		return "", false
+	}
+	return fmt.Sprintf("+%d −%d in %d files", pr.Additions, pr.Deletions, pr.ChangedFiles), true
+}
+
+var ciStates = map[string]string{
+	pr_gh.CISuccess: "passing",
+	pr_gh.CIFailure: "failing",
+	pr_gh.CIPending: "pending",
+}
+
+var ciSummaries = map[string]string{
+	pr_gh.CISuccess: ":white_check_mark: Passing",
+	pr_gh.CIFailure: ":x: Failing",
+	pr_gh.CIPending: ":hourglass_flowing_sand: Pending",
+}
+
+// Describe the CI status of a pull request, if it's known. (ex: ":x: Failing")
+func ciText(pr *pr_gh.PullRequest) (string, bool) {
+	summary, ok := ciSummaries[pr.CIStatus]
+	return summary, ok && detailsLoaded(pr)
+}
+
+// Describe the state of a pull request, which is its review decision while it's open.
+func statusText(pr *pr_gh.PullRequest) string {
+	switch {
+	case pr.State == pr_gh.StateMerged:
+		return "Merged"
+	case pr.State == pr_gh.StateClosed:
+		return "Closed"
+	case pr.Draft:
+		return "Draft"
+	case pr.ReviewDecision == "":
+		return "No review required"
+	}
+	return pr.ReviewDecision
+}
+
+// Get the colour of the bar beside a message about a pull request.
+func statusColor(pr *pr_gh.PullRequest) string {
+	switch {
+	case pr.State == pr_gh.StateMerged:
+		return colorMerged
+	case pr.State == pr_gh.StateClosed:
+		return colorClosed
+	case pr.Draft:
+		return colorDraft
+	case pr.ReviewDecision == pr_gh.ReviewApproved:
+		return colorApproved
+	case pr.ReviewDecision == pr_gh.ReviewChangesRequested:
+		return colorChangesRequested
+	}
+	return colorOpen
+}
+
+func markdown(text string) *slack_go.TextBlockObject {
+	return slack_go.NewTextBlockObject(slack_go.MarkdownType, text, false, false)
+}
+
+func field(name string, value string) *slack_go.TextBlockObject {
+	return markdown(fmt.Sprintf("*%s*\n%s", name, value))
+}
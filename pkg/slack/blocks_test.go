@@ -0,0 +1,130 @@
+package slack
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+)
+
+func TestLayouts(t *testing.T) {
+	if _, err := ParseLayouts(map[string]string{lifecycle.Merged: "fancy"}); !errors.Is(err, UnknownLayoutError) {
+		t.Fatalf("ParseLayouts(fancy) = %v, want UnknownLayoutError", err)
+	}
+
+	layouts, err := ParseLayouts(map[string]string{lifecycle.Merged: LayoutLegacy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for event, want := range map[string]string{
+		lifecycle.Opened:   LayoutFull,
+		lifecycle.Approved: LayoutCompact,
+		lifecycle.Merged:   LayoutLegacy,
+	} {
+		if got := layouts.For(event); got != want {
+			t.Errorf("For(%s) = %s, want %s", event, got, want)
+		}
+	}
+
+	layouts[DefaultLayoutKey] = LayoutLegacy
+	if got := layouts.For(lifecycle.Opened); got != LayoutLegacy {
+		t.Errorf("For(opened) = %s, want the default", got)
+	}
+}
+
+func TestPullRequestAttachment(t *testing.T) {
+	now := time.Date(2022, 7, 4, 9, 0, 0, 0, time.UTC)
+	pr := &pr_gh.PullRequest{
+		Organization: "org", Repository: "api", Number: 7, Title: "Fix <login>", URL: "https://github.com/org/api/pull/7",
+		Creator: "octocat", Created: now.Add(-72 * time.Hour), Labels: []string{"bug", "ui"},
+		ReviewDecision: pr_gh.ReviewApproved,
+	}
+
+	// Until the page is loaded, only what search results show is described.
+	search, err := json.Marshal(PullRequestAttachment(pr, LayoutFull, now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, missing := range []string{"*Size*", "*CI*", "*Reviewers*", "into `"} {
+		if strings.Contains(string(search), missing) {
+			t.Errorf("full layout without details has %s:\n%s", missing, search)
+		}
+	}
+
+	file, err := os.Open("../github/testdata/pull_request.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	doc, err := goquery.NewDocumentFromReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr_gh.ParsePullRequestPage(doc, pr)
+	pr.DetailsLoaded = now
+
+	render := func(layout string) string {
+		body, err := json.Marshal(PullRequestAttachment(pr, layout, now))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	full := render(LayoutFull)
+	for _, want := range []string{
+		`"color":"#2da44e"`,
+		`*\u003chttps://github.com/org/api/pull/7|Fix \u0026lt;login\u0026gt;\u003e*\norg/api #7`,
+		`https://github.com/octocat.png?size=64`,
+		`*Labels*\nbug, ui`,
+		`*Age*\n3d`,
+		`*Size*\n+1204 −4 in 5 files`,
+		`*CI*\n:x: Failing`,
+		`*Status*\nApproved`,
+		`*Reviewers*\nalice, org/backend`,
+		`"type":"context"`,
+		"into `main`",
+	} {
+		if !strings.Contains(full, want) {
+			t.Errorf("full layout is missing %s:\n%s", want, full)
+		}
+	}
+
+	if compact := render(LayoutCompact); !strings.Contains(compact, `3d old, +1204 −4 in 5 files, CI failing, Approved`) {
+		t.Errorf("compact layout is missing details:\n%s", compact)
+	}
+
+	legacy := PullRequestAttachment(pr, LayoutLegacy, now)
+	if legacy.Title != pr.Title || legacy.TitleLink != pr.URL || legacy.AuthorName != pr.Creator || len(legacy.Blocks.BlockSet) != 0 {
+		t.Errorf("legacy layout = %+v", legacy)
+	}
+}
+
+func TestClosedPullRequest(t *testing.T) {
+	pr := &pr_gh.PullRequest{Repository: "api", Number: 7, Title: "Fix login", URL: "https://github.com/org/api/pull/7",
+		State: pr_gh.StateClosed, ReviewDecision: pr_gh.ReviewApproved}
+
+	if got := titleText(pr); got != "~*<https://github.com/org/api/pull/7|Fix login>*~\napi #7" {
+		t.Errorf("titleText() = %q, want it struck through", got)
+	}
+	if got := statusText(pr); got != "Closed" {
+		t.Errorf("statusText() = %q, want Closed", got)
+	}
+	if got := statusColor(pr); got != colorClosed {
+		t.Errorf("statusColor() = %q, want %q", got, colorClosed)
+	}
+	if got := statusMessage(pr); got != "~A pull request was closed.~" {
+		t.Errorf("statusMessage() = %q", got)
+	}
+
+	pr.State = pr_gh.StateMerged
+	if got := statusMessage(pr); got != "A pull request was merged." || statusColor(pr) != colorMerged {
+		t.Errorf("merged pull request = %q, %s", got, statusColor(pr))
+	}
+}
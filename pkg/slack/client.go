@@ -1,7 +1,13 @@
 package slack
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
 	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
 	"github.com/ooojustin/pr-puller/pkg/utils"
 	slack_go "github.com/slack-go/slack"
 )
@@ -9,18 +15,51 @@ import (
 type Slack struct {
 	Client    *slack_go.Client
 	ChannelID string
+
+	// Layout of the message sent for each type of lifecycle event.
+	Layouts Layouts
+
+	// What happens to the message announcing a pull request once it's closed without
+	// being merged: ClosedStrike (default) or ClosedDelete.
+	ClosedMessage string
 }
 
+// What happens to the message announcing a pull request which is closed without being
+// merged.
+const (
+	ClosedStrike string = "strike" // strike through its title
+	ClosedDelete string = "delete" // delete it
+)
+
+var (
+	UnknownClosedMessageError error = errors.New("Unknown closed message action.")
+)
+
 func Initialize() (*Slack, bool) {
 	cfg, ok := utils.GetConfig()
 	if !ok {
 		return nil, false
 	}
 
+	layouts, err := ParseLayouts(cfg.MessageLayouts)
+	if err != nil {
+		fmt.Println("Failed to parse message layouts:", err)
+		return nil, false
+	}
+
+	switch cfg.ClosedMessage {
+	case "", ClosedStrike, ClosedDelete:
+	default:
+		fmt.Println("Failed to parse closed message action:", UnknownClosedMessageError)
+		return nil, false
+	}
+
 	client := slack_go.New(cfg.SlackOauthToken)
 	slack := &Slack{
-		Client:    client,
-		ChannelID: cfg.SlackChannelID,
+		Client:        client,
+		ChannelID:     cfg.SlackChannelID,
+		Layouts:       layouts,
+		ClosedMessage: cfg.ClosedMessage,
 	}
 
 	return slack, true
@@ -30,37 +69,234 @@ func (slack *Slack) SendMessage(
 	msg string,
 	attachment *slack_go.Attachment,
 ) error {
+	_, _, err := slack.PostMessage(slack.ChannelID, msg, attachment)
+	return err
+}
+
+// Send a message to a channel, returning the channel it was posted in and its
+// timestamp, which Slack uses to identify the message.
+func (slack *Slack) PostMessage(
+	channelID string,
+	msg string,
+	attachment *slack_go.Attachment,
+	extra ...slack_go.MsgOption,
+) (string, string, error) {
 	options := []slack_go.MsgOption{
 		slack_go.MsgOptionText(msg, false),
 		slack_go.MsgOptionAsUser(true),
 	}
+	options = append(options, extra...)
 
 	if attachment != nil {
 		attatchmentOption := slack_go.MsgOptionAttachments(*attachment)
 		options = append(options, attatchmentOption)
 	}
 
-	_, _, err := slack.Client.PostMessage(slack.ChannelID, options...)
-	return err
+	return slack.Client.PostMessage(channelID, options...)
+}
+
+// Notification describes a message to send about a pull request.
+type Notification struct {
+	Destination    string        // channel or user ID
+	Event          string        // lifecycle event which caused it
+	Mention        string        // user group ID to mention, if any
+	Users          []string      // user IDs to mention, if any
+	ThreadTS       string        // message to reply to, if any
+	Broadcast      bool          // whether a reply is also shown in the channel
+	Review         *pr_gh.Review // review it's about, if any
+	IdempotencyKey string
+	Since          time.Time // when it was queued
+}
+
+// Text of the message sent for each type of lifecycle event.
+var eventMessages = map[string]string{
+	lifecycle.Opened:           "A pull request is ready to be reviewed.",
+	lifecycle.ReadyForReview:   "A pull request is ready to be reviewed.",
+	lifecycle.ReviewRequired:   "A pull request is ready to be reviewed.",
+	lifecycle.ConvertedToDraft: "A pull request was converted to a draft.",
+	lifecycle.ChangesRequested: "Changes were requested on a pull request.",
+	lifecycle.Approved:         "A pull request was approved.",
+	lifecycle.Reviewed:         "A pull request was reviewed.",
+	lifecycle.LabelsChanged:    "The labels of a pull request changed.",
+	lifecycle.TitleChanged:     "A pull request was renamed.",
+	lifecycle.NewCommits:       "New commits were pushed to a pull request.",
+	lifecycle.CIFailed:         "Checks failed on a pull request.",
+	lifecycle.Merged:           "A pull request was merged.",
+	lifecycle.Closed:           "A pull request was closed.",
+	lifecycle.Reminded:         "This pull request is still waiting for review.",
+	lifecycle.Escalated:        "A pull request has been waiting for review longer than expected.",
 }
 
-func (slack *Slack) SendPullRequestMessage(pr *pr_gh.PullRequest) error {
-	attachment := &slack_go.Attachment{
-		Title:      pr.Title,
-		TitleLink:  pr.URL,
-		AuthorName: pr.Creator,
+// Announce a pull request, unless a message with the same idempotency key has been
+// posted in the destination since the notification was queued, in which case that
+// message is returned. The pull request is described in the layout set for the
+// event. Notifications for a user are sent as a direct message, and
+// notifications with a ThreadTS are posted as a reply in that thread, which is also
+// shown in the channel if Broadcast is set.
+func (slack *Slack) SendPullRequestMessage(
+	notification *Notification,
+	pr *pr_gh.PullRequest,
+) (string, string, error) {
+	channelID, err := slack.resolveDestination(notification.Destination)
+	if err != nil {
+		return "", "", err
 	}
 
-	err := slack.SendMessage(
-		"A pull request is ready to be reviewed.",
-		attachment,
-	)
+	ts, found, err := slack.FindMessage(channelID, notification.ThreadTS, notification.IdempotencyKey, notification.Since)
+	if err != nil {
+		return "", "", err
+	} else if found {
+		return channelID, ts, nil
+	}
 
-	return err
+	msg := notificationText(notification)
+	attachment := PullRequestAttachment(pr, slack.Layouts.For(notification.Event), time.Now())
+
+	options := []slack_go.MsgOption{idempotencyMetadata(notification.IdempotencyKey, pr.PK)}
+	if notification.ThreadTS != "" {
+		options = append(options, slack_go.MsgOptionTS(notification.ThreadTS))
+		if notification.Broadcast {
+			options = append(options, slack_go.MsgOptionBroadcast())
+		}
+	}
+
+	return slack.PostMessage(channelID, msg, attachment, options...)
+}
+
+// Text of the message sent for a notification, after any mentions.
+func notificationText(notification *Notification) string {
+	msg, ok := eventMessages[notification.Event]
+	if !ok {
+		msg = eventMessages[lifecycle.Opened]
+	}
+	if notification.Review != nil {
+		msg = reviewMessage(notification.Review, isUser(notification.Destination))
+	}
+	for idx := len(notification.Users) - 1; idx >= 0; idx-- {
+		msg = fmt.Sprintf("<@%s> %s", notification.Users[idx], msg)
+	}
+	if notification.Mention != "" {
+		msg = fmt.Sprintf("<!subteam^%s> %s", notification.Mention, msg)
+	}
+	return msg
+}
+
+// Send several notifications to one destination as a single message, such as those
+// held during quiet hours, with a line for each. prs holds the pull request each
+// notification is about. The batch is identified by the idempotency key of its first
+// notification, so it's only posted once, like SendPullRequestMessage.
+func (slack *Slack) SendNotificationBatch(
+	destination string,
+	notifications []*Notification,
+	prs []*pr_gh.PullRequest,
+) (string, string, error) {
+	channelID, err := slack.resolveDestination(destination)
+	if err != nil {
+		return "", "", err
+	}
+
+	first := notifications[0]
+	ts, found, err := slack.FindMessage(channelID, "", first.IdempotencyKey, first.Since)
+	if err != nil {
+		return "", "", err
+	} else if found {
+		return channelID, ts, nil
+	}
+
+	return slack.PostMessage(channelID, FormatBatch(notifications, prs), nil,
+		idempotencyMetadata(first.IdempotencyKey, prs[0].PK))
+}
+
+// Format notifications which were sent together, one line per pull request.
+// (ex: "• <url|org/api #12 Fix login>: A pull request was approved.")
+func FormatBatch(notifications []*Notification, prs []*pr_gh.PullRequest) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d notifications were held during quiet hours*\n", len(notifications))
+	for idx, notification := range notifications {
+		pr := prs[idx]
+		title := escaper.Replace(fmt.Sprintf("%s #%d %s", repositoryName(pr), pr.Number, pr.Title))
+		fmt.Fprintf(&sb, "• <%s|%s>: %s\n", pr.URL, title, notificationText(notification))
+	}
+	return sb.String()
+}
+
+// Update the message announcing a pull request to show its current state, in the
+// layout of opened events. Once it's closed without being merged, the message is
+// struck through, or deleted if ClosedMessage is ClosedDelete. A message which was
+// already deleted is left alone. Returns the channel and timestamp of the message.
+func (slack *Slack) UpdatePullRequestMessage(
+	channelID string,
+	ts string,
+	pr *pr_gh.PullRequest,
+) (string, string, error) {
+	var err error
+	if pr.State == pr_gh.StateClosed && slack.ClosedMessage == ClosedDelete {
+		_, _, err = slack.Client.DeleteMessage(channelID, ts)
+	} else {
+		attachment := PullRequestAttachment(pr, slack.Layouts.For(lifecycle.Opened), time.Now())
+		_, _, _, err = slack.Client.UpdateMessage(channelID, ts,
+			slack_go.MsgOptionText(statusMessage(pr), false),
+			slack_go.MsgOptionAttachments(*attachment),
+		)
+	}
+
+	if err != nil && err.Error() == "message_not_found" {
+		err = nil
+	}
+	return channelID, ts, err
 }
 
-func (slack *Slack) SendPullRequestMessages(prs []*pr_gh.PullRequest) {
-	for _, pr := range prs {
-		slack.SendPullRequestMessage(pr)
+// Text of the message announcing a pull request, once it's updated to show its state.
+func statusMessage(pr *pr_gh.PullRequest) string {
+	switch {
+	case pr.State == pr_gh.StateMerged:
+		return eventMessages[lifecycle.Merged]
+	case pr.State == pr_gh.StateClosed:
+		return fmt.Sprintf("~%s~", eventMessages[lifecycle.Closed])
+	case pr.Draft:
+		return eventMessages[lifecycle.ConvertedToDraft]
+	case pr.ReviewDecision == pr_gh.ReviewApproved:
+		return eventMessages[lifecycle.Approved]
+	case pr.ReviewDecision == pr_gh.ReviewChangesRequested:
+		return eventMessages[lifecycle.ChangesRequested]
+	}
+	return eventMessages[lifecycle.Opened]
+}
+
+// Get the channel to post in for a destination. Users are sent direct messages, so
+// the conversation with them is opened first.
+func (slack *Slack) resolveDestination(destination string) (string, error) {
+	if !isUser(destination) {
+		return destination, nil
+	}
+
+	channel, _, _, err := slack.Client.OpenConversation(&slack_go.OpenConversationParameters{
+		Users: []string{destination},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to open direct message: %w", err)
+	}
+	return channel.ID, nil
+}
+
+// Whether a destination is a user, rather than a channel.
+func isUser(destination string) bool {
+	return strings.HasPrefix(destination, "U") || strings.HasPrefix(destination, "W")
+}
+
+// Text of the message sent about a review, to the author of the pull request, or in
+// its thread otherwise. (ex: "octocat approved your pull request. <url|View review>")
+func reviewMessage(review *pr_gh.Review, author bool) string {
+	action := "reviewed"
+	switch review.State {
+	case pr_gh.ReviewApproved:
+		action = "approved"
+	case pr_gh.ReviewChangesRequested:
+		action = "requested changes on"
+	}
+	whose := "this"
+	if author {
+		whose = "your"
 	}
+	return fmt.Sprintf("%s %s %s pull request. <%s|View review>", escaper.Replace(review.Reviewer), action, whose, review.URL)
 }
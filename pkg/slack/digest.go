@@ -0,0 +1,116 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	slack_go "github.com/slack-go/slack"
+)
+
+// Characters which have to be escaped in Slack's message formatting.
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Format a digest of pull requests waiting for review, grouped by repository. The
+// pull requests should be sorted oldest first, and repositories are listed in order
+// of their oldest pull request.
+func FormatDigest(prs []*pr_gh.PullRequest, now time.Time) string {
+	if len(prs) == 0 {
+		return "No pull requests are waiting for review."
+	}
+	return formatPullRequestList("Pull requests waiting for review", prs, now)
+}
+
+// Format a user's review queue, like a digest.
+func FormatReviewQueue(prs []*pr_gh.PullRequest, now time.Time) string {
+	if len(prs) == 0 {
+		return "Nothing is waiting for your review."
+	}
+	return formatPullRequestList("Waiting for your review", prs, now)
+}
+
+func formatPullRequestList(title string, prs []*pr_gh.PullRequest, now time.Time) string {
+	var repositories []string
+	groups := make(map[string][]*pr_gh.PullRequest)
+	for _, pr := range prs {
+		if _, ok := groups[pr.Repository]; !ok {
+			repositories = append(repositories, pr.Repository)
+		}
+		groups[pr.Repository] = append(groups[pr.Repository], pr)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%s* (%d)\n", title, len(prs))
+	for _, repository := range repositories {
+		fmt.Fprintf(&sb, "\n*%s*\n", escaper.Replace(repository))
+		for _, pr := range groups[repository] {
+			sb.WriteString(formatDigestLine(pr, now))
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// Format a pull request as a line of a digest.
+// (ex: "• <url|#12 Fix login> by octocat, 3d old, +120 −4 in 5 files, Review required")
+func formatDigestLine(pr *pr_gh.PullRequest, now time.Time) string {
+	details := []string{
+		fmt.Sprintf("by %s", escaper.Replace(pr.Creator)),
+		fmt.Sprintf("%s old", FormatAge(now.Sub(pr.Created))),
+	}
+	if size, ok := sizeText(pr); ok {
+		details = append(details, size)
+	}
+	review := pr.ReviewDecision
+	if review == "" {
+		review = "No review required"
+	}
+	details = append(details, review)
+
+	title := escaper.Replace(fmt.Sprintf("#%d %s", pr.Number, pr.Title))
+	return fmt.Sprintf("• <%s|%s> %s", pr.URL, title, strings.Join(details, ", "))
+}
+
+// Format how long ago something happened, in the largest whole unit. (ex: "3d", "5h")
+func FormatAge(age time.Duration) string {
+	switch {
+	case age >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(age/(24*time.Hour)))
+	case age >= time.Hour:
+		return fmt.Sprintf("%dh", int(age/time.Hour))
+	default:
+		return fmt.Sprintf("%dm", int(age/time.Minute))
+	}
+}
+
+// Post a digest of pull requests waiting for review in a channel.
+func (slack *Slack) SendDigest(channelID string, prs []*pr_gh.PullRequest, now time.Time) error {
+	_, _, err := slack.PostMessage(channelID, FormatDigest(prs, now), nil)
+	return err
+}
+
+// Send a user their review queue as a direct message, or update the message at ts in
+// channelID instead, if it's set. Returns the channel and timestamp of the message.
+func (slack *Slack) SendReviewQueue(
+	userID string,
+	channelID string,
+	ts string,
+	prs []*pr_gh.PullRequest,
+	now time.Time,
+) (string, string, error) {
+	text := FormatReviewQueue(prs, now)
+	if ts != "" {
+		_, _, _, err := slack.Client.UpdateMessage(channelID, ts, slack_go.MsgOptionText(text, false))
+		if err == nil || err.Error() != "message_not_found" {
+			return channelID, ts, err
+		}
+		// The user deleted it, so a new one is posted.
+	}
+
+	channelID, err := slack.resolveDestination(userID)
+	if err != nil {
+		return "", "", err
+	}
+	return slack.PostMessage(channelID, text, nil)
+}
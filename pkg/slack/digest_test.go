@@ -0,0 +1,62 @@
+package slack
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	pr_gh "github.com/ooojustin/pr-puller/pkg/github"
+	"github.com/ooojustin/pr-puller/pkg/lifecycle"
+)
+
+func TestFormatDigest(t *testing.T) {
+	now := time.Date(2022, 7, 4, 9, 0, 0, 0, time.UTC)
+	prs := []*pr_gh.PullRequest{
+		{Repository: "api", Number: 7, Title: "Fix <login>", URL: "https://github.com/org/api/pull/7", Creator: "octocat",
+			Created: now.Add(-72 * time.Hour), ReviewDecision: pr_gh.ReviewRequired, Additions: 120, Deletions: 4, ChangedFiles: 5, DetailsLoaded: now},
+		{Repository: "web", Number: 3, Title: "Dark mode", URL: "https://github.com/org/web/pull/3", Creator: "hubot",
+			Created: now.Add(-5 * time.Hour)},
+		{Repository: "api", Number: 9, Title: "Add search", URL: "https://github.com/org/api/pull/9", Creator: "octocat",
+			Created: now.Add(-20 * time.Minute), ReviewDecision: pr_gh.ReviewRequired, Additions: 8, ChangedFiles: 1},
+	}
+
+	want := strings.Join([]string{
+		"*Pull requests waiting for review* (3)",
+		"",
+		"*api*",
+		"• <https://github.com/org/api/pull/7|#7 Fix &lt;login&gt;> by octocat, 3d old, +120 −4 in 5 files, Review required",
+		"• <https://github.com/org/api/pull/9|#9 Add search> by octocat, 20m old, Review required",
+		"",
+		"*web*",
+		"• <https://github.com/org/web/pull/3|#3 Dark mode> by hubot, 5h old, No review required",
+		"",
+	}, "\n")
+	if got := FormatDigest(prs, now); got != want {
+		t.Errorf("FormatDigest() =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := FormatDigest(nil, now); got != "No pull requests are waiting for review." {
+		t.Errorf("FormatDigest(nil) = %q", got)
+	}
+}
+
+func TestFormatBatch(t *testing.T) {
+	prs := []*pr_gh.PullRequest{
+		{Organization: "org", Repository: "api", Number: 7, Title: "Fix <login>", URL: "https://github.com/org/api/pull/7"},
+		{Organization: "org", Repository: "web", Number: 3, Title: "Dark mode", URL: "https://github.com/org/web/pull/3"},
+	}
+	notifications := []*Notification{
+		{Destination: "C123", Event: lifecycle.Opened, Users: []string{"U1"}},
+		{Destination: "C123", Event: lifecycle.Merged},
+	}
+
+	want := strings.Join([]string{
+		"*2 notifications were held during quiet hours*",
+		"• <https://github.com/org/api/pull/7|org/api #7 Fix &lt;login&gt;>: <@U1> A pull request is ready to be reviewed.",
+		"• <https://github.com/org/web/pull/3|org/web #3 Dark mode>: A pull request was merged.",
+		"",
+	}, "\n")
+	if got := FormatBatch(notifications, prs); got != want {
+		t.Errorf("FormatBatch() =\n%s\nwant\n%s", got, want)
+	}
+}
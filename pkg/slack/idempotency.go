@@ -0,0 +1,112 @@
+package slack
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	slack_go "github.com/slack-go/slack"
+)
+
+// Type of the metadata attached to messages which carry an idempotency key.
+const idempotencyEventType string = "pr_slacker_notification"
+
+// How many recent messages are searched for an idempotency key, per page and in total.
+const (
+	idempotencyPageSize int = 100
+	idempotencyMaxPages int = 5
+)
+
+// Metadata which identifies a message by its idempotency key.
+func idempotencyMetadata(key string, pr_uid string) slack_go.MsgOption {
+	return slack_go.MsgOptionMetadata(slack_go.SlackMetadata{
+		EventType: idempotencyEventType,
+		EventPayload: map[string]interface{}{
+			"idempotency_key": key,
+			"pr_uid":          pr_uid,
+		},
+	})
+}
+
+// Search the messages posted in a channel (or in a thread, if threadTS is set) since
+// the given time for one which carries the idempotency key, returning its timestamp
+// if it's found.
+func (slack *Slack) FindMessage(channelID string, threadTS string, key string, since time.Time) (string, bool, error) {
+	if threadTS != "" {
+		return slack.findReply(channelID, threadTS, key, since)
+	}
+
+	params := &slack_go.GetConversationHistoryParameters{
+		ChannelID:          channelID,
+		Limit:              idempotencyPageSize,
+		IncludeAllMetadata: true,
+		Oldest:             oldestTimestamp(since),
+	}
+
+	for page := 0; page < idempotencyMaxPages; page++ {
+		history, err := slack.Client.GetConversationHistory(params)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read channel history: %w", err)
+		}
+
+		if ts, found := findIdempotencyKey(history.Messages, key); found {
+			return ts, true, nil
+		}
+
+		if !history.HasMore || history.ResponseMetaData.NextCursor == "" {
+			break
+		}
+		params.Cursor = history.ResponseMetaData.NextCursor
+	}
+
+	return "", false, nil
+}
+
+// Search the replies in a thread for one which carries the idempotency key.
+func (slack *Slack) findReply(channelID string, threadTS string, key string, since time.Time) (string, bool, error) {
+	params := &slack_go.GetConversationRepliesParameters{
+		ChannelID:          channelID,
+		Timestamp:          threadTS,
+		Limit:              idempotencyPageSize,
+		IncludeAllMetadata: true,
+		Oldest:             oldestTimestamp(since),
+	}
+
+	for page := 0; page < idempotencyMaxPages; page++ {
+		replies, hasMore, cursor, err := slack.Client.GetConversationReplies(params)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read thread replies: %w", err)
+		}
+
+		if ts, found := findIdempotencyKey(replies, key); found {
+			return ts, true, nil
+		}
+
+		if !hasMore || cursor == "" {
+			break
+		}
+		params.Cursor = cursor
+	}
+
+	return "", false, nil
+}
+
+func findIdempotencyKey(messages []slack_go.Message, key string) (string, bool) {
+	for _, msg := range messages {
+		if msg.Metadata.EventType != idempotencyEventType {
+			continue
+		}
+		if msg.Metadata.EventPayload["idempotency_key"] == key {
+			return msg.Timestamp, true
+		}
+	}
+	return "", false
+}
+
+// Slack timestamps are in unix seconds. Allow for clock skew between us and Slack.
+func oldestTimestamp(since time.Time) string {
+	if since.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(since.Add(-time.Minute).Unix(), 10)
+}
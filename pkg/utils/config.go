@@ -3,19 +3,74 @@ package utils
 import (
 	"encoding/json"
 	"io/ioutil"
+	"os"
 )
 
 type Config struct {
-	GithubOrganization string `json:"github_organization"`
-	GithubManualLogin  bool   `json:"github_manual_login"`
-	GithubUsername     string `json:"github_username"`
-	GithubPassword     string `json:"github_password"`
-	GithubSaveCookies  bool   `json:"github_save_cookies"`
-	AwsAccessKeyID     string `json:"aws_access_key_id"`
-	AwsAccessKeySecret string `json:"aws_access_key_secret"`
-	AwsRegion          string `json:"aws_region"`
-	SlackOauthToken    string `json:"slack_oauth_token"`
-	SlackChannelID     string `json:"slack_channel_id"`
+	GithubOrganization    string                     `json:"github_organization"`
+	GithubManualLogin     bool                       `json:"github_manual_login"`
+	GithubUsername        string                     `json:"github_username"`
+	GithubPassword        string                     `json:"github_password"`
+	GithubSaveCookies     bool                       `json:"github_save_cookies"`
+	AwsAccessKeyID        string                     `json:"aws_access_key_id"`
+	AwsAccessKeySecret    string                     `json:"aws_access_key_secret"`
+	AwsRegion             string                     `json:"aws_region"`
+	AwsCredentials        string                     `json:"aws_credentials"`
+	AwsProfile            string                     `json:"aws_profile"`
+	DynamoDBEndpoint      string                     `json:"dynamodb_endpoint"`
+	SlackOauthToken       string                     `json:"slack_oauth_token"`
+	SlackChannelID        string                     `json:"slack_channel_id"`
+	SlackUsers            map[string]string          `json:"slack_users"`
+	AuthorOptOut          []string                   `json:"author_opt_out"`
+	NotificationRules     []NotificationRule         `json:"notification_rules"`
+	ReminderSchedules     []ReminderSchedule         `json:"reminder_schedules"`
+	NotificationSchedules map[string]BusinessHours   `json:"notification_schedules"`
+	Digests               []Digest                   `json:"digests"`
+	ReviewQueues          map[string]ReviewQueue     `json:"review_queues"`
+	GithubTeams           map[string][]string        `json:"github_teams"`
+	StormProtection       StormProtection            `json:"storm_protection"`
+	SeedMode              string                     `json:"seed_mode"`
+	MessageLayouts        map[string]string          `json:"message_layouts"`
+	ClosedMessage         string                     `json:"closed_message"`
+	DetailsRefreshMinutes int                        `json:"details_refresh_minutes"`
+	DatabaseBackend       string                     `json:"database_backend"`
+	DatabaseProfile       string                     `json:"database_profile"`
+	DatabaseProfiles      map[string]DatabaseProfile `json:"database_profiles"`
+	SQLitePath            string                     `json:"sqlite_path"`
+	RetentionDays         int                        `json:"retention_days"`
+	LeaderLeaseSeconds    int                        `json:"leader_lease_seconds"`
+	MetricsAddr           string                     `json:"metrics_addr"`
+}
+
+// DatabaseProfile isolates the records of one environment from others which share
+// the same database. Tables which aren't named explicitly use their default name,
+// with TablePrefix prepended. KeyPrefix is prepended to every key that's stored.
+type DatabaseProfile struct {
+	TablePrefix       string `json:"table_prefix"`
+	KeyPrefix         string `json:"key_prefix"`
+	PullRequestsTable string `json:"pull_requests_table"`
+	HistoryTable      string `json:"history_table"`
+	LeasesTable       string `json:"leases_table"`
+	MetaTable         string `json:"meta_table"`
+	MessagesTable     string `json:"messages_table"`
+	OutboxTable       string `json:"outbox_table"`
+}
+
+// Get the selected database profile. The PR_SLACKER_PROFILE environment variable
+// takes precedence over the profile selected in config. If no profile is selected,
+// a profile with default settings is returned.
+func (cfg *Config) GetDatabaseProfile() (DatabaseProfile, bool) {
+	name := cfg.DatabaseProfile
+	if env := os.Getenv("PR_SLACKER_PROFILE"); env != "" {
+		name = env
+	}
+
+	if name == "" {
+		return DatabaseProfile{}, true
+	}
+
+	profile, ok := cfg.DatabaseProfiles[name]
+	return profile, ok
 }
 
 func GetConfig() (*Config, bool) {
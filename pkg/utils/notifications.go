@@ -0,0 +1,110 @@
+package utils
+
+// Types of actions a notification rule can take.
+const (
+	ActionPost        string = "post"
+	ActionDMReviewers string = "dm_reviewers"
+	ActionDMAuthor    string = "dm_author"
+	ActionMention     string = "mention"
+	ActionSuppress    string = "suppress"
+)
+
+// NotificationRule decides what happens when a pull request has a lifecycle event.
+// Every condition which is set must match for the rule to match. Conditions on
+// details which aren't known for a pull request, such as its size, don't match.
+// Lists match if any of their entries match, and repositories and base branches
+// can use glob patterns. (ex: "web-*")
+type NotificationRule struct {
+	Name            string       `json:"name"`
+	Events          []string     `json:"events"`
+	Repositories    []string     `json:"repositories"`
+	Labels          []string     `json:"labels"`
+	ExcludeLabels   []string     `json:"exclude_labels"`
+	Authors         []string     `json:"authors"`
+	BaseBranches    []string     `json:"base_branches"`
+	MinSize         int          `json:"min_size"`
+	MaxSize         int          `json:"max_size"`
+	CIStatuses      []string     `json:"ci_statuses"`
+	Draft           *bool        `json:"draft"`
+	ReviewDecisions []string     `json:"review_decisions"`
+	Actions         []RuleAction `json:"actions"`
+
+	// Whether replies about the events it matches, in the thread of a pull request's
+	// message, are also broadcast to the channel.
+	Important bool `json:"important"`
+}
+
+// RuleAction is something a notification rule does when it fires. Posts and mentions
+// go to Channel, or slack_channel_id if it's empty.
+type RuleAction struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	Group   string `json:"group"` // Slack user group ID to mention
+}
+
+// Actions a reminder step can take.
+const (
+	ReminderThreadReply      string = "thread_reply"
+	ReminderMentionReviewers string = "mention_reviewers"
+	ReminderEscalate         string = "escalate"
+)
+
+// ReminderSchedule reminds people about pull requests which are waiting for review.
+// The first schedule whose repositories and labels match a pull request applies to it.
+// Time only counts during business hours, and stops once the pull request is reviewed.
+type ReminderSchedule struct {
+	Name          string         `json:"name"`
+	Repositories  []string       `json:"repositories"`
+	Labels        []string       `json:"labels"`
+	BusinessHours BusinessHours  `json:"business_hours"`
+	Steps         []ReminderStep `json:"steps"`
+}
+
+// ReminderStep is taken once a pull request has waited for review for AfterHours
+// business hours. Replies and mentions go in the thread of the original message in
+// Channel, and escalations are posted in Channel. Channel defaults to slack_channel_id.
+type ReminderStep struct {
+	AfterHours float64 `json:"after_hours"`
+	Action     string  `json:"action"`
+	Channel    string  `json:"channel"`
+}
+
+// BusinessHours are the hours of each working day, in a timezone. (ex: "America/New_York")
+// By default, they're 09:00 to 17:00, Monday to Friday, in the local timezone.
+// Holidays are loaded from ICS or YAML files, and aren't working days.
+type BusinessHours struct {
+	Timezone string   `json:"timezone"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Days     []string `json:"days"`     // (ex: "mon", "tue")
+	Holidays []string `json:"holidays"` // paths of holiday files
+}
+
+// Digest is a summary of the pull requests waiting for review, posted in Channel
+// whenever Schedule fires. Channel defaults to slack_channel_id. If Repositories (names
+// or glob patterns) are given, only pull requests in them are listed.
+type Digest struct {
+	Name         string   `json:"name"`
+	Channel      string   `json:"channel"`
+	Schedule     string   `json:"schedule"` // cron expression (ex: "0 9 * * 1-5")
+	Timezone     string   `json:"timezone"`
+	Repositories []string `json:"repositories"`
+}
+
+// ReviewQueue sends a Github user, who's listed in slack_users, a direct message of the
+// pull requests they're requested to review whenever Schedule fires. The first message
+// of each day is updated in place for the rest of the day.
+type ReviewQueue struct {
+	Schedule string `json:"schedule"` // cron expression (ex: "0 9,13 * * 1-5")
+	Timezone string `json:"timezone"`
+}
+
+// StormProtection trips a circuit breaker, which holds every notification and alerts
+// AdminChannel, when more than MaxPerCycle notifications are due at once, or more than
+// MaxPerHour would be sent within an hour. Zero disables a limit. AdminChannel defaults
+// to slack_channel_id.
+type StormProtection struct {
+	MaxPerCycle  int    `json:"max_per_cycle"`
+	MaxPerHour   int    `json:"max_per_hour"`
+	AdminChannel string `json:"admin_channel"`
+}